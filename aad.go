@@ -0,0 +1,187 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// AAD mints Azure AD tokens for a resource with a chain of credentials
+type AAD struct {
+	Resource   string // Resource or scope, such as "api://myapi" or "https://vault.azure.net/.default"
+	Credential string // "chain", "env", "managedidentity", or "azcli"
+
+	client *http.Client
+}
+
+// Credentials tried, in order, by the "chain" credential
+var aadChain = []string{"env", "managedidentity", "azcli"}
+
+// Scope for the v2 endpoint from a resource
+func (a *AAD) scope() string {
+	if strings.HasSuffix(a.Resource, "/.default") {
+		return a.Resource
+	}
+	return strings.TrimSuffix(a.Resource, "/") + "/.default"
+}
+
+// Resource for the v1-style endpoints from a scope
+func (a *AAD) resource() string {
+	return strings.TrimSuffix(a.Resource, "/.default")
+}
+
+// Token mints a token with the configured credential
+func (a *AAD) Token() (string, error) {
+	if a.client == nil {
+		a.client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	creds := []string{a.Credential}
+	if a.Credential == "" || a.Credential == "chain" {
+		creds = aadChain
+	}
+
+	var failures []string
+	for _, cred := range creds {
+		var token string
+		var err error
+
+		switch cred {
+		case "env":
+			token, err = a.clientSecret()
+		case "managedidentity":
+			token, err = a.managedIdentity()
+		case "azcli":
+			token, err = a.azCLI()
+		default:
+			return "", errors.New("unknown AAD credential → " + cred)
+		}
+
+		if err == nil {
+			chat("aad: token acquired with " + cred + " credential\n")
+			return token, nil
+		}
+		failures = append(failures, cred+": "+err.Error())
+	}
+
+	return "", errors.New("no AAD credential succeeded → " + strings.Join(failures, "; "))
+}
+
+// Client secret from AZURE_TENANT_ID, AZURE_CLIENT_ID, and AZURE_CLIENT_SECRET
+func (a *AAD) clientSecret() (string, error) {
+	tenant, id, secret := os.Getenv("AZURE_TENANT_ID"), os.Getenv("AZURE_CLIENT_ID"), os.Getenv("AZURE_CLIENT_SECRET")
+	if tenant == "" || id == "" || secret == "" {
+		return "", errors.New("AZURE_TENANT_ID, AZURE_CLIENT_ID, and AZURE_CLIENT_SECRET not set")
+	}
+
+	authority := os.Getenv("AZURE_AUTHORITY_HOST")
+	if authority == "" {
+		authority = "https://login.microsoftonline.com"
+	}
+
+	resp, err := a.client.PostForm(strings.TrimSuffix(authority, "/")+"/"+tenant+"/oauth2/v2.0/token", url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {id},
+		"client_secret": {secret},
+		"scope":         {a.scope()},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return accessToken(resp)
+}
+
+// Managed identity from App Service or the instance metadata service
+// AZURE_CLIENT_ID selects a user-assigned identity
+func (a *AAD) managedIdentity() (string, error) {
+	q := url.Values{"resource": {a.resource()}}
+	if id := os.Getenv("AZURE_CLIENT_ID"); id != "" {
+		q.Set("client_id", id)
+	}
+
+	var req *http.Request
+	var err error
+	if endpoint, secret := os.Getenv("IDENTITY_ENDPOINT"), os.Getenv("IDENTITY_HEADER"); endpoint != "" && secret != "" {
+		// App Service, Functions, and friends
+		q.Set("api-version", "2019-08-01")
+		req, err = http.NewRequest("GET", endpoint+"?"+q.Encode(), nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("X-IDENTITY-HEADER", secret)
+	} else {
+		// Virtual machines and scale sets
+		q.Set("api-version", "2018-02-01")
+		req, err = http.NewRequest("GET", "http://169.254.169.254/metadata/identity/oauth2/token?"+q.Encode(), nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Metadata", "true")
+	}
+
+	// The metadata service is local, don't wait long if it's absent
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+
+	return accessToken(resp)
+}
+
+// The signed in Azure CLI user
+func (a *AAD) azCLI() (string, error) {
+	out, err := exec.Command("az", "account", "get-access-token", "--resource", a.resource(), "--output", "json").Output()
+	if err != nil {
+		var exit *exec.ExitError
+		if errors.As(err, &exit) {
+			return "", fmt.Errorf("%v → %s", err, strings.TrimSpace(string(exit.Stderr)))
+		}
+		return "", err
+	}
+
+	var t struct {
+		AccessToken string `json:"accessToken"`
+	}
+	err = json.Unmarshal(out, &t)
+	if err != nil {
+		return "", err
+	}
+	if t.AccessToken == "" {
+		return "", errors.New("no access token from az")
+	}
+
+	return t.AccessToken, nil
+}
+
+// Read the access token from a token endpoint response
+func accessToken(resp *http.Response) (string, error) {
+	defer resp.Body.Close()
+
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	var t Token
+	json.Unmarshal(buf, &t)
+	if resp.StatusCode != 200 || t.AccessToken == "" {
+		if t.Error != "" {
+			return "", errors.New(t.Error + ": " + t.Description)
+		}
+		return "", errors.New("token endpoint responded " + resp.Status)
+	}
+
+	return t.AccessToken, nil
+}
@@ -0,0 +1,168 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Azure DevOps REST API version we speak
+const adoAPIVersion = "6.0"
+
+// ADO is a client for the Azure DevOps test REST API
+// Defaults are taken from the pipeline's predefined variables
+type ADO struct {
+	Collection string // SYSTEM_COLLECTIONURI such as https://dev.azure.com/org/
+	Project    string // SYSTEM_TEAMPROJECT
+	Token      string // SYSTEM_ACCESSTOKEN (bearer) or a PAT (basic)
+
+	client *http.Client
+}
+
+// Build an ADO client from the pipeline environment
+func adoFromEnv() (*ADO, error) {
+	a := &ADO{
+		Collection: os.Getenv("SYSTEM_COLLECTIONURI"),
+		Project:    os.Getenv("SYSTEM_TEAMPROJECT"),
+		Token:      os.Getenv("SYSTEM_ACCESSTOKEN"),
+		client:     &http.Client{Timeout: 30 * time.Second},
+	}
+
+	if a.Collection == "" || a.Project == "" || a.Token == "" {
+		return nil, errors.New("SYSTEM_COLLECTIONURI, SYSTEM_TEAMPROJECT, and SYSTEM_ACCESSTOKEN must be set")
+	}
+
+	return a, nil
+}
+
+// Call the test API, decoding the JSON response into out if non-nil
+func (a *ADO) call(method, path string, in, out interface{}) error {
+	buf, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	url := strings.TrimSuffix(a.Collection, "/") + "/" + a.Project + "/_apis/test/" + path + "?api-version=" + adoAPIVersion
+	req, err := http.NewRequest(method, url, bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// Pipeline tokens are bearer tokens, personal access tokens are basic
+	if strings.Count(a.Token, ".") == 2 {
+		req.Header.Set("Authorization", "Bearer "+a.Token)
+	} else {
+		req.SetBasicAuth("", a.Token)
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s %s → %s %s", method, path, resp.Status, redact(string(body)))
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return json.Unmarshal(body, out)
+}
+
+// Publish results as a test run with one result per operation and the JSON report attached
+func (a *ADO) Publish(name string, requests []*Request, missed map[string]uint64, sus, ok []Set) (int, error) {
+	// Create the run
+	var run struct {
+		ID int `json:"id"`
+	}
+	err := a.call("POST", "runs", map[string]interface{}{
+		"name":      name,
+		"automated": true,
+		"state":     "InProgress",
+	}, &run)
+	if err != nil {
+		return 0, err
+	}
+
+	// One result per operation
+	type Result struct {
+		TestCaseTitle     string `json:"testCaseTitle"`
+		AutomatedTestName string `json:"automatedTestName"`
+		Outcome           string `json:"outcome"`
+		State             string `json:"state"`
+		DurationInMs      int64  `json:"durationInMs"`
+		ErrorMessage      string `json:"errorMessage,omitempty"`
+	}
+	var results []Result
+
+	result := func(set Set, outcome string) Result {
+		title := strings.ToUpper(set.Request.Request.Method) + " " + set.Request.URL.Path + set.Request.VariantSuffix()
+		r := Result{
+			TestCaseTitle:     title,
+			AutomatedTestName: title,
+			Outcome:           outcome,
+			State:             "Completed",
+			DurationInMs:      set.Response.Latency.Milliseconds(),
+		}
+		if op := set.Request.Method.OperationID; op != "" {
+			r.AutomatedTestName = op
+		}
+		if outcome == "Failed" {
+			r.ErrorMessage = fmt.Sprintf("Suspicious response code HTTP %d%s", set.Response.StatusCode, requestIDSuffix(set.Request))
+		}
+		return r
+	}
+
+	for _, bad := range sus {
+		results = append(results, result(bad, "Failed"))
+	}
+	for _, set := range ok {
+		results = append(results, result(set, "Passed"))
+	}
+
+	if len(results) > 0 {
+		err = a.call("POST", fmt.Sprintf("runs/%d/results", run.ID), results, nil)
+		if err != nil {
+			return run.ID, err
+		}
+	}
+
+	// Attach the full JSON report
+	var report bytes.Buffer
+	err = printJSON(&report, true, *full, requests, missed, sus, ok)
+	if err != nil {
+		return run.ID, err
+	}
+
+	err = a.call("POST", fmt.Sprintf("runs/%d/attachments", run.ID), map[string]string{
+		"stream":         base64.StdEncoding.EncodeToString(report.Bytes()),
+		"fileName":       "generator.json",
+		"comment":        "Generator JSON report",
+		"attachmentType": "GeneralAttachment",
+	}, nil)
+	if err != nil {
+		return run.ID, err
+	}
+
+	// Finish the run
+	err = a.call("PATCH", fmt.Sprintf("runs/%d", run.ID), map[string]string{
+		"state": "Completed",
+	}, nil)
+
+	return run.ID, err
+}
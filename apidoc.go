@@ -0,0 +1,352 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Pointer to the service's own description, for callers who got something wrong
+const seeDocs = "See /docs, or /openapi.json, for the options."
+
+// Handle '/openapi.json' requests with the service's own specification
+// The server is whichever one was asked, so the document can be fed straight back to the generator
+func specHandler(w http.ResponseWriter, r *http.Request) {
+	var doc map[string]interface{}
+	err := json.Unmarshal([]byte(serviceSpec), &doc)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "Error: could not read specification → "+err.Error()+"\n")
+		return
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	doc["servers"] = []map[string]string{{"url": scheme + "://" + r.Host}}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	enc.Encode(doc)
+}
+
+// Handle '/docs' requests with a Swagger UI for the service's specification
+// The UI itself is loaded from a CDN by the browser
+func docsHandler(w http.ResponseWriter, r *http.Request) {
+	page := `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Generator API</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+<script>
+window.ui = SwaggerUIBundle({url: "/openapi.json", dom_id: "#swagger-ui"});
+</script>
+</body>
+</html>
+`
+	w.Header().Set("Content-Type", "text/html")
+	fmt.Fprint(w, page)
+}
+
+// The service's OpenAPI specification, within what the generator itself can parse
+// Response codes are numeric and defaults are strings for that reason
+const serviceSpec = `{
+	"openapi": "3.0.3",
+	"info": {
+		"title": "Generator API",
+		"version": "1.0.0",
+		"description": "Generate HTTP requests from an OpenAPI specification and a db of identifiers, replay them, and judge the responses."
+	},
+	"security": [{"apiKey": []}, {"bearer": []}],
+	"paths": {
+		"/v1/generator": {
+			"post": {
+				"operationId": "generate",
+				"summary": "Generate, and optionally replay, requests and answer with the results",
+				"requestBody": {
+					"required": true,
+					"content": {
+						"application/json": {"schema": {"$ref": "#/components/schemas/JobOptions"}},
+						"multipart/form-data": {"schema": {"$ref": "#/components/schemas/Upload"}}
+					}
+				},
+				"responses": {
+					"200": {
+						"description": "Results, or the built requests if noreplay is set",
+						"content": {
+							"application/json": {"schema": {"$ref": "#/components/schemas/Results"}},
+							"application/x-ndjson": {"schema": {"type": "string"}},
+							"application/xml": {"schema": {"type": "string"}},
+							"text/plain": {"schema": {"type": "string"}}
+						}
+					},
+					"400": {"description": "The options are wrong or a document couldn't be fetched", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+					"401": {"description": "The caller isn't authenticated", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+					"403": {"description": "A document, target, or callback is on a host the server may not connect to", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+					"406": {"description": "Results can't be given in any format Accept allows", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+					"413": {"description": "The body is larger than the server's -maxbody", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+					"429": {"description": "The caller is over its rate limit, or the queue is full, try again after Retry-After", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+					"502": {"description": "A built request couldn't be replayed", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+					"503": {"description": "The server is shutting down", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+					"504": {"description": "A document wasn't fetched within the server's -fetchtimeout", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+				}
+			}
+		},
+		"/v1/jobs": {
+			"post": {
+				"operationId": "submitJob",
+				"summary": "Submit a job to generate, and optionally replay, requests in the background",
+				"requestBody": {
+					"required": true,
+					"content": {
+						"application/json": {"schema": {"$ref": "#/components/schemas/JobOptions"}},
+						"multipart/form-data": {"schema": {"$ref": "#/components/schemas/Upload"}}
+					}
+				},
+				"responses": {
+					"202": {"description": "The job was accepted, its Location is where to follow it", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Job"}}}},
+					"400": {"description": "The options are wrong", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+					"401": {"description": "The caller isn't authenticated", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+					"403": {"description": "A document, target, or callback is on a host the server may not connect to", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+					"429": {"description": "The caller is over its rate limit, or the queue is full, try again after Retry-After", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+					"503": {"description": "The server is shutting down", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+				}
+			}
+		},
+		"/v1/jobs/{id}": {
+			"get": {
+				"operationId": "getJob",
+				"summary": "Status of a job",
+				"parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "string"}}],
+				"responses": {
+					"200": {"description": "The job", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Job"}}}},
+					"401": {"description": "The caller isn't authenticated", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+					"404": {"description": "No such job, or it isn't the caller's", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+				}
+			},
+			"delete": {
+				"operationId": "cancelJob",
+				"summary": "Cancel a queued or running job, stopping its requests",
+				"parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "string"}}],
+				"responses": {
+					"202": {"description": "The job is being cancelled", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Job"}}}},
+					"401": {"description": "The caller isn't authenticated", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+					"404": {"description": "No such job, or it isn't the caller's", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+					"409": {"description": "The job has already finished", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+				}
+			}
+		},
+		"/v1/jobs/{id}/result": {
+			"get": {
+				"operationId": "getJobResult",
+				"summary": "Result of a finished job, as POST /v1/generator would answer",
+				"parameters": [
+					{"name": "id", "in": "path", "required": true, "schema": {"type": "string"}},
+					{"name": "download", "in": "query", "required": false, "schema": {"type": "boolean"}, "description": "Answer as a file to save"}
+				],
+				"responses": {
+					"200": {
+						"description": "Results",
+						"content": {
+							"application/json": {"schema": {"$ref": "#/components/schemas/Results"}},
+							"application/x-ndjson": {"schema": {"type": "string"}},
+							"application/xml": {"schema": {"type": "string"}},
+							"text/plain": {"schema": {"type": "string"}}
+						}
+					},
+					"202": {"description": "The job hasn't finished"},
+					"406": {"description": "Results can't be given in any format Accept allows", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+					"410": {"description": "The job was cancelled", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+					"401": {"description": "The caller isn't authenticated", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+					"404": {"description": "No such job, or it isn't the caller's", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+				}
+			}
+		},
+		"/v1/jobs/{id}/summary": {
+			"get": {
+				"operationId": "getJobSummary",
+				"summary": "What a finished job found, without its results, linking to them",
+				"parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "string"}}],
+				"responses": {
+					"200": {"description": "Summary", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ResultSummary"}}}},
+					"202": {"description": "The job hasn't finished"},
+					"410": {"description": "The job was cancelled", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+					"401": {"description": "The caller isn't authenticated", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+					"404": {"description": "No such job, it isn't the caller's, or it didn't replay", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+				}
+			}
+		},
+		"/v1/jobs/{id}/results": {
+			"get": {
+				"operationId": "getJobResults",
+				"summary": "A page of a finished job's results, in the order their requests were built",
+				"parameters": [
+					{"name": "id", "in": "path", "required": true, "schema": {"type": "string"}},
+					{"name": "offset", "in": "query", "required": false, "schema": {"type": "integer"}, "description": "Results to skip, 0 by default"},
+					{"name": "limit", "in": "query", "required": false, "schema": {"type": "integer", "maximum": 1000}, "description": "Results in the page, 100 by default"}
+				],
+				"responses": {
+					"200": {"description": "A page, the next linked in Link:", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ResultPage"}}}},
+					"202": {"description": "The job hasn't finished"},
+					"400": {"description": "Bad offset or limit", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+					"410": {"description": "The job was cancelled", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+					"401": {"description": "The caller isn't authenticated", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+					"404": {"description": "No such job, it isn't the caller's, or it didn't replay", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+				}
+			}
+		},
+		"/v1/jobs/{id}/events": {
+			"get": {
+				"operationId": "getJobEvents",
+				"summary": "Progress of a job as Server-Sent Events, resumed from Last-Event-ID",
+				"parameters": [
+					{"name": "id", "in": "path", "required": true, "schema": {"type": "string"}},
+					{"name": "Last-Event-ID", "in": "header", "required": false, "schema": {"type": "string"}}
+				],
+				"responses": {
+					"200": {"description": "A stream of built, replayed, verdict, done, and failed events", "content": {"text/event-stream": {"schema": {"type": "string"}}}},
+					"401": {"description": "The caller isn't authenticated", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+					"404": {"description": "No such job, or it isn't the caller's", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+				}
+			}
+		}
+	},
+	"components": {
+		"securitySchemes": {
+			"apiKey": {"type": "apiKey", "in": "header", "name": "X-API-Key"},
+			"bearer": {"type": "http", "scheme": "bearer"}
+		},
+		"schemas": {
+			"JobOptions": {
+				"type": "object",
+				"properties": {
+					"profile": {"type": "string", "description": "Name of a profile on the server to take options from"},
+					"cfgpath": {"type": "string", "description": "URL for the db, cfg ⊻ cfgpath"},
+					"cfg": {"type": "string", "description": "Literal db"},
+					"api": {"type": "string", "description": "URL for the OpenAPI specification"},
+					"auth": {"type": "string", "description": "Authorization: Bearer token, defaults to the server's -authfile"},
+					"cookie": {"type": "string", "description": "Cookie: header value for session cookies"},
+					"target": {"type": "string", "description": "Hostname to replay built requests to"},
+					"noauth": {"type": "boolean", "description": "Strip Authorization: and Cookie: headers"},
+					"noreplay": {"type": "boolean", "description": "Answer with the built requests rather than replay them"},
+					"ignoremethods": {"type": "array", "items": {"type": "string"}, "description": "HTTP methods to not build, such as PUT"},
+					"methods": {"type": "array", "items": {"type": "string"}, "description": "HTTP methods to build, such as GET, otherwise all"},
+					"limit": {"type": "integer", "description": "Most requests to build and replay, 0 for no limit"},
+					"sample": {"type": "string", "enum": ["first", "random"], "description": "Which requests a limit keeps"},
+					"shuffle": {"type": "integer", "format": "int64", "description": "Seed to shuffle the order requests are replayed in with, otherwise as built"},
+					"paths": {"type": "array", "items": {"type": "string"}, "description": "Path templates to build, regular expressions or globs, such as /users/.*"},
+					"ado": {"type": "boolean", "description": "Answer in ADO logging command format"},
+					"gha": {"type": "boolean", "description": "Answer in GitHub Actions workflow command format"},
+					"full": {"type": "boolean", "description": "Include complete requests and responses in results"},
+					"indent": {"type": "boolean", "description": "Indent results for humans"},
+					"format": {"type": "string", "enum": ["json", "jsonl", "junit", "summary", "ado", "gha"], "description": "Format of results, otherwise as per ado, gha, or Accept"},
+					"strict": {"type": "boolean", "description": "Fail if a value can't be filled"},
+					"allbodies": {"type": "boolean", "description": "Write a body for every request, not only those which require one"},
+					"proto": {"type": "string", "enum": ["http", "https"], "description": "Scheme to replay with"},
+					"sequence": {"type": "boolean", "description": "Build a request per enumerated value of multi-valued parameters, in step"},
+					"cartesian": {"type": "integer", "description": "Build up to this many requests per operation from the cross product of multi-valued parameters"},
+					"printreqs": {"type": "boolean", "description": "Log built requests on the server"},
+					"concurrency": {"type": "integer", "description": "Requests replayed at once, 1 to 32"},
+					"timeout": {"type": "string", "description": "Longest each replayed request may take, such as 30s"},
+					"rate": {"type": "number", "description": "Requests replayed per second"},
+					"bodylimit": {"type": "integer", "description": "Bytes of each response body kept in results"},
+					"deadline": {"type": "string", "description": "Longest the call or job may take, such as 10m"},
+					"callback": {"type": "string", "description": "URL to post the job to once it's finished, for jobs only"},
+					"callbackfull": {"type": "boolean", "description": "Include the job's result in the callback"}
+				}
+			},
+			"Error": {
+				"type": "object",
+				"properties": {
+					"error": {"$ref": "#/components/schemas/ErrorBody"}
+				}
+			},
+			"ErrorBody": {
+				"type": "object",
+				"properties": {
+					"status": {"type": "integer", "description": "HTTP status"},
+					"reason": {"type": "string", "enum": ["bad_request", "unauthorized", "not_found", "method_not_allowed", "conflict", "cancelled", "too_large", "too_many_requests", "internal", "replay_failed", "shutting_down", "error"], "description": "Stable reason for programs to act on"},
+					"message": {"type": "string", "description": "For humans, may change"}
+				}
+			},
+			"Upload": {
+				"type": "object",
+				"properties": {
+					"options": {"type": "string", "description": "JobOptions as JSON, without api and cfg"},
+					"api": {"type": "string", "format": "binary", "description": "The OpenAPI specification itself"},
+					"cfg": {"type": "string", "format": "binary", "description": "The db itself, its file name telling its format"}
+				}
+			},
+			"Job": {
+				"type": "object",
+				"properties": {
+					"id": {"type": "string"},
+					"status": {"type": "string", "enum": ["queued", "running", "done", "failed", "cancelled"]},
+					"created": {"type": "string", "format": "date-time"},
+					"started": {"type": "string", "format": "date-time"},
+					"finished": {"type": "string", "format": "date-time"},
+					"error": {"type": "string"}
+				}
+			},
+			"Results": {
+				"type": "object",
+				"properties": {
+					"Info": {"$ref": "#/components/schemas/Info"},
+					"Conformant": {"type": "array", "items": {"$ref": "#/components/schemas/Result"}},
+					"Suspicious": {"type": "array", "items": {"$ref": "#/components/schemas/Result"}}
+				}
+			},
+			"Info": {
+				"type": "object",
+				"properties": {
+					"Server": {"type": "string", "description": "Host targeted"},
+					"Missed": {"type": "object", "description": "Parameters which couldn't be filled, and how often"}
+				}
+			},
+			"Result": {
+				"type": "object",
+				"properties": {
+					"Method": {"type": "string"},
+					"HTTPCode": {"type": "integer"},
+					"Path": {"type": "string"},
+					"Variant": {"type": "string"},
+					"Body": {"type": "string"},
+					"Verdict": {"type": "string", "enum": ["suspicious", "conformant"]},
+					"Exchange": {"type": "object", "description": "The complete request and response, if full is set"}
+				}
+			},
+			"ResultSummary": {
+				"type": "object",
+				"properties": {
+					"built": {"type": "integer", "description": "Requests built"},
+					"possible": {"type": "integer", "description": "Requests which could have been built"},
+					"suspicious": {"type": "integer"},
+					"conformant": {"type": "integer"},
+					"missed": {"type": "object", "description": "Parameters which couldn't be filled, and how often"},
+					"results": {"type": "string", "description": "First page of results"},
+					"download": {"type": "string", "description": "All of the results, as a file"}
+				}
+			},
+			"ResultPage": {
+				"type": "object",
+				"properties": {
+					"results": {"type": "array", "items": {"$ref": "#/components/schemas/Result"}},
+					"offset": {"type": "integer"},
+					"limit": {"type": "integer"},
+					"total": {"type": "integer", "description": "Results in every page"},
+					"next": {"type": "string", "description": "The following page, if any"}
+				}
+			}
+		}
+	}
+}`
@@ -0,0 +1,187 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default Application Insights ingestion endpoint
+const aiEndpoint = "https://dc.services.visualstudio.com"
+
+// Most telemetry items sent per call
+const aiBatch = 500
+
+// Envelope is an Application Insights telemetry item
+type Envelope struct {
+	Name string            `json:"name"`
+	Time string            `json:"time"`
+	IKey string            `json:"iKey"`
+	Tags map[string]string `json:"tags"`
+	Data struct {
+		BaseType string         `json:"baseType"`
+		BaseData DependencyData `json:"baseData"`
+	} `json:"data"`
+}
+
+// DependencyData is a call we made, as per Application Insights
+type DependencyData struct {
+	Ver        int               `json:"ver"`
+	Name       string            `json:"name"`
+	ID         string            `json:"id"`
+	Data       string            `json:"data"`
+	Duration   string            `json:"duration"`
+	ResultCode string            `json:"resultCode"`
+	Success    bool              `json:"success"`
+	Type       string            `json:"type"`
+	Target     string            `json:"target"`
+	Properties map[string]string `json:"properties"`
+}
+
+// Parse an Application Insights connection string into a key and endpoint
+// A bare instrumentation key is accepted too
+func parseConnectionString(s string) (string, string, error) {
+	key, endpoint := "", aiEndpoint
+
+	if !strings.Contains(s, "=") {
+		key = s
+	}
+
+	for _, part := range strings.Split(s, ";") {
+		i := strings.Index(part, "=")
+		if i < 0 {
+			continue
+		}
+
+		switch strings.ToLower(strings.TrimSpace(part[:i])) {
+		case "instrumentationkey":
+			key = strings.TrimSpace(part[i+1:])
+		case "ingestionendpoint":
+			endpoint = strings.TrimSpace(part[i+1:])
+		}
+	}
+
+	if key == "" {
+		return "", "", errors.New("no InstrumentationKey in connection string")
+	}
+
+	return key, strings.TrimSuffix(endpoint, "/"), nil
+}
+
+// Random hex identifier
+func randomID(n int) string {
+	buf := make([]byte, n)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// A call's ID, its correlation id if it has one
+func aiID(request *Request) string {
+	if id := requestID(request); id != "" {
+		return id
+	}
+
+	return randomID(8)
+}
+
+// Format a duration as per Application Insights `d.hh:mm:ss.fffffff`
+func aiDuration(d time.Duration) string {
+	days := d / (24 * time.Hour)
+	d -= days * 24 * time.Hour
+	h := d / time.Hour
+	d -= h * time.Hour
+	m := d / time.Minute
+	d -= m * time.Minute
+	sec := d / time.Second
+	d -= sec * time.Second
+
+	return fmt.Sprintf("%d.%02d:%02d:%02d.%07d", days, h, m, sec, d/100)
+}
+
+// Export per-request telemetry for a run to Application Insights
+// Calls are dependencies, grouped under one operation per run
+// Suspicious responses are unsuccessful calls
+func exportAppInsights(conn string, sus, ok []Set) error {
+	key, endpoint, err := parseConnectionString(conn)
+	if err != nil {
+		return err
+	}
+
+	name := "Microsoft.ApplicationInsights." + strings.ReplaceAll(key, "-", "") + ".RemoteDependency"
+
+	var items []Envelope
+	envelope := func(set Set, verdict string) Envelope {
+		e := Envelope{
+			Name: name,
+			Time: time.Now().UTC().Format(time.RFC3339Nano),
+			IKey: key,
+			Tags: map[string]string{
+				"ai.operation.id":   runID,
+				"ai.operation.name": "generator",
+				"ai.cloud.role":     "generator",
+			},
+		}
+		e.Data.BaseType = "RemoteDependencyData"
+		e.Data.BaseData = DependencyData{
+			Ver:        2,
+			Name:       strings.ToUpper(set.Request.Request.Method) + " " + set.Request.Path,
+			ID:         aiID(set.Request),
+			Data:       redact(set.Request.URL.String()),
+			Duration:   aiDuration(set.Response.Latency),
+			ResultCode: strconv.Itoa(set.Response.StatusCode),
+			Success:    verdict == "conformant",
+			Type:       "HTTP",
+			Target:     set.Request.Host,
+			Properties: map[string]string{
+				"verdict":     verdict,
+				"operationId": set.Request.Method.OperationID,
+			},
+		}
+		return e
+	}
+
+	for _, bad := range sus {
+		items = append(items, envelope(bad, "suspicious"))
+	}
+	for _, set := range ok {
+		items = append(items, envelope(set, "conformant"))
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	for len(items) > 0 {
+		n := len(items)
+		if n > aiBatch {
+			n = aiBatch
+		}
+
+		buf, err := json.Marshal(items[:n])
+		if err != nil {
+			return err
+		}
+		items = items[n:]
+
+		resp, err := client.Post(endpoint+"/v2/track", "application/json", bytes.NewReader(buf))
+		if err != nil {
+			return err
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("ingestion responded %s → %s", resp.Status, string(body))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,182 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Extensions of the reports written to an artifacts directory, by format
+var formatExts = map[string]string{"json": ".json", "jsonl": ".jsonl", "har": ".har", "junit": ".xml", "markdown": ".md", "summary": ".txt", "template": ".txt", "ado": ".log", "gha": ".log"}
+
+// Flags which are credentials, whose values are never written however short
+var secretFlags = map[string]bool{"auth": true, "basic": true, "ntlm": true, "apikey": true, "cookie": true, "signkey": true, "dbauth": true}
+
+// Artifacts is a directory of everything from a run, as per -artifacts, for archiving as a CI artifact
+// A nil Artifacts writes nothing
+type Artifacts struct {
+	Dir string
+	log *os.File
+}
+
+// Make a run's directory under dir, named for when it started and the run, and log to it as well as standard error
+func newArtifacts(dir string) (*Artifacts, error) {
+	name := runStarted.UTC().Format("20060102T150405Z") + "-" + runID[:8]
+	a := &Artifacts{Dir: filepath.Join(dir, name)}
+
+	err := os.MkdirAll(a.Dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	a.log, err = os.Create(a.path("run.log"))
+	if err != nil {
+		return nil, err
+	}
+	stderr.Flush()
+	stderr = bufio.NewWriter(io.MultiWriter(os.Stderr, a.log))
+
+	return a, nil
+}
+
+// A file of the run's directory
+func (a *Artifacts) path(name string) string {
+	return filepath.Join(a.Dir, name)
+}
+
+// Write the run's options, its arguments and the value of every flag, secrets redacted
+func (a *Artifacts) Options() error {
+	if a == nil {
+		return nil
+	}
+
+	var out struct {
+		Run     string            `json:"run"`
+		Version string            `json:"version"`
+		Started time.Time         `json:"started"`
+		Args    []string          `json:"args"`
+		Flags   map[string]string `json:"flags"`
+	}
+	out.Run, out.Version, out.Started = runID, generatorVersion(), runStarted.UTC()
+	secret := false
+	for _, arg := range os.Args[1:] {
+		name := strings.SplitN(strings.TrimLeft(arg, "-"), "=", 2)[0]
+		switch {
+		case secret:
+			arg = redacted
+		case strings.HasPrefix(arg, "-") && secretFlags[name] && strings.Contains(arg, "="):
+			arg = arg[:strings.Index(arg, "=")+1] + redacted
+		}
+		secret = strings.HasPrefix(arg, "-") && secretFlags[name] && !strings.Contains(arg, "=")
+		out.Args = append(out.Args, redact(arg))
+	}
+	out.Flags = make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		if secretFlags[f.Name] && value != "" {
+			value = redacted
+		}
+		out.Flags[f.Name] = redact(value)
+	})
+
+	buf, err := json.MarshalIndent(out, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(a.path("options.json"), append(buf, '\n'), 0644)
+}
+
+// Outputs writing a report in each format the run writes, as report.json and so on
+func (a *Artifacts) Sinks(sinks Sinks) Sinks {
+	if a == nil {
+		return nil
+	}
+
+	var out Sinks
+	seen := make(map[string]bool)
+	for _, sink := range sinks {
+		ext := formatExts[sink.Format]
+		if seen[ext] {
+			ext = "." + sink.Format + ext
+		}
+		if seen[ext] {
+			continue
+		}
+		seen[ext] = true
+
+		out = append(out, &Sink{Format: sink.Format, Name: a.path("report" + ext)})
+	}
+
+	return out
+}
+
+// Write the built requests, as -outdir does
+func (a *Artifacts) Requests(proto string, requests []*Request) error {
+	if a == nil {
+		return nil
+	}
+
+	return writeOutdir(a.path("requests"), proto, requests)
+}
+
+// Write each raw response, named as its request is
+func (a *Artifacts) Responses(proto string, requests []*Request, results map[*Request]*Response) error {
+	if a == nil {
+		return nil
+	}
+
+	dir := a.path("responses")
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	names := requestFileNames(proto, requests)
+	for i, request := range requests {
+		response, ok := results[request]
+		if !ok {
+			continue
+		}
+
+		err := ioutil.WriteFile(filepath.Join(dir, names[i]), []byte(prettyResponse(response)), 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// A response as raw HTTP, its headers and body already scrubbed of secrets
+func prettyResponse(resp *Response) string {
+	var b strings.Builder
+	proto := resp.Proto
+	if proto == "" {
+		proto = "HTTP/1.1"
+	}
+	b.WriteString(proto + " " + resp.Status + "\r\n")
+	resp.Header.Write(&b)
+	b.WriteString("\r\n")
+	b.WriteString(resp.Body)
+
+	return b.String()
+}
+
+// Write a file of the run's directory
+func (a *Artifacts) Write(name string, buf []byte) error {
+	if a == nil {
+		return nil
+	}
+
+	return ioutil.WriteFile(a.path(name), buf, 0644)
+}
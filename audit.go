@@ -0,0 +1,148 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// AuditEntry records a call to the server or a job it ran, by whom, and what it asked to be replayed where
+type AuditEntry struct {
+	Time     time.Time `json:"time"`
+	Event    string    `json:"event"` // "call" or "job"
+	Caller   string    `json:"caller,omitempty"`
+	Remote   string    `json:"remote,omitempty"`
+	Method   string    `json:"method,omitempty"`
+	Path     string    `json:"path,omitempty"`
+	Status   int       `json:"status,omitempty"`
+	Duration int64     `json:"durationms"`
+	API      string    `json:"api,omitempty"`
+	CfgPath  string    `json:"cfgpath,omitempty"`
+	Target   string    `json:"target,omitempty"`
+	Profile  string    `json:"profile,omitempty"`
+	Replay   *bool     `json:"replay,omitempty"`
+	Job      string    `json:"job,omitempty"`
+	Outcome  string    `json:"outcome,omitempty"` // How a job ended, "done", "failed", or "cancelled"
+	Error    string    `json:"error,omitempty"`
+}
+
+// Audit records go here, one JSON object per line
+var (
+	auditOut io.Writer
+	auditMu  sync.Mutex
+)
+
+// Key for the audit entry in a request's context
+type auditKey struct{}
+
+// The audit entry of a request, for handlers to fill in
+func auditFor(r *http.Request) *AuditEntry {
+	entry, _ := r.Context().Value(auditKey{}).(*AuditEntry)
+	if entry == nil {
+		// Not audited, so written nowhere
+		return &AuditEntry{}
+	}
+	return entry
+}
+
+// Note what a generation request asked for in its audit entry
+func auditOptions(r *http.Request, opts JobOptions) {
+	auditFor(r).options(opts)
+}
+
+// Summarize options, with secrets redacted
+func (entry *AuditEntry) options(opts JobOptions) {
+	replay := !opts.NoReplay
+	entry.API, entry.CfgPath, entry.Target, entry.Replay = redact(opts.API), redact(opts.CfgPath), opts.Target, &replay
+	entry.Profile = opts.Profile
+	if opts.spec != nil {
+		entry.API = "(uploaded)"
+	}
+}
+
+// Record a job which has finished
+func auditJob(job *Job) {
+	entry := &AuditEntry{
+		Time:    job.Finished.UTC(),
+		Event:   "job",
+		Caller:  job.owner,
+		Job:     job.ID,
+		Outcome: job.Status,
+		Error:   redact(job.Error),
+	}
+	// Jobs cancelled while queued never started
+	if job.Started != nil {
+		entry.Duration = job.Finished.Sub(*job.Started).Milliseconds()
+	}
+	entry.options(job.opts)
+	writeAudit(entry)
+}
+
+// Write an audit record as a line of JSON
+func writeAudit(entry *AuditEntry) {
+	buf, err := json.Marshal(entry)
+	if err != nil || auditOut == nil {
+		return
+	}
+
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	auditOut.Write(append(buf, '\n'))
+}
+
+// statusWriter remembers the status written
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(buf []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(buf)
+}
+
+// Flush for streaming responses
+func (w *statusWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Wrap a handler so each call is audited once it's answered
+func audit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entry := &AuditEntry{
+			Time:   time.Now().UTC(),
+			Event:  "call",
+			Remote: r.RemoteAddr,
+			Method: r.Method,
+			Path:   r.URL.Path,
+		}
+		sw := &statusWriter{ResponseWriter: w}
+
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), auditKey{}, entry)))
+
+		entry.Status = sw.status
+		if entry.Status == 0 {
+			entry.Status = http.StatusOK
+		}
+		entry.Duration = time.Since(entry.Time).Milliseconds()
+
+		writeAudit(entry)
+	})
+}
@@ -0,0 +1,376 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/Azure/go-ntlmssp"
+	"github.com/seh-msft/cfg"
+	"github.com/seh-msft/generator/pkg/generator"
+)
+
+// SecurityScheme is an OpenAPI security scheme
+// The openapi package doesn't carry these, so they're parsed separately
+type SecurityScheme struct {
+	Type   string `json:"type"`   // "apiKey", "http", "oauth2", or "openIdConnect"
+	Name   string `json:"name"`   // Header, query parameter, or cookie name for "apiKey"
+	In     string `json:"in"`     // "header", "query", or "cookie" for "apiKey"
+	Scheme string `json:"scheme"` // Such as "bearer" or "basic" for "http"
+}
+
+// SecurityRequirement names the schemes which together satisfy an operation
+type SecurityRequirement map[string][]string
+
+// Security is the security portion of an OpenAPI specification
+type Security struct {
+	Schemes    map[string]SecurityScheme
+	Global     *[]SecurityRequirement            // Top-level requirements, nil if undeclared
+	Operations map[string]*[]SecurityRequirement // Per-operation requirements by "method path"
+}
+
+// Parse the security schemes and requirements from an OpenAPI JSON specification
+func parseSecurity(spec []byte) (Security, error) {
+	var doc struct {
+		Components struct {
+			SecuritySchemes map[string]SecurityScheme `json:"securitySchemes"`
+		} `json:"components"`
+		Security *[]SecurityRequirement                `json:"security"`
+		Paths    map[string]map[string]json.RawMessage `json:"paths"`
+	}
+
+	err := json.Unmarshal(spec, &doc)
+	if err != nil {
+		return Security{}, err
+	}
+
+	security := Security{
+		Schemes:    doc.Components.SecuritySchemes,
+		Global:     doc.Security,
+		Operations: make(map[string]*[]SecurityRequirement),
+	}
+
+	for path, methods := range doc.Paths {
+		security.addOperations(path, methods)
+	}
+
+	return security, nil
+}
+
+// Add the requirements of a path item's operations
+// Path items hold more than methods, such as "parameters", so methods are decoded one at a time
+func (s Security) addOperations(path string, methods map[string]json.RawMessage) {
+	for method, raw := range methods {
+		var op struct {
+			Security *[]SecurityRequirement `json:"security"`
+		}
+		if json.Unmarshal(raw, &op) != nil || op.Security == nil {
+			continue
+		}
+		s.Operations[strings.ToLower(method)+" "+path] = op.Security
+	}
+}
+
+// Requirements for an operation, its own or else the spec's
+// Any one requirement will do, none at all means the operation takes no credentials
+// If declared is false, the spec says nothing about security for the operation
+func (s Security) requirements(method, path string) (requirements []SecurityRequirement, declared bool) {
+	if op, ok := s.Operations[strings.ToLower(method)+" "+path]; ok {
+		return *op, true
+	}
+	if s.Global != nil {
+		return *s.Global, true
+	}
+	return nil, false
+}
+
+// Attach credentials to requests as per each operation's security requirements
+// Operations the spec says nothing about get every credential we have
+// The Authorization header value is for bearer, basic, and other HTTP schemes, the key for apiKey schemes
+// Credentials in the db are looked up as generation with opts would
+func applySecurity(requests []*Request, security Security, db cfg.Cfg, opts generator.Options, title, authorization, key string) error {
+	var undeclared []*Request
+
+	for _, request := range requests {
+		requirements, declared := security.requirements(request.Request.Method, request.Path)
+		if !declared {
+			undeclared = append(undeclared, request)
+			continue
+		}
+
+		// security: [] means no credentials
+		if len(requirements) < 1 {
+			trace("\t\tno credentials for " + request.Request.Method + " " + request.Path + " as per its security\n")
+			stripAuth([]*Request{request})
+			continue
+		}
+
+		// The first requirement we hold every credential for wins
+		// An empty requirement makes credentials optional, so it's a last resort
+		satisfied := false
+		optional := false
+		for _, requirement := range requirements {
+			if len(requirement) < 1 {
+				optional = true
+				continue
+			}
+			ok, err := satisfy(request, requirement, security.Schemes, db, opts, title, authorization, key)
+			if err != nil {
+				return err
+			}
+			if ok {
+				satisfied = true
+				break
+			}
+		}
+
+		if !satisfied && !optional {
+			trace("\t\tno credentials satisfy the security of " + request.Request.Method + " " + request.Path + "\n")
+		}
+	}
+
+	if len(undeclared) < 1 {
+		return nil
+	}
+
+	// Authorization goes on every request
+	if authorization != "" {
+		applyAuthorization(undeclared, authorization)
+	} else {
+		err := applyDbBasic(undeclared, db, opts, title)
+		if err != nil {
+			return err
+		}
+	}
+
+	// API keys go wherever the spec says
+	return applyAPIKeys(undeclared, security.Schemes, db, opts, title, key)
+}
+
+// Attach the credentials for every scheme in a requirement, if we hold them all
+func satisfy(request *Request, requirement SecurityRequirement, schemes map[string]SecurityScheme, db cfg.Cfg, opts generator.Options, title, authorization, key string) (bool, error) {
+	// Resolve every credential before touching the request
+	values := make(map[string]string)
+	for name := range requirement {
+		scheme, ok := schemes[name]
+		if !ok {
+			return false, nil
+		}
+
+		value, err := credential(request, name, scheme, db, opts, title, authorization, key)
+		if err != nil || value == "" {
+			return false, err
+		}
+		values[name] = value
+	}
+
+	for name, value := range values {
+		scheme := schemes[name]
+		if strings.EqualFold(scheme.Type, "apiKey") {
+			setAPIKey(request.Request, scheme, value)
+		} else {
+			redactCredential(value)
+			request.Header.Set("Authorization", value)
+		}
+	}
+
+	return true, nil
+}
+
+// The credential we hold for a scheme, if any
+// For apiKey schemes it's the key, otherwise it's an Authorization header value
+func credential(request *Request, name string, scheme SecurityScheme, db cfg.Cfg, opts generator.Options, title, authorization, key string) (string, error) {
+	switch strings.ToLower(scheme.Type) {
+	case "apikey":
+		if key != "" {
+			return key, nil
+		}
+		for _, n := range []string{scheme.Name, name} {
+			values, r, err := opts.Lookup(db, n, request.Path, request.Method.OperationID, title)
+			if err != nil {
+				return "", err
+			}
+			if r == generator.Something {
+				return values[0], nil
+			}
+		}
+
+	case "http":
+		switch strings.ToLower(scheme.Scheme) {
+		case "bearer":
+			if strings.HasPrefix(authorization, "Bearer ") {
+				return authorization, nil
+			}
+		case "basic":
+			if strings.HasPrefix(authorization, "Basic ") {
+				return authorization, nil
+			}
+			values, r, err := opts.Lookup(db, "basic", request.Path, request.Method.OperationID, title)
+			if err != nil {
+				return "", err
+			}
+			if r == generator.Something {
+				redactCredential(values[0])
+				return basicAuthorization(values[0]), nil
+			}
+		default:
+			return authorization, nil
+		}
+
+	case "oauth2", "openidconnect":
+		if strings.HasPrefix(authorization, "Bearer ") {
+			return authorization, nil
+		}
+	}
+
+	return "", nil
+}
+
+// Attach API keys to requests as per the spec's apiKey security schemes
+// The key is the -apikey value if given, else a db entry named for the key or the scheme
+func applyAPIKeys(requests []*Request, schemes map[string]SecurityScheme, db cfg.Cfg, opts generator.Options, title, key string) error {
+	for schemeName, scheme := range schemes {
+		if !strings.EqualFold(scheme.Type, "apiKey") || scheme.Name == "" {
+			continue
+		}
+
+		for _, request := range requests {
+			value := key
+			if value == "" {
+				for _, name := range []string{scheme.Name, schemeName} {
+					values, r, err := opts.Lookup(db, name, request.Path, request.Method.OperationID, title)
+					if err != nil {
+						return err
+					}
+					if r == generator.Something {
+						value = values[0]
+						break
+					}
+				}
+			}
+
+			if value == "" {
+				trace("\t\tno key for apiKey scheme " + schemeName + " on " + request.Path + "\n")
+				continue
+			}
+
+			setAPIKey(request.Request, scheme, value)
+		}
+	}
+
+	return nil
+}
+
+// Place an API key where the scheme says it goes
+// Keys in a query aren't caught by the header patterns, so every key is redacted as it's placed
+func setAPIKey(req *http.Request, scheme SecurityScheme, value string) {
+	redactCredential(value)
+	switch strings.ToLower(scheme.In) {
+	case "query":
+		q := req.URL.Query()
+		q.Set(scheme.Name, value)
+		req.URL.RawQuery = q.Encode()
+
+	case "cookie":
+		req.AddCookie(&http.Cookie{Name: scheme.Name, Value: value})
+
+	default:
+		req.Header.Set(scheme.Name, value)
+	}
+}
+
+// Authorization header value for Basic credentials in the form `user:pass`
+func basicAuthorization(creds string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(creds))
+}
+
+// Negotiate NTLM or SPNEGO with servers which ask for it, from the Basic credentials on each request
+// Credentials are in the form `DOMAIN\user:pass` or `user@domain:pass`
+func negotiator() http.RoundTripper {
+	return ntlmssp.Negotiator{RoundTripper: http.DefaultTransport}
+}
+
+// Attach an Authorization header to requests which don't have one from a parameter
+func applyAuthorization(requests []*Request, value string) {
+	redactCredential(value)
+	for _, request := range requests {
+		if request.Header.Get("Authorization") == "" {
+			request.Header.Set("Authorization", value)
+		}
+	}
+}
+
+// Attach HTTP Basic credentials from the db's "basic" entries in the form `user:pass`
+func applyDbBasic(requests []*Request, db cfg.Cfg, opts generator.Options, title string) error {
+	for _, request := range requests {
+		if request.Header.Get("Authorization") != "" {
+			continue
+		}
+
+		values, r, err := opts.Lookup(db, "basic", request.Path, request.Method.OperationID, title)
+		if err != nil {
+			return err
+		}
+		if r != generator.Something {
+			continue
+		}
+
+		redactCredential(values[0])
+		value := basicAuthorization(values[0])
+		redactCredential(value)
+		request.Header.Set("Authorization", value)
+	}
+
+	return nil
+}
+
+// Attach session cookies from -cookie and the db's "cookie" entries in the form `'name=value; name=value'`
+// Cookies from a spec parameter are kept
+func applyCookies(requests []*Request, cookies string, db cfg.Cfg, opts generator.Options, title string) error {
+	for _, request := range requests {
+		var jar []string
+		if existing := request.Header.Get("Cookie"); existing != "" {
+			jar = append(jar, existing)
+		}
+		if cookies != "" {
+			jar = append(jar, cookies)
+			redactCookies(cookies)
+		}
+		values, r, err := opts.Lookup(db, "cookie", request.Path, request.Method.OperationID, title)
+		if err != nil {
+			return err
+		}
+		if r == generator.Something {
+			jar = append(jar, values[0])
+			redactCookies(values[0])
+		}
+
+		if len(jar) > 0 {
+			request.Header.Set("Cookie", strings.Join(jar, "; "))
+		}
+	}
+
+	return nil
+}
+
+// Redact each value of a `name=value; name=value` Cookie header
+func redactCookies(cookies string) {
+	redactCredential(cookies)
+	for _, cookie := range strings.Split(cookies, ";") {
+		if parts := strings.SplitN(strings.TrimSpace(cookie), "=", 2); len(parts) == 2 {
+			redactCredential(parts[1])
+		}
+	}
+}
+
+// Strip credentials from requests, as per -noauth
+func stripAuth(requests []*Request) {
+	for _, request := range requests {
+		request.Header.Del("Authorization")
+		request.Header.Del("Cookie")
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuthFile is a bearer token kept in a file, such as by a sidecar which refreshes it
+// The file is read again whenever it changes
+type AuthFile struct {
+	Name string
+
+	mu      sync.Mutex
+	token   string
+	modTime time.Time
+	size    int64
+}
+
+// Token from -authfile, if any
+var authFile *AuthFile
+
+// Token returns the file's token, reading it again if the file has changed
+func (a *AuthFile) Token() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	info, err := os.Stat(a.Name)
+	if err != nil {
+		return "", err
+	}
+	if a.token != "" && info.ModTime().Equal(a.modTime) && info.Size() == a.size {
+		return a.token, nil
+	}
+
+	buf, err := ioutil.ReadFile(a.Name)
+	if err != nil {
+		return "", err
+	}
+
+	token := strings.TrimSpace(string(buf))
+	if token == "" {
+		return "", errors.New("no token in " + a.Name)
+	}
+
+	if token != a.token {
+		chat("auth: read token from " + a.Name)
+		if !*noRedact {
+			redactSecret(token)
+		}
+	}
+	a.token, a.modTime, a.size = token, info.ModTime(), info.Size()
+
+	return token, nil
+}
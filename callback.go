@@ -0,0 +1,124 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Completion is posted to a job's callback once it's finished
+type Completion struct {
+	Job
+	Built      int         `json:"built"`
+	Suspicious int         `json:"suspicious"`
+	Conformant int         `json:"conformant"`
+	Result     interface{} `json:"result,omitempty"` // The job's output, if the full report was asked for
+}
+
+// Attempts made to deliver a completion, and the wait before the first retry, doubling after
+const (
+	callbackAttempts = 3
+	callbackBackoff  = 2 * time.Second
+)
+
+// Check a callback is a URL we could post to
+func checkCallback(callback string) error {
+	u, err := url.Parse(callback)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("callback must be an http or https URL")
+	}
+
+	return nil
+}
+
+// Summarize a finished job for its callback
+func (job *Job) completion() Completion {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+
+	c := Completion{Job: *job}
+	for _, event := range job.events {
+		switch {
+		case event.Type == "built":
+			c.Built = event.Total
+		case event.Verdict == "suspicious":
+			c.Suspicious++
+		case event.Verdict == "conformant":
+			c.Conformant++
+		}
+	}
+
+	if job.opts.CallbackFull && job.output != nil {
+		if json.Valid(job.output.Body) {
+			c.Result = json.RawMessage(job.output.Body)
+		} else {
+			c.Result = string(job.output.Body)
+		}
+	}
+
+	return c
+}
+
+// Post a finished job to its callback, retrying failures, and audit how it went
+func (job *Job) callback() {
+	entry := &AuditEntry{Event: "callback", Caller: job.owner, Job: job.ID, Outcome: "delivered"}
+	start := time.Now()
+
+	err := deliver(job.opts.Callback, job.ID, job.completion(), &entry.Status)
+	if err != nil {
+		entry.Outcome, entry.Error = "failed", redact(err.Error())
+	}
+
+	entry.Time, entry.Duration = time.Now().UTC(), time.Since(start).Milliseconds()
+	writeAudit(entry)
+}
+
+// Post a completion, noting the status of the last attempt
+func deliver(callback, id string, c Completion, status *int) error {
+	buf, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Transport: egress.roundTripper(), Timeout: 30 * time.Second}
+	wait := callbackBackoff
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequest("POST", callback, bytes.NewReader(buf))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Generator-Job", id)
+
+		resp, err := client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			*status = resp.StatusCode
+			if resp.StatusCode/100 == 2 {
+				return nil
+			}
+			err = fmt.Errorf("callback answered %s", resp.Status)
+
+			// Only the receiver being busy or broken is worth trying again
+			if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+				return err
+			}
+		}
+
+		if attempt >= callbackAttempts {
+			return err
+		}
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
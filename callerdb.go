@@ -0,0 +1,34 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"errors"
+
+	"github.com/seh-msft/cfg"
+)
+
+// Check a db a caller of -listen gave, which may not make the server read its own files
+// Dbs from the command line or a profile are the operator's, so aren't checked
+func checkCallerDb(db cfg.Cfg) error {
+	for _, record := range db.Records {
+		if len(record.Tuples) < 1 || len(record.Tuples[0].Attributes) < 1 {
+			continue
+		}
+		name := record.Tuples[0].Attributes[0].Name
+
+		for _, tuple := range record.Tuples[1:] {
+			if len(tuple.Attributes) < 1 || tuple.Attributes[0].Name != "properties" {
+				continue
+			}
+			for _, attr := range tuple.Attributes[1:] {
+				if attr.Name == "dictionary" {
+					return errors.New(name + ": fuzz dictionaries are files on the server, so only its own dbs may name them")
+				}
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,155 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config file found in the working directory when -config isn't given
+const defaultConfig = ".generator.yaml"
+
+// Prefix of the environment variables flags may be given as
+const envPrefix = "GENERATOR_"
+
+// The environment variable a flag may be given as, such as GENERATOR_FAIL_ON for -fail-on
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// Set flags not given on the command line from the environment, such as secrets a pipeline holds
+// Repeatable flags take a value per line
+// Flags which don't apply to the subcommand, if any, are passed over
+func applyEnv(cmd *Subcommand) error {
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok || given[f.Name] || !cmd.takes(f.Name) || err != nil {
+			return
+		}
+
+		values := []string{value}
+		if _, ok := f.Value.(flag.Getter); !ok {
+			values = strings.Split(strings.TrimSpace(value), "\n")
+		}
+		for _, value := range values {
+			if e := flag.Set(f.Name, value); e != nil {
+				err = fmt.Errorf("%s → %v", envName(f.Name), e)
+				return
+			}
+		}
+	})
+
+	return err
+}
+
+// Set flags not given on the command line from a YAML file of flag: value, such as
+//
+//	api: spec.json
+//	db: db.cfg
+//	ignoremethods: [PUT, PATCH]
+//	output: [junit=results.xml, summary=-]
+//
+// Lists are given once per value to repeatable flags, and comma-separated to others
+// Flags which don't apply to the subcommand, if any, are passed over, so one file may serve several
+// Without -config, .generator.yaml is read if present, -config "" reads nothing
+func applyConfig(name string, cmd *Subcommand) error {
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	if !given["config"] {
+		if _, err := os.Stat(defaultConfig); err != nil {
+			return nil
+		}
+		name = defaultConfig
+	}
+	if name == "" {
+		return nil
+	}
+
+	buf, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+
+	var doc map[string]interface{}
+	err = yaml.Unmarshal(buf, &doc)
+	if err != nil {
+		return errors.New(name + ": " + err.Error())
+	}
+
+	var keys []string
+	for key := range doc {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		fname := strings.TrimLeft(key, "-")
+		f := flag.Lookup(fname)
+		if f == nil || fname == "config" {
+			return fmt.Errorf("%s: no such flag → %s", name, key)
+		}
+		if given[fname] || !cmd.takes(fname) {
+			continue
+		}
+
+		values, err := configValues(doc[key])
+		if err != nil {
+			return fmt.Errorf("%s: %s → %v", name, key, err)
+		}
+
+		// Repeatable flags are ours, the standard ones are Getters
+		if _, ok := f.Value.(flag.Getter); ok {
+			values = []string{strings.Join(values, ",")}
+		}
+		for _, value := range values {
+			err := flag.Set(fname, value)
+			if err != nil {
+				return fmt.Errorf("%s: %s → %v", name, key, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// The values of a config entry, a scalar or a list of them
+func configValues(v interface{}) ([]string, error) {
+	switch v := v.(type) {
+	case nil:
+		return []string{""}, nil
+
+	case []interface{}:
+		var out []string
+		for _, item := range v {
+			switch item.(type) {
+			case []interface{}, map[string]interface{}:
+				return nil, errors.New("lists must be of plain values")
+			}
+			out = append(out, fmt.Sprint(item))
+		}
+		return out, nil
+
+	case map[string]interface{}:
+		return nil, errors.New("must be a value or list of values")
+	}
+
+	return []string{fmt.Sprint(v)}, nil
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Flags of a run as applyEnv and applyConfig see them, given the command line, environment, and config file
+type testFlags struct {
+	target      *string
+	concurrency *int
+	noAuth      *bool
+	redact      *RedactFlags
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		config  string
+		want    testFlags
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+			want: testFlags{target: str("default"), concurrency: num(1), noAuth: boolean(false), redact: &RedactFlags{}},
+		},
+		{
+			name:   "config",
+			config: "target: config\nconcurrency: 4\nnoauth: true\nredact: [a, b]\n",
+			want:   testFlags{target: str("config"), concurrency: num(4), noAuth: boolean(true), redact: &RedactFlags{"a", "b"}},
+		},
+		{
+			name:   "environment over config",
+			env:    map[string]string{"GENERATOR_TARGET": "env", "GENERATOR_REDACT": "x\ny"},
+			config: "target: config\nconcurrency: 4\nredact: [a, b]\n",
+			want:   testFlags{target: str("env"), concurrency: num(4), noAuth: boolean(false), redact: &RedactFlags{"x", "y"}},
+		},
+		{
+			name:   "command line over environment and config",
+			args:   []string{"-target", "flag", "-concurrency", "2"},
+			env:    map[string]string{"GENERATOR_TARGET": "env", "GENERATOR_CONCURRENCY": "3"},
+			config: "target: config\nconcurrency: 4\nnoauth: true\n",
+			want:   testFlags{target: str("flag"), concurrency: num(2), noAuth: boolean(true), redact: &RedactFlags{}},
+		},
+		{
+			name:   "lists for other flags are comma-separated",
+			config: "target: [a, b]\n",
+			want:   testFlags{target: str("a,b"), concurrency: num(1), noAuth: boolean(false), redact: &RedactFlags{}},
+		},
+		{
+			name:    "unknown config key",
+			config:  "targte: config\n",
+			wantErr: true,
+		},
+		{
+			name:    "config may not name a config",
+			config:  "config: other.yaml\n",
+			wantErr: true,
+		},
+		{
+			name:    "bad config value",
+			config:  "concurrency: many\n",
+			wantErr: true,
+		},
+		{
+			name:    "nested config value",
+			config:  "target: {a: b}\n",
+			wantErr: true,
+		},
+		{
+			name:    "bad environment value",
+			env:     map[string]string{"GENERATOR_CONCURRENCY": "many"},
+			wantErr: true,
+		},
+	}
+
+	dir, err := ioutil.TempDir("", "generator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			name := filepath.Join(dir, "config.yaml")
+			err := ioutil.WriteFile(name, []byte(test.config), 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := parseTestFlags(append([]string{"-config", name}, test.args...), test.env)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("error = %v, want error %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if *got.target != *test.want.target || *got.concurrency != *test.want.concurrency || *got.noAuth != *test.want.noAuth || !reflect.DeepEqual(*got.redact, *test.want.redact) {
+				t.Errorf("target %q concurrency %d noauth %v redact %q, want target %q concurrency %d noauth %v redact %q",
+					*got.target, *got.concurrency, *got.noAuth, *got.redact,
+					*test.want.target, *test.want.concurrency, *test.want.noAuth, *test.want.redact)
+			}
+		})
+	}
+}
+
+// Parse flags of a fresh command line as main does, the environment first, then the config
+func parseTestFlags(args []string, env map[string]string) (testFlags, error) {
+	saved := flag.CommandLine
+	defer func() { flag.CommandLine = saved }()
+
+	flag.CommandLine = flag.NewFlagSet("generator", flag.ContinueOnError)
+	f := testFlags{
+		target:      flag.String("target", "default", ""),
+		concurrency: flag.Int("concurrency", 1, ""),
+		noAuth:      flag.Bool("noauth", false, ""),
+		redact:      &RedactFlags{},
+	}
+	flag.Var(f.redact, "redact", "")
+	config := flag.String("config", defaultConfig, "")
+
+	for name, value := range env {
+		os.Setenv(name, value)
+		defer os.Unsetenv(name)
+	}
+
+	err := flag.CommandLine.Parse(args)
+	if err != nil {
+		return f, err
+	}
+	err = applyEnv(nil)
+	if err != nil {
+		return f, err
+	}
+
+	return f, applyConfig(*config, nil)
+}
+
+func str(s string) *string { return &s }
+func num(n int) *int       { return &n }
+func boolean(b bool) *bool { return &b }
@@ -0,0 +1,52 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"strings"
+)
+
+// Header a request's correlation id is sent as, alongside traceparent
+const requestIDHeader = "X-Request-ID"
+
+// Give each request a correlation id, as X-Request-ID and the span of a traceparent in the run's trace
+// Results carry the id, so a suspicious one can be found in the target's logs
+// Headers already set, such as by -H, are kept
+func correlate(requests []*Request) {
+	for _, request := range requests {
+		id := randomID(8)
+		if request.Header.Get(requestIDHeader) == "" {
+			request.Header.Set(requestIDHeader, id)
+		}
+		// Not sampled, unless -otlp traces the request
+		if request.Header.Get("traceparent") == "" {
+			request.Header.Set("traceparent", "00-"+runID+"-"+id+"-00")
+		}
+	}
+}
+
+// A request's correlation id, if any
+func requestID(request *Request) string {
+	return request.Header.Get(requestIDHeader)
+}
+
+// Label a request's correlation id for a line of output, such as " (request 9f86d081884c7d65)"
+func requestIDSuffix(request *Request) string {
+	id := requestID(request)
+	if id == "" {
+		return ""
+	}
+
+	return " (request " + id + ")"
+}
+
+// The span of the run's trace a traceparent names, "" if another's
+func traceSpan(traceparent string) string {
+	parts := strings.Split(traceparent, "-")
+	if len(parts) != 4 || parts[1] != runID {
+		return ""
+	}
+
+	return parts[2]
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// CORS lets browsers on other origins call the server, such as an internal web UI
+type CORS struct {
+	Origins []string // Origins allowed, "*" for any
+	Methods []string // Methods allowed
+}
+
+// Headers browsers may send and read across origins
+const (
+	corsAllowHeaders  = "Authorization, Content-Type, X-API-Key, Last-Event-ID"
+	corsExposeHeaders = "Location, Retry-After"
+)
+
+// Split a comma-separated flag into its trimmed, non-empty elements
+func splitList(s string) []string {
+	var out []string
+	for _, e := range strings.Split(s, ",") {
+		e = strings.TrimSpace(e)
+		if e != "" {
+			out = append(out, e)
+		}
+	}
+
+	return out
+}
+
+// Is an origin allowed
+func (c *CORS) allowed(origin string) bool {
+	for _, o := range c.Origins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Wrap a handler to answer preflight requests and mark responses to allowed origins
+// Preflight requests carry no credentials, so this goes before authentication
+func (c *CORS) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+
+		preflight := r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != ""
+		if !c.allowed(origin) {
+			if preflight {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Callers authenticate with headers rather than cookies, so credentials needn't be allowed
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Expose-Headers", corsExposeHeaders)
+
+		if preflight {
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(c.Methods, ", "))
+			w.Header().Set("Access-Control-Allow-Headers", corsAllowHeaders)
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/seh-msft/cfg"
+	"github.com/seh-msft/generator/pkg/generator"
+	"github.com/seh-msft/openapi"
+)
+
+// Read a CSV file whose header names identifiers and whose rows are consistent data sets
+func readRows(name string) ([]string, [][]string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	rows, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(rows) < 2 {
+		return nil, nil, errors.New("need a header and at least one row")
+	}
+
+	header := rows[0]
+	for i := range header {
+		header[i] = strings.TrimSpace(header[i])
+	}
+
+	return header, rows[1:], nil
+}
+
+// A db for one row, the row's values replace the db's own for its identifiers
+// Empty cells leave the db's own values be
+func withRow(db cfg.Cfg, header, row []string) cfg.Cfg {
+	replaced := make(map[string]bool)
+	for i, name := range header {
+		if row[i] != "" {
+			replaced[name] = true
+		}
+	}
+
+	var out cfg.Cfg
+	for _, record := range db.Records {
+		if !replaced[record.PrimaryKey()] {
+			out.Records = append(out.Records, record)
+		}
+	}
+
+	for i, name := range header {
+		if row[i] == "" {
+			continue
+		}
+		out.Records = append(out.Records, &cfg.Record{Tuples: []*cfg.Tuple{{Attributes: []*cfg.Attribute{{Name: name, Value: row[i]}}}}})
+	}
+
+	out.BuildMap()
+	return out
+}
+
+// Generate one request set per CSV row, so correlated values stay together
+func generateRows(ctx context.Context, api openapi.API, db cfg.Cfg, name string, opts generator.Options) ([]*Request, map[string]uint64, uint64, error) {
+	header, rows, err := readRows(name)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	var requests []*Request
+	missing := make(map[string]uint64)
+	totalPossible := uint64(0)
+
+	for n, row := range rows {
+		trace("row " + strconv.Itoa(n+1) + ":\n")
+
+		built, missed, possible, err := generator.Generate(ctx, api, withRow(db, header, row), opts)
+		if err != nil {
+			return nil, nil, 0, fmt.Errorf("row %d: %w", n+1, err)
+		}
+
+		requests = append(requests, built...)
+		for param, count := range missed {
+			missing[param] += count
+		}
+		totalPossible += possible
+	}
+
+	return requests, missing, totalPossible, nil
+}
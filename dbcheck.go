@@ -0,0 +1,364 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/seh-msft/cfg"
+	"github.com/seh-msft/generator/pkg/generator"
+	"github.com/seh-msft/openapi"
+)
+
+// Attributes each tuple keyword may have, beyond its scoping title, path, and operation
+var ruleAttributes = map[string][]string{
+	"disallow": {"regex"},
+	"permit":   {"regex"},
+	"override": {"regex", "value"},
+	"extract":  {"regex", "field", "header"},
+}
+
+// Check a db, as per `generator db check -db file [-api spec]`
+// Problems are written to w, the exit code is returned
+func dbCheck(w io.Writer) int {
+	if *dbName == "" {
+		fmt.Fprintln(w, "err: db check requires -db")
+		return exitError
+	}
+
+	// Secrets needn't resolve to check the db
+	db, err := readDb(context.Background(), *dbName)
+	if err != nil {
+		fmt.Fprintln(w, "err: malformed db →", err)
+		return exitFindings
+	}
+	db.BuildMap()
+
+	var api *openapi.API
+	opts := generator.Options{EnvFallback: *envFallback}
+	if *apiName != "" {
+		spec, err := ioutil.ReadFile(*apiName)
+		if err != nil {
+			fmt.Fprintln(w, "err: could not open API file →", err)
+			return exitError
+		}
+		parsed, err := openapi.Parse(bytes.NewReader(spec))
+		if err != nil {
+			fmt.Fprintln(w, "err: could not parse API →", err)
+			return exitError
+		}
+		api = &parsed
+		opts.Formats = generator.SpecFormats(spec)
+	}
+
+	problems := checkDb(db, api, opts)
+	for _, problem := range problems {
+		fmt.Fprintln(w, problem)
+	}
+	if len(problems) > 0 {
+		return exitFindings
+	}
+
+	fmt.Fprintln(w, "ok: no problems found in", *dbName)
+	return exitClean
+}
+
+// Find problems with a db, and with its coverage of the spec, if given
+func checkDb(db cfg.Cfg, api *openapi.API, opts generator.Options) []string {
+	var problems []string
+	problem := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+
+	// Operations in the spec, for rules to match against
+	type Operation struct {
+		Method, Path, ID string
+	}
+	var operations []Operation
+	title := ""
+	if api != nil {
+		title = api.Info.Title
+		for path, methods := range api.Paths {
+			for method, m := range methods {
+				operations = append(operations, Operation{strings.ToUpper(method), path, m.OperationID})
+			}
+		}
+	}
+
+	counts := make(map[string]int)
+	for _, record := range db.Records {
+		if len(record.Tuples) < 1 || len(record.Tuples[0].Attributes) < 1 {
+			problem("err: empty record")
+			continue
+		}
+		if generator.IsDefaults(record) {
+			for _, tuple := range record.Tuples[1:] {
+				found := make(map[string]bool)
+				for _, attr := range tuple.Attributes {
+					found[attr.Name] = true
+					switch attr.Name {
+					case "type", "format":
+					case "value":
+						checkValue(problem, "defaults", attr.Value)
+					default:
+						problem("warn: defaults: unknown attribute %q", attr.Name)
+					}
+				}
+				switch {
+				case !found["type"] && !found["format"]:
+					problem("warn: defaults: default has no type or format, so never matches")
+				case !found["value"]:
+					problem("warn: defaults: default has no value")
+				}
+			}
+			continue
+		}
+		if isHeaders(record) {
+			for _, h := range dbHeaders(cfg.Cfg{Records: []*cfg.Record{record}}) {
+				if err := checkHeader(h[0], h[1]); err != nil {
+					problem("err: headers: %v", err)
+				}
+			}
+			continue
+		}
+		if generator.IsIdentity(record) {
+			if record.Tuples[0].Attributes[0].Value == "" {
+				problem("err: identity has no name")
+			}
+			continue
+		}
+
+		name := record.PrimaryKey()
+		counts[name]++
+
+		for _, alias := range generator.Aliases(record) {
+			if _, ok := db.Map[alias]; ok && alias != name {
+				problem("warn: %s: alias %q has a record of its own, which is used instead", name, alias)
+			}
+		}
+
+		hasValue := record.Tuples[0].Attributes[0].Value != ""
+		checkValue(problem, name, record.Tuples[0].Attributes[0].Value)
+
+		for _, tuple := range record.Tuples[1:] {
+			if len(tuple.Attributes) < 1 {
+				continue
+			}
+			keyword := tuple.Attributes[0].Name
+
+			switch keyword {
+			case "properties":
+				strategy := make(map[string][]string)
+				for _, attr := range tuple.Attributes[1:] {
+					switch attr.Name {
+					case "fuzz":
+						hasValue = true
+					case "charset", "length", "range", "dictionary":
+						strategy[attr.Name] = append(strategy[attr.Name], attr.Value)
+					default:
+						problem("warn: %s: unknown property %q", name, attr.Name)
+					}
+				}
+				if s, _, err := generator.ParseFuzz(strategy); err != nil {
+					problem("err: %s: invalid fuzz properties → %v", name, err)
+				} else if err := s.Check(); err != nil {
+					problem("err: %s: unusable dictionary → %v", name, err)
+				}
+				continue
+
+			case "values":
+				for _, attr := range tuple.Attributes[1:] {
+					checkValue(problem, name, attr.Name)
+					if weight, err := strconv.Atoi(attr.Value); attr.Value != "" && (err != nil || weight < 0) {
+						problem("err: %s: weight of value %q must be a whole number, not %q", name, attr.Name, attr.Value)
+					}
+				}
+				hasValue = hasValue || len(tuple.Attributes) > 1
+				continue
+
+			case "alias":
+				continue
+
+			case "override", "extract":
+				hasValue = true
+
+			case "permit", "disallow":
+
+			default:
+				problem("warn: %s: unknown keyword %q", name, keyword)
+				continue
+			}
+
+			// Rules scope by title, path, and operation
+			regex, scoped := false, false
+			found := make(map[string]bool)
+			for _, attr := range tuple.Attributes[1:] {
+				found[attr.Name] = true
+				switch attr.Name {
+				case "title", "path", "operation":
+					scoped = true
+				case "regex":
+					regex = true
+				case "value":
+					checkValue(problem, name, attr.Value)
+				}
+
+				if !known(keyword, attr.Name) {
+					problem("warn: %s: unknown attribute %q in %s rule", name, attr.Name, keyword)
+				}
+			}
+
+			if regex {
+				for _, attr := range tuple.Attributes[1:] {
+					if attr.Name != "title" && attr.Name != "path" && attr.Name != "operation" {
+						continue
+					}
+					if _, err := regexp.Compile(attr.Value); err != nil {
+						problem("err: %s: invalid regex %q in %s rule → %v", name, attr.Value, keyword, err)
+					}
+				}
+			}
+
+			switch {
+			case !scoped:
+				problem("warn: %s: %s rule has no title, path, or operation, so never matches", name, keyword)
+			case keyword == "override" && !found["value"]:
+				problem("warn: %s: override has no value", name)
+			case keyword == "extract" && !found["field"] && !found["header"]:
+				problem("warn: %s: extract has no field or header", name)
+			}
+
+			if api == nil || !scoped {
+				continue
+			}
+
+			// Rules matching nothing in the spec are dead, as are permits for what's disallowed
+			matched, permitted := false, false
+			disallows, _ := record.Lookup("disallow")
+			for _, op := range operations {
+				if matchRules([]*cfg.Tuple{tuple}, op.Path, op.ID, title) {
+					matched = true
+					permitted = permitted || !matchRules(disallows, op.Path, op.ID, title)
+				}
+			}
+			switch {
+			case !matched:
+				problem("warn: %s: %s rule matches no operation in the spec → %s", name, keyword, ruleString(tuple))
+			case keyword == "permit" && !permitted:
+				problem("warn: %s: permit rule is unreachable, disallow rules match every operation it does → %s", name, ruleString(tuple))
+			}
+		}
+
+		// Records whose rules rule out every operation are never used
+		disallows, hasDisallows := record.Lookup("disallow")
+		permits, hasPermits := record.Lookup("permit")
+		if api != nil && (hasDisallows || hasPermits) {
+			used := false
+			for _, op := range operations {
+				if hasDisallows && matchRules(disallows, op.Path, op.ID, title) {
+					continue
+				}
+				if hasPermits && !matchRules(permits, op.Path, op.ID, title) {
+					continue
+				}
+				used = true
+				break
+			}
+			if !used {
+				problem("warn: %s: rules rule out every operation in the spec, so %q is never used", name, record.Tuples[0].Attributes[0].Value)
+			}
+		}
+
+		if !hasValue {
+			problem("warn: %s: has no value, values, or fuzz property", name)
+		}
+	}
+
+	// Later plain records shadow earlier ones
+	var names []string
+	for name, n := range counts {
+		if n > 1 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		last := db.Map[name]
+		_, permits := last["permit"]
+		_, disallows := last["disallow"]
+		_, values := last["values"]
+		if !permits && !disallows && !values {
+			problem("warn: %s: defined %d times, only the last is used as it has no rules", name, counts[name])
+		}
+	}
+
+	if api == nil {
+		return problems
+	}
+
+	// Spec parameters the db can't fill
+	for _, m := range missingParameters(*api, db, opts) {
+		problem("missing: %s parameter %s has no db entry → %s", m.In, m.Name, strings.Join(m.Operations, ", "))
+	}
+
+	return problems
+}
+
+// A rule as written in cfg
+func ruleString(tuple *cfg.Tuple) string {
+	var out []string
+	for _, attr := range tuple.Attributes {
+		if attr.Value == "" {
+			out = append(out, attr.Name)
+			continue
+		}
+		out = append(out, attr.Name+"="+strconv.Quote(attr.Value))
+	}
+
+	return strings.Join(out, " ")
+}
+
+// Is an attribute known for a rule keyword?
+func known(keyword, attribute string) bool {
+	switch attribute {
+	case "title", "path", "operation":
+		return true
+	}
+
+	for _, a := range ruleAttributes[keyword] {
+		if a == attribute {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Do rules match an operation, bad regexes are reported on their own
+func matchRules(tuples []*cfg.Tuple, path, operation, title string) bool {
+	matched, _ := generator.MatchTuples(tuples, path, operation, title)
+	return matched
+}
+
+// Check a value's faker kind or template, if any
+func checkValue(problem func(string, ...interface{}), name, value string) {
+	if strings.HasPrefix(value, generator.FakerPrefix) {
+		if _, err := generator.Fake(strings.TrimPrefix(value, generator.FakerPrefix)); err != nil {
+			problem("err: %s: %v", name, err)
+		}
+		return
+	}
+
+	if _, err := generator.Evaluate(value); err != nil {
+		problem("err: %s: invalid value template → %v", name, err)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/seh-msft/generator/pkg/generator"
+)
+
+// Environment variable holding the passphrase for an encrypted db
+// Passphrases aren't flags so they stay out of shell history and process listings
+const dbPassphraseEnv = generator.EnvPrefix + "DB_PASSPHRASE"
+
+// Headers of age-encrypted files, binary and armored
+var ageHeaders = []string{"age-encryption.org/", armor.Header}
+
+// Decrypt a db if it's age-encrypted, otherwise read it as is
+// The name loses its .age suffix, so "db.yaml.age" is read as YAML
+func decryptDb(r io.Reader, name string) (io.Reader, string, error) {
+	br := bufio.NewReader(r)
+	name = strings.TrimSuffix(name, ".age")
+
+	encrypted, armored := ageEncrypted(br)
+	if !encrypted {
+		return br, name, nil
+	}
+
+	identities, err := dbIdentities()
+	if err != nil {
+		return nil, name, err
+	}
+
+	var src io.Reader = br
+	if armored {
+		src = armor.NewReader(br)
+	}
+
+	plain, err := age.Decrypt(src, identities...)
+	if err != nil {
+		return nil, name, errors.New("could not decrypt db → " + err.Error())
+	}
+
+	return plain, name, nil
+}
+
+// Whether a db is age-encrypted, and if so whether it's armored, without reading past its header
+func ageEncrypted(br *bufio.Reader) (encrypted, armored bool) {
+	for _, header := range ageHeaders {
+		if peek, _ := br.Peek(len(header)); string(peek) == header {
+			encrypted, armored = true, header == armor.Header
+		}
+	}
+
+	return encrypted, armored
+}
+
+// Encrypt a db for writing to a name ending in .age, with the key it was read with
+func encryptDb(buf []byte) ([]byte, error) {
+	identities, err := dbIdentities()
+	if err != nil {
+		return nil, err
+	}
+
+	var recipients []age.Recipient
+	for _, identity := range identities {
+		switch identity := identity.(type) {
+		case *age.X25519Identity:
+			recipients = append(recipients, identity.Recipient())
+		case *age.ScryptIdentity:
+			recipient, err := age.NewScryptRecipient(os.Getenv(dbPassphraseEnv))
+			if err != nil {
+				return nil, err
+			}
+			// Passphrases must be a file's only recipient
+			recipients = []age.Recipient{recipient}
+		}
+	}
+
+	var out bytes.Buffer
+	w, err := age.Encrypt(&out, recipients...)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(buf)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+
+	return out.Bytes(), err
+}
+
+// The keys for an encrypted db, from -dbkey's identity file or the passphrase in the environment
+func dbIdentities() ([]age.Identity, error) {
+	if *dbKey != "" {
+		f, err := os.Open(*dbKey)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		return age.ParseIdentities(f)
+	}
+
+	if passphrase := os.Getenv(dbPassphraseEnv); passphrase != "" {
+		identity, err := age.NewScryptIdentity(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		return []age.Identity{identity}, nil
+	}
+
+	return nil, errors.New("db is encrypted, but there's no -dbkey or " + dbPassphraseEnv)
+}
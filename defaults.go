@@ -0,0 +1,32 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/seh-msft/cfg"
+)
+
+// Build the defaults record from a JSON db's list of defaults
+func jsonDefaults(raw json.RawMessage) (*cfg.Record, error) {
+	var defaults []map[string]interface{}
+	err := json.Unmarshal(raw, &defaults)
+	if err != nil {
+		return nil, err
+	}
+
+	record := &cfg.Record{Tuples: []*cfg.Tuple{{Attributes: []*cfg.Attribute{{Name: "defaults"}}}}}
+	for _, d := range defaults {
+		var attrs []*cfg.Attribute
+		for _, name := range []string{"type", "format", "value"} {
+			if v, ok := d[name]; ok {
+				attrs = append(attrs, &cfg.Attribute{Name: name, Value: jsonString(v)})
+			}
+		}
+		record.Tuples = append(record.Tuples, &cfg.Tuple{Attributes: attrs})
+	}
+
+	return record, nil
+}
@@ -0,0 +1,246 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Egress decides which hosts -listen may connect to on a caller's behalf
+// A host is allowed if it matches an Allow rule, or there are none, and no Deny rule
+type Egress struct {
+	Allow []hostRule
+	Deny  []hostRule
+
+	transport *http.Transport
+}
+
+// A host name, *.domain, or CIDR
+type hostRule struct {
+	name    string
+	network *net.IPNet
+}
+
+// Addresses -listen never connects to unless -denyhosts says otherwise
+// Loopback, private, and link-local networks, such as the cloud metadata service, are behind the server rather than out on the internet
+// Shared address space and NAT64 may be translated to private IPv4 addresses, so are denied too
+const privateHosts = "0.0.0.0/8,127.0.0.0/8,::/128,::1/128,10.0.0.0/8,172.16.0.0/12,192.168.0.0/16,100.64.0.0/10,fc00::/7,169.254.0.0/16,fe80::/10,64:ff9b::/96"
+
+// Hosts -listen may connect to, nil outside of -listen
+var egress *Egress
+
+// Denied is the error for a host we may not connect to
+type Denied struct {
+	Host string
+	IP   net.IP
+}
+
+func (d *Denied) Error() string {
+	if d.IP == nil || d.IP.String() == d.Host {
+		return "connecting to " + d.Host + " is not allowed"
+	}
+	return "connecting to " + d.Host + " (" + d.IP.String() + ") is not allowed"
+}
+
+// Build an egress policy from comma-separated allow and deny lists
+func newEgress(allow, deny string) (*Egress, error) {
+	var e Egress
+	var err error
+
+	e.Allow, err = parseHostRules(allow)
+	if err != nil {
+		return nil, err
+	}
+	e.Deny, err = parseHostRules(deny)
+	if err != nil {
+		return nil, err
+	}
+
+	// Connections are made directly to the addresses we checked, never through a proxy
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	e.transport = &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			host, port, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+
+			// Dial what we resolved, so a name can't change where it points after the check
+			ips, err := e.resolve(ctx, host)
+			if err != nil {
+				return nil, err
+			}
+			for _, ip := range ips {
+				var conn net.Conn
+				conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+				if err == nil {
+					return conn, nil
+				}
+			}
+			return nil, err
+		},
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+
+	return &e, nil
+}
+
+// Parse a comma-separated list of host names, *.domains, IPs, and CIDRs
+func parseHostRules(list string) ([]hostRule, error) {
+	var rules []hostRule
+	for _, s := range splitList(list) {
+		s = strings.ToLower(s)
+
+		if strings.Contains(s, "/") {
+			_, network, err := net.ParseCIDR(s)
+			if err != nil {
+				return nil, err
+			}
+			// IPv4-mapped networks, such as ::ffff:10.0.0.0/104, are their IPv4 networks, as addresses are matched as IPv4
+			if ones, bits := network.Mask.Size(); bits == 8*net.IPv6len && ones >= 96 && network.IP.To4() != nil {
+				network = &net.IPNet{IP: network.IP.To4(), Mask: net.CIDRMask(ones-96, 8*net.IPv4len)}
+			}
+			rules = append(rules, hostRule{network: network})
+			continue
+		}
+
+		if ip := net.ParseIP(s); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			rules = append(rules, hostRule{network: &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}})
+			continue
+		}
+
+		if strings.Contains(strings.TrimPrefix(s, "*."), "*") || strings.ContainsAny(s, ":") {
+			return nil, errors.New("bad host " + s + ", want a name, *.domain, IP, or CIDR")
+		}
+		rules = append(rules, hostRule{name: s})
+	}
+
+	return rules, nil
+}
+
+// Does a rule cover a host, by name or by the address it resolved to
+func (r hostRule) matches(host string, ip net.IP) bool {
+	if r.network != nil {
+		// IPv4-mapped IPv6 addresses, such as ::ffff:127.0.0.1, are the IPv4 addresses they reach
+		if v4 := ip.To4(); v4 != nil {
+			ip = v4
+		}
+		return ip != nil && r.network.Contains(ip)
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if strings.HasPrefix(r.name, "*.") {
+		return strings.HasSuffix(host, r.name[1:])
+	}
+	return host == r.name
+}
+
+// May we connect to a host at an address
+func (e *Egress) permits(host string, ip net.IP) bool {
+	matched := func(rules []hostRule) bool {
+		for _, r := range rules {
+			if r.matches(host, ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return (len(e.Allow) == 0 || matched(e.Allow)) && !matched(e.Deny)
+}
+
+// Resolve a host, failing if it or any of its addresses isn't allowed
+func (e *Egress) resolve(ctx context.Context, host string) ([]net.IP, error) {
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+
+	for _, ip := range ips {
+		if !e.permits(host, ip) {
+			return nil, &Denied{host, ip}
+		}
+	}
+
+	return ips, nil
+}
+
+// Check we may connect to the host of a URL, before trying to
+func (e *Egress) check(ctx context.Context, rawurl string) error {
+	if e == nil {
+		return nil
+	}
+
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return err
+	}
+	if u.Hostname() == "" {
+		return fmt.Errorf("no host in %q", rawurl)
+	}
+
+	_, err = e.resolve(ctx, u.Hostname())
+	return err
+}
+
+// Whether -allowhosts names the host of a URL or target, such as "api.contoso.com:8443" or "://api.contoso.com", rather than merely not denying it
+func (e *Egress) allowListed(ctx context.Context, rawurl string) bool {
+	if e == nil || len(e.Allow) == 0 {
+		return false
+	}
+
+	u, err := url.Parse(rawurl)
+	if err != nil || u.Host == "" {
+		u, err = url.Parse("//" + strings.TrimPrefix(rawurl, "://"))
+	}
+	if err != nil || u.Hostname() == "" {
+		return false
+	}
+
+	_, err = e.resolve(ctx, u.Hostname())
+	return err == nil
+}
+
+// Transport for connecting out on a caller's behalf, nil for the default
+func (e *Egress) roundTripper() http.RoundTripper {
+	if e == nil {
+		return nil
+	}
+	return e.transport
+}
+
+// Refuse a URL a caller gave, if we may not connect to it
+func refuseHost(ctx context.Context, what, rawurl string) *apiError {
+	err := egress.check(ctx, rawurl)
+	var denied *Denied
+	if errors.As(err, &denied) {
+		return &apiError{http.StatusForbidden, "Error: " + what + " is not allowed → " + err.Error(), false}
+	}
+
+	// Failing to resolve is left to the connection itself to report
+	return nil
+}
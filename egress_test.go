@@ -0,0 +1,131 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestParseHostRules(t *testing.T) {
+	tests := []struct {
+		list    string
+		rules   int
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"api.contoso.com, *.contoso.com", 2, false},
+		{"10.0.0.0/8,fc00::/7", 2, false},
+		{"127.0.0.1,::1", 2, false},
+		{"::ffff:10.0.0.0/104", 1, false},
+		{privateHosts, 12, false},
+		{"10.0.0.0/33", 0, true},
+		{"api.*.com", 0, true},
+		{"**.contoso.com", 0, true},
+		{"host:8080", 0, true},
+	}
+
+	for _, test := range tests {
+		rules, err := parseHostRules(test.list)
+		if (err != nil) != test.wantErr {
+			t.Errorf("parseHostRules(%q) error = %v, want error %v", test.list, err, test.wantErr)
+			continue
+		}
+		if len(rules) != test.rules {
+			t.Errorf("parseHostRules(%q) = %d rules, want %d", test.list, len(rules), test.rules)
+		}
+	}
+}
+
+func TestPermits(t *testing.T) {
+	tests := []struct {
+		allow, deny string
+		host, ip    string
+		want        bool
+	}{
+		// The default deny list
+		{"", privateHosts, "api.contoso.com", "20.1.2.3", true},
+		{"", privateHosts, "localhost", "127.0.0.1", false},
+		{"", privateHosts, "::", "::", false},
+		{"", privateHosts, "::1", "::1", false},
+		{"", privateHosts, "metadata", "169.254.169.254", false},
+		{"", privateHosts, "cgnat", "100.64.0.1", false},
+		{"", privateHosts, "nat64", "64:ff9b::a00:1", false},
+		{"", privateHosts, "ula", "fd00::1", false},
+		{"", privateHosts, "10.1.2.3", "10.1.2.3", false},
+		{"", privateHosts, "172.31.255.255", "172.31.255.255", false},
+		{"", privateHosts, "172.32.0.1", "172.32.0.1", true},
+		{"", privateHosts, "2001:db8::1", "2001:db8::1", true},
+
+		// IPv4-mapped addresses are their IPv4 addresses, whichever way a rule is written
+		{"", privateHosts, "::ffff:127.0.0.1", "::ffff:127.0.0.1", false},
+		{"", privateHosts, "::ffff:10.0.0.1", "::ffff:10.0.0.1", false},
+		{"", "::ffff:10.0.0.0/104", "10.0.0.1", "10.0.0.1", false},
+		{"", "::ffff:10.0.0.0/104", "11.0.0.1", "11.0.0.1", true},
+
+		// Allow rules, by name, wildcard, and address
+		{"api.contoso.com", privateHosts, "api.contoso.com", "20.1.2.3", true},
+		{"api.contoso.com", privateHosts, "API.Contoso.com.", "20.1.2.3", true},
+		{"api.contoso.com", privateHosts, "evil.com", "20.1.2.3", false},
+		{"*.contoso.com", privateHosts, "a.b.contoso.com", "20.1.2.3", true},
+		{"*.contoso.com", privateHosts, "contoso.com", "20.1.2.3", false},
+		{"*.contoso.com", privateHosts, "evilcontoso.com", "20.1.2.3", false},
+		{"20.0.0.0/8", privateHosts, "anything", "20.1.2.3", true},
+		{"20.0.0.0/8", privateHosts, "anything", "21.1.2.3", false},
+
+		// Deny wins over allow
+		{"*.contoso.com", privateHosts, "internal.contoso.com", "10.0.0.5", false},
+		{"10.0.0.5", "", "internal", "10.0.0.5", true},
+		{"", "", "localhost", "127.0.0.1", true},
+	}
+
+	for _, test := range tests {
+		e, err := newEgress(test.allow, test.deny)
+		if err != nil {
+			t.Fatalf("newEgress(%q, %q) → %v", test.allow, test.deny, err)
+		}
+
+		got := e.permits(test.host, net.ParseIP(test.ip))
+		if got != test.want {
+			t.Errorf("allow %q deny %q: permits(%q, %s) = %v, want %v", test.allow, test.deny, test.host, test.ip, got, test.want)
+		}
+	}
+}
+
+func TestAllowListed(t *testing.T) {
+	e, err := newEgress("20.1.2.3,2001:db8::1", privateHosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		rawurl string
+		want   bool
+	}{
+		{"https://20.1.2.3/spec.json", true},
+		{"20.1.2.3:8443", true},
+		{"://20.1.2.3", true},
+		{"://[2001:db8::1]:8443", true},
+		{"https://20.1.2.4/", false},
+		{"://127.0.0.1", false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		got := e.allowListed(context.Background(), test.rawurl)
+		if got != test.want {
+			t.Errorf("allowListed(%q) = %v, want %v", test.rawurl, got, test.want)
+		}
+	}
+
+	// Without -allowhosts, nothing is allow listed, even what isn't denied
+	open, err := newEgress("", privateHosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if open.allowListed(context.Background(), "https://20.1.2.3/") {
+		t.Error("allowListed without -allowhosts = true, want false")
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Most result documents indexed per bulk call
+const elasticBatch = 1000
+
+// Document is a single result as indexed into Elasticsearch/OpenSearch
+type Document struct {
+	Timestamp   string  `json:"@timestamp"`
+	Run         string  `json:"run"`
+	API         string  `json:"api"`
+	Server      string  `json:"server"`
+	Method      string  `json:"method"`
+	Path        string  `json:"path"` // OpenAPI path template
+	URL         string  `json:"url"`
+	OperationID string  `json:"operationId,omitempty"`
+	Variant     string  `json:"variant,omitempty"`
+	RequestID   string  `json:"requestId,omitempty"`
+	Status      int     `json:"status"`
+	LatencyMs   float64 `json:"latency_ms"`
+	Verdict     string  `json:"verdict"`
+}
+
+// Bulk-index per-request result documents into an Elasticsearch/OpenSearch index
+// Credentials are taken from ELASTIC_API_KEY or ELASTIC_USERNAME and ELASTIC_PASSWORD
+func exportElastic(base, index, title string, sus, ok []Set) error {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+
+	var docs []Document
+	document := func(set Set, verdict string) Document {
+		return Document{
+			Timestamp:   now,
+			Run:         runID,
+			API:         title,
+			Server:      set.Request.Host,
+			Method:      strings.ToUpper(set.Request.Request.Method),
+			Path:        set.Request.Path,
+			URL:         redact(set.Request.URL.String()),
+			OperationID: set.Request.Method.OperationID,
+			Variant:     set.Request.Variant,
+			RequestID:   requestID(set.Request),
+			Status:      set.Response.StatusCode,
+			LatencyMs:   float64(set.Response.Latency) / float64(time.Millisecond),
+			Verdict:     verdict,
+		}
+	}
+
+	for _, bad := range sus {
+		docs = append(docs, document(bad, "suspicious"))
+	}
+	for _, set := range ok {
+		docs = append(docs, document(set, "conformant"))
+	}
+
+	client := &http.Client{Timeout: 60 * time.Second}
+	for len(docs) > 0 {
+		n := len(docs)
+		if n > elasticBatch {
+			n = elasticBatch
+		}
+
+		// Bulk bodies are an action line followed by a document line
+		var body bytes.Buffer
+		enc := json.NewEncoder(&body)
+		for _, doc := range docs[:n] {
+			enc.Encode(map[string]interface{}{"index": map[string]string{"_index": index}})
+			enc.Encode(doc)
+		}
+		docs = docs[n:]
+
+		req, err := http.NewRequest("POST", strings.TrimSuffix(base, "/")+"/_bulk", &body)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/x-ndjson")
+
+		if key := os.Getenv("ELASTIC_API_KEY"); key != "" {
+			req.Header.Set("Authorization", "ApiKey "+key)
+		} else if user := os.Getenv("ELASTIC_USERNAME"); user != "" {
+			req.SetBasicAuth(user, os.Getenv("ELASTIC_PASSWORD"))
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		buf, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("bulk index responded %s → %s", resp.Status, string(buf))
+		}
+
+		// Bulk calls succeed as a whole even if items fail
+		var result struct {
+			Errors bool `json:"errors"`
+		}
+		if json.Unmarshal(buf, &result) == nil && result.Errors {
+			return fmt.Errorf("bulk index had item errors → %s", string(buf))
+		}
+	}
+
+	return nil
+}
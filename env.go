@@ -0,0 +1,107 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/seh-msft/cfg"
+)
+
+// References to environment variables, as $ENV{NAME}
+var envReference = regexp.MustCompile(`\$ENV\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Expand $ENV{NAME} references, unset variables are an error
+func expandEnv(s string) (string, error) {
+	if !strings.Contains(s, "$ENV{") {
+		return s, nil
+	}
+
+	var missing []string
+	out := envReference.ReplaceAllStringFunc(s, func(ref string) string {
+		name := envReference.FindStringSubmatch(ref)[1]
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return value
+	})
+
+	if len(missing) > 0 {
+		return "", errors.New("environment variable not set → " + strings.Join(missing, ", "))
+	}
+
+	return out, nil
+}
+
+// Resolve a value from flags or the db
+// $ENV{NAME} references are expanded, then a Key Vault secret URI or `vault:path#key` reference is replaced by its secret
+func resolveValue(s string) (string, error) {
+	s, err := expandEnv(s)
+	if err != nil {
+		return "", err
+	}
+
+	if _, ok := keyVaultResource(s); ok {
+		return keyVault.Secret(s)
+	}
+
+	if strings.HasPrefix(s, "vault:") {
+		return hashiVault.Secret(s)
+	}
+
+	return s, nil
+}
+
+// Resolve string flags given on the command line
+func expandFlags() error {
+	var err error
+	flag.Visit(func(f *flag.Flag) {
+		getter, ok := f.Value.(flag.Getter)
+		if !ok || err != nil {
+			return
+		}
+		s, ok := getter.Get().(string)
+		if !ok {
+			return
+		}
+
+		var value string
+		value, err = resolveValue(s)
+		if err == nil && value != s {
+			err = f.Value.Set(value)
+		}
+	})
+
+	return err
+}
+
+// Resolve db values
+// Values from the environment or a vault are treated as secrets
+func expandDb(db cfg.Cfg) error {
+	for _, record := range db.Records {
+		for _, tuple := range record.Tuples {
+			for _, attr := range tuple.Attributes {
+				value, err := resolveValue(attr.Value)
+				if err != nil {
+					return errors.New(attr.Name + ": " + err.Error())
+				}
+				if value == attr.Value {
+					continue
+				}
+
+				attr.Value = value
+				if !*noRedact {
+					redactSecret(value)
+				}
+			}
+		}
+	}
+
+	return nil
+}
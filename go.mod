@@ -3,6 +3,12 @@ module github.com/seh-msft/generator
 go 1.16
 
 require (
+	filippo.io/age v1.2.1
+	github.com/Azure/go-ntlmssp v0.0.0-20221128193559-754e69321358
+	github.com/mattn/go-sqlite3 v1.14.22
 	github.com/seh-msft/cfg v0.0.0-20210114223223-348cc89c9d0c
 	github.com/seh-msft/openapi v0.0.0-20210616183003-5b1ff0059ea1
+	google.golang.org/grpc v1.56.3
+	google.golang.org/protobuf v1.30.0
+	gopkg.in/yaml.v3 v3.0.1
 )
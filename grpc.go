@@ -0,0 +1,300 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Name of the gRPC service, as per generator.proto
+const grpcService = "generator.v1.Generator"
+
+// GRPCServer offers generation over gRPC, with the options, authentication, and limits of -listen
+// Options are the JSON options of POST /v1/generator as a google.protobuf.Struct, so no generated code is needed
+type GRPCServer struct {
+	Auth  *ServerAuth // Who may call, nil for anyone
+	Limit *RateLimit  // How often each caller may, nil for no limit
+}
+
+// What the service descriptor dispatches to
+type generatorService interface {
+	Generate(ctx context.Context, options *structpb.Struct) (*structpb.Value, error)
+	Run(options *structpb.Struct, stream grpc.ServerStream) error
+}
+
+// The service as generator.proto describes it
+var grpcServiceDesc = grpc.ServiceDesc{
+	ServiceName: grpcService,
+	HandlerType: (*generatorService)(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "Generate",
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			options := new(structpb.Struct)
+			if err := dec(options); err != nil {
+				return nil, err
+			}
+			return srv.(generatorService).Generate(ctx, options)
+		},
+	}},
+	Streams: []grpc.StreamDesc{{
+		StreamName: "Run",
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			options := new(structpb.Struct)
+			if err := stream.RecvMsg(options); err != nil {
+				return err
+			}
+			return srv.(generatorService).Run(options, stream)
+		},
+		ServerStreams: true,
+	}},
+	Metadata: "generator.proto",
+}
+
+// gRPC codes for the HTTP statuses generation fails with
+var grpcCodes = map[int]codes.Code{
+	http.StatusBadRequest:            codes.InvalidArgument,
+	http.StatusUnauthorized:          codes.Unauthenticated,
+	http.StatusForbidden:             codes.PermissionDenied,
+	http.StatusNotFound:              codes.NotFound,
+	http.StatusGone:                  codes.Canceled,
+	http.StatusRequestEntityTooLarge: codes.ResourceExhausted,
+	http.StatusTooManyRequests:       codes.ResourceExhausted,
+	http.StatusBadGateway:            codes.Unavailable,
+	http.StatusServiceUnavailable:    codes.Unavailable,
+	http.StatusGatewayTimeout:        codes.DeadlineExceeded,
+}
+
+// Build a gRPC server for the service, over TLS if given a certificate
+func newGRPCServer(s *GRPCServer, cert, key string) (*grpc.Server, error) {
+	opts := []grpc.ServerOption{grpc.MaxRecvMsgSize(int(*maxBody))}
+	if cert != key {
+		creds, err := credentials.NewServerTLSFromFile(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+
+	gs := grpc.NewServer(opts...)
+	gs.RegisterService(&grpcServiceDesc, s)
+
+	return gs, nil
+}
+
+// Serve gRPC on a port, sending why it stopped on failed
+func serveGRPC(gs *grpc.Server, port string, failed chan<- error) {
+	l, err := net.Listen("tcp", port)
+	if err != nil {
+		failed <- err
+		return
+	}
+
+	emit("Listening for gRPC on localhost" + port + " …")
+	failed <- gs.Serve(l)
+}
+
+// Stop serving gRPC once calls under way are answered, or the context ends
+func stopGRPC(ctx context.Context, gs *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		gs.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		warn("warn: gRPC calls were still under way at shutdown")
+		gs.Stop()
+	}
+}
+
+// Generate, and optionally replay, answering with the results
+// JSON results are a structured value, other formats a string
+func (s *GRPCServer) Generate(ctx context.Context, options *structpb.Struct) (*structpb.Value, error) {
+	var result *structpb.Value
+	err := s.call(ctx, "Generate", options, func(ctx context.Context, opts JobOptions) *apiError {
+		out, e := runJob(ctx, opts, nil)
+		if e != nil {
+			return e
+		}
+
+		result, e = outputValue(out)
+		return e
+	})
+
+	return result, err
+}
+
+// Generate, and optionally replay, streaming each event as a job's event stream would, then the results
+func (s *GRPCServer) Run(options *structpb.Struct, stream grpc.ServerStream) error {
+	return s.call(stream.Context(), "Run", options, func(ctx context.Context, opts JobOptions) *apiError {
+		// Events arrive one at a time, once a send fails the caller has gone
+		var sendErr error
+		send := func(v interface{}) {
+			if sendErr != nil {
+				return
+			}
+			msg, err := toStruct(v)
+			if err == nil {
+				err = stream.SendMsg(msg)
+			}
+			sendErr = err
+		}
+
+		out, e := runJob(ctx, opts, func(event JobEvent) { send(event) })
+		if e != nil {
+			return e
+		}
+
+		result, e := outputValue(out)
+		if e != nil {
+			return e
+		}
+		send(map[string]interface{}{"type": "done", "result": result.AsInterface()})
+		if sendErr != nil {
+			return &apiError{http.StatusInternalServerError, "Error: could not send results → " + sendErr.Error(), false}
+		}
+
+		return nil
+	})
+}
+
+// Authenticate, limit, and queue a call, as -listen would, then run it and audit it
+func (s *GRPCServer) call(ctx context.Context, method string, options *structpb.Struct, run func(context.Context, JobOptions) *apiError) error {
+	entry := &AuditEntry{Time: time.Now().UTC(), Event: "call", Method: "gRPC", Path: "/" + grpcService + "/" + method}
+	if p, ok := peer.FromContext(ctx); ok {
+		entry.Remote = p.Addr.String()
+	}
+
+	e := s.serve(ctx, entry, options, run)
+	entry.Status = http.StatusOK
+	if e != nil {
+		entry.Status, entry.Error = e.Code, redact(e.Message)
+	}
+	entry.Duration = time.Since(entry.Time).Milliseconds()
+	writeAudit(entry)
+
+	if e != nil {
+		code, ok := grpcCodes[e.Code]
+		if !ok {
+			code = codes.Internal
+		}
+		return status.Error(code, e.Message)
+	}
+
+	return nil
+}
+
+// Run a call on behalf of its caller
+func (s *GRPCServer) serve(ctx context.Context, entry *AuditEntry, options *structpb.Struct, run func(context.Context, JobOptions) *apiError) *apiError {
+	// Credentials are in metadata, as they'd be in headers
+	md, _ := metadata.FromIncomingContext(ctx)
+	r := &http.Request{Header: make(http.Header)}
+	for _, name := range []string{"X-API-Key", "Authorization"} {
+		if values := md.Get(name); len(values) > 0 {
+			r.Header.Set(name, values[0])
+		}
+	}
+
+	if s.Auth != nil {
+		name, err := s.Auth.authenticate(r)
+		if err != nil {
+			return &apiError{http.StatusUnauthorized, "Error: unauthorized → " + err.Error(), false}
+		}
+		entry.Caller = name
+	}
+
+	if s.Limit != nil {
+		client := entry.Caller
+		if client == "" {
+			client, _, _ = net.SplitHostPort(entry.Remote)
+		}
+		if ok, _ := s.Limit.take(client, time.Now()); !ok {
+			return &apiError{http.StatusTooManyRequests, fmt.Sprint("Error: too many requests, limit is ", s.Limit.PerMinute, " a minute"), false}
+		}
+	}
+
+	// Options are as they'd be in JSON
+	opts := JobOptions{Options: serverDefaults()}
+	buf, err := json.Marshal(options.AsMap())
+	if err == nil {
+		err = json.Unmarshal(buf, &opts)
+	}
+	if err != nil {
+		return &apiError{http.StatusBadRequest, "Error: " + err.Error(), false}
+	}
+	e := prepareOptions(ctx, &opts)
+	if e != nil {
+		return e
+	}
+	if opts.Callback != "" {
+		return &apiError{http.StatusBadRequest, "Error: callbacks are for jobs, POST /v1/jobs instead", false}
+	}
+	entry.options(opts)
+
+	// Wait our turn, as jobs do
+	err = enqueue()
+	if err == errDraining {
+		return &apiError{http.StatusServiceUnavailable, "Error: " + err.Error(), false}
+	}
+	if err != nil {
+		return &apiError{http.StatusTooManyRequests, "Error: " + err.Error(), false}
+	}
+	if !acquire(ctx) {
+		running.Done()
+		return errCancelled
+	}
+	defer release()
+
+	return run(ctx, opts)
+}
+
+// Results as a value, structured if they're JSON
+func outputValue(out *Output) (*structpb.Value, *apiError) {
+	if out.ContentType != "application/json" {
+		return structpb.NewStringValue(string(out.Body)), nil
+	}
+
+	var v interface{}
+	err := json.Unmarshal(out.Body, &v)
+	if err != nil {
+		return nil, &apiError{http.StatusInternalServerError, "Error: could not read results → " + err.Error(), false}
+	}
+	value, err := structpb.NewValue(v)
+	if err != nil {
+		return nil, &apiError{http.StatusInternalServerError, "Error: could not convert results → " + err.Error(), false}
+	}
+
+	return value, nil
+}
+
+// Anything which marshals to a JSON object, as a Struct
+func toStruct(v interface{}) (*structpb.Struct, error) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var m map[string]interface{}
+	err = json.Unmarshal(buf, &m)
+	if err != nil {
+		return nil, err
+	}
+
+	return structpb.NewStruct(m)
+}
@@ -0,0 +1,162 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// HAR 1.2 as per http://www.softwareishard.com/blog/har-12-spec/, so results open in browser dev tools and proxies
+type harLog struct {
+	Log struct {
+		Version string `json:"version"`
+		Creator struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"creator"`
+		Entries []harEntry `json:"entries"`
+	} `json:"log"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value,omitempty"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         struct {
+		Send    float64 `json:"send"`
+		Wait    float64 `json:"wait"`
+		Receive float64 `json:"receive"`
+	} `json:"timings"`
+	Comment string `json:"comment"` // Verdict
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *struct {
+		MimeType string `json:"mimeType"`
+		Text     string `json:"text"`
+	} `json:"postData,omitempty"`
+	HeadersSize int `json:"headersSize"`
+	BodySize    int `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     struct {
+		Size     int    `json:"size"`
+		MimeType string `json:"mimeType"`
+		Text     string `json:"text"`
+	} `json:"content"`
+	RedirectURL string `json:"redirectURL"`
+	HeadersSize int    `json:"headersSize"`
+	BodySize    int    `json:"bodySize"`
+}
+
+// HAR output, each replayed request and its response, in the order they were built, secrets redacted
+func printHAR(w io.Writer, indent bool, requests []*Request, sus, ok []Set) error {
+	var out harLog
+	out.Log.Version = "1.2"
+	out.Log.Creator.Name, out.Log.Creator.Version = "generator", generatorVersion()
+	out.Log.Entries = []harEntry{}
+
+	suspicious := make(map[*Request]bool)
+	for _, set := range sus {
+		suspicious[set.Request] = true
+	}
+
+	f := &Findings{requests: requests, sus: sus, ok: ok}
+	for _, set := range f.results() {
+		e := exchange(set)
+		ms := float64(set.Response.Latency) / float64(time.Millisecond)
+
+		var entry harEntry
+		entry.StartedDateTime = runStarted.UTC().Format(time.RFC3339Nano)
+		entry.Time = ms
+		entry.Timings.Wait = ms
+		entry.Comment = "conformant"
+		if suspicious[set.Request] {
+			entry.Comment = "suspicious"
+		}
+
+		entry.Request = harRequest{
+			Method:      e.Request.Method,
+			URL:         e.Request.URL,
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(e.Request.Header),
+			QueryString: []harNameValue{},
+			HeadersSize: -1,
+			BodySize:    len(e.Request.Body),
+		}
+		for name, values := range set.Request.URL.Query() {
+			for _, v := range values {
+				entry.Request.QueryString = append(entry.Request.QueryString, harNameValue{Name: name, Value: redact(v)})
+			}
+		}
+		sort.SliceStable(entry.Request.QueryString, func(i, j int) bool {
+			return entry.Request.QueryString[i].Name < entry.Request.QueryString[j].Name
+		})
+		if e.Request.Body != "" {
+			entry.Request.PostData = &struct {
+				MimeType string `json:"mimeType"`
+				Text     string `json:"text"`
+			}{set.Request.Header.Get("Content-Type"), e.Request.Body}
+		}
+
+		entry.Response = harResponse{
+			Status:      set.Response.StatusCode,
+			StatusText:  http.StatusText(set.Response.StatusCode),
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(e.Response.Header),
+			HeadersSize: -1,
+			BodySize:    len(e.Response.Body),
+		}
+		if set.Response.Proto != "" {
+			entry.Response.HTTPVersion = set.Response.Proto
+		}
+		entry.Response.Content.Size = len(e.Response.Body)
+		entry.Response.Content.MimeType = set.Response.Header.Get("Content-Type")
+		entry.Response.Content.Text = e.Response.Body
+
+		out.Log.Entries = append(out.Log.Entries, entry)
+	}
+
+	return newEncoder(w, indent).Encode(out)
+}
+
+// Headers as HAR name/value pairs, by name
+func harHeaders(h http.Header) []harNameValue {
+	out := []harNameValue{}
+	for name, values := range h {
+		for _, v := range values {
+			out = append(out, harNameValue{Name: name, Value: v})
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return strings.ToLower(out[i].Name) < strings.ToLower(out[j].Name)
+	})
+
+	return out
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// HashiVault resolves `vault:path#key` references against HashiCorp Vault
+// The server and token are from VAULT_ADDR and VAULT_TOKEN, as per the vault CLI
+type HashiVault struct {
+	secrets map[string]map[string]interface{} // Secret data by path, each is read once
+	client  *http.Client
+}
+
+// Secrets resolved for this run
+var hashiVault = &HashiVault{}
+
+// Secret reads a key from a secret, such as `vault:secret/data/myapp#token`
+// As '#' starts a comment in cfg files, `vault:secret/data/myapp:token` is the same
+// Both KV version 1 and version 2 secret engines are understood
+func (h *HashiVault) Secret(ref string) (string, error) {
+	if h.client == nil {
+		h.client = &http.Client{Timeout: 30 * time.Second}
+		h.secrets = make(map[string]map[string]interface{})
+	}
+
+	rest := strings.TrimPrefix(ref, "vault:")
+	i := strings.LastIndex(rest, "#")
+	if i < 0 {
+		i = strings.LastIndex(rest, ":")
+	}
+	if i < 1 || i == len(rest)-1 {
+		return "", errors.New("vault reference must be vault:path#key → " + ref)
+	}
+	path, key := strings.Trim(rest[:i], "/"), rest[i+1:]
+
+	data, ok := h.secrets[path]
+	if !ok {
+		var err error
+		data, err = h.read(path)
+		if err != nil {
+			return "", err
+		}
+		h.secrets[path] = data
+	}
+
+	value, ok := data[key]
+	if !ok {
+		return "", errors.New("no key " + key + " in vault secret " + path)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+
+	return fmt.Sprint(value), nil
+}
+
+// Read the data of a secret
+func (h *HashiVault) read(path string) (map[string]interface{}, error) {
+	addr, token := os.Getenv("VAULT_ADDR"), os.Getenv("VAULT_TOKEN")
+	if addr == "" || token == "" {
+		return nil, errors.New("VAULT_ADDR and VAULT_TOKEN not set")
+	}
+
+	req, err := http.NewRequest("GET", strings.TrimSuffix(addr, "/")+"/v1/"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if ns := os.Getenv("VAULT_NAMESPACE"); ns != "" {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var secret struct {
+		Data   map[string]interface{} `json:"data"`
+		Errors []string               `json:"errors"`
+	}
+	json.Unmarshal(buf, &secret)
+	if resp.StatusCode != 200 {
+		if len(secret.Errors) > 0 {
+			return nil, errors.New(resp.Status + ": " + strings.Join(secret.Errors, "; "))
+		}
+		return nil, errors.New("vault responded " + resp.Status)
+	}
+
+	chat("vault: read " + path + "\n")
+
+	// KV version 2 nests the data alongside metadata
+	if nested, ok := secret.Data["data"].(map[string]interface{}); ok {
+		if _, versioned := secret.Data["metadata"]; versioned {
+			return nested, nil
+		}
+	}
+
+	return secret.Data, nil
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/seh-msft/cfg"
+)
+
+// HeaderFlags are headers for every request, as per repeated `-H 'Name: value'` flags
+type HeaderFlags []string
+
+func (h *HeaderFlags) String() string {
+	return strings.Join(*h, ",")
+}
+
+func (h *HeaderFlags) Set(v string) error {
+	_, _, err := splitHeader(v)
+	if err != nil {
+		return err
+	}
+
+	*h = append(*h, v)
+	return nil
+}
+
+// A header as the name and value of `Name: value`
+func splitHeader(v string) (string, string, error) {
+	i := strings.Index(v, ":")
+	if i < 1 {
+		return "", "", errors.New("header must be 'Name: value' → " + v)
+	}
+
+	name, value := strings.TrimSpace(v[:i]), strings.TrimSpace(v[i+1:])
+	err := checkHeader(name, value)
+	if err != nil {
+		return "", "", err
+	}
+
+	return name, value, nil
+}
+
+// Check a header's name is a token and its value is a single line
+func checkHeader(name, value string) error {
+	if name == "" || strings.IndexFunc(name, func(r rune) bool {
+		return r <= ' ' || r >= 0x7f || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, r)
+	}) >= 0 {
+		return errors.New("bad header name → " + name)
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return errors.New("header value must be a single line → " + name)
+	}
+
+	return nil
+}
+
+// Is a record the headers record, such as:
+//
+//	headers
+//		X-Env=staging
+//		X-Feature-Flags='beta, new-checkout'
+//
+// Each attribute is a header for every request
+func isHeaders(record *cfg.Record) bool {
+	return len(record.Tuples) > 0 && len(record.Tuples[0].Attributes) > 0 && record.Tuples[0].Attributes[0].Name == "headers"
+}
+
+// The headers the db gives every request
+func dbHeaders(db cfg.Cfg) [][2]string {
+	var out [][2]string
+	for _, record := range db.Records {
+		if !isHeaders(record) {
+			continue
+		}
+
+		for i, tuple := range record.Tuples {
+			for j, attr := range tuple.Attributes {
+				if i == 0 && j == 0 {
+					continue
+				}
+				out = append(out, [2]string{attr.Name, attr.Value})
+			}
+		}
+	}
+
+	return out
+}
+
+// Build the headers record from a JSON db's object of headers
+func jsonHeaders(raw json.RawMessage) (*cfg.Record, error) {
+	var headers map[string]interface{}
+	err := json.Unmarshal(raw, &headers)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	record := &cfg.Record{Tuples: []*cfg.Tuple{{Attributes: []*cfg.Attribute{{Name: "headers"}}}}}
+	for _, name := range names {
+		record.Tuples = append(record.Tuples, &cfg.Tuple{Attributes: []*cfg.Attribute{{Name: name, Value: jsonString(headers[name])}}})
+	}
+
+	return record, nil
+}
+
+// Put the db's headers, then those of -H, on every request, replacing any of the same name
+// Headers named more than once by -H are sent more than once
+func applyHeaders(requests []*Request, flags HeaderFlags, db cfg.Cfg) error {
+	header := make(http.Header)
+	for _, h := range dbHeaders(db) {
+		err := checkHeader(h[0], h[1])
+		if err != nil {
+			return errors.New("headers: " + err.Error())
+		}
+		header.Set(h[0], h[1])
+	}
+
+	given := make(map[string]bool)
+	for _, v := range flags {
+		name, value, err := splitHeader(v)
+		if err != nil {
+			return err
+		}
+
+		name = http.CanonicalHeaderKey(name)
+		if !given[name] {
+			header.Del(name)
+			given[name] = true
+		}
+		header.Add(name, value)
+	}
+
+	for _, request := range requests {
+		for name, values := range header {
+			request.Header[name] = append([]string{}, values...)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"os/exec"
+	"strings"
+
+	"github.com/seh-msft/generator/pkg/generator"
+)
+
+// Hooks run around each replayed request, as per -hook
+var hooks []generator.Hook
+
+// HookFlags are repeatable -hook commands
+type HookFlags []string
+
+func (h *HookFlags) String() string {
+	return strings.Join(*h, ",")
+}
+
+func (h *HookFlags) Set(v string) error {
+	*h = append(*h, v)
+	return nil
+}
+
+// Build hooks from -hook commands
+func newHooks(commands []string) ([]generator.Hook, error) {
+	var out []generator.Hook
+	for _, command := range commands {
+		args := strings.Fields(command)
+		if len(args) < 1 {
+			return nil, errors.New("no command to hook with")
+		}
+		out = append(out, &execHook{args})
+	}
+
+	return out, nil
+}
+
+// An external command run as `command before` with the raw request on stdin, writing `Name: value` header lines to set,
+// then as `command after` with the raw response on stdin, failing the response by exiting non-zero
+type execHook struct {
+	args []string
+}
+
+// Before runs the command and sets the headers it writes, a failure stops the replay
+func (h *execHook) Before(req *http.Request) error {
+	dump, err := httputil.DumpRequestOut(req, true)
+	if err != nil {
+		return err
+	}
+
+	out, err := h.run("before", dump)
+	if err != nil {
+		return err
+	}
+
+	return setHeaderLines(req, out)
+}
+
+// After runs the command, its standard error saying why it failed the response, if it did
+func (h *execHook) After(req *http.Request, resp *generator.Response) error {
+	var dump bytes.Buffer
+	fmt.Fprintf(&dump, "%s %s\r\n", resp.Proto, resp.Status)
+	resp.Header.Write(&dump)
+	fmt.Fprintf(&dump, "\r\n%s", resp.Body)
+
+	_, err := h.run("after", dump.Bytes())
+	return err
+}
+
+// Run the command for a stage, with input on stdin
+func (h *execHook) run(stage string, input []byte) ([]byte, error) {
+	cmd := exec.Command(h.args[0], append(h.args[1:], stage)...)
+	cmd.Stdin = bytes.NewReader(input)
+	out, err := cmd.Output()
+	if err != nil {
+		var exit *exec.ExitError
+		if errors.As(err, &exit) && len(exit.Stderr) > 0 {
+			return nil, errors.New(err.Error() + " → " + strings.TrimSpace(string(exit.Stderr)))
+		}
+		return nil, err
+	}
+
+	return out, nil
+}
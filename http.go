@@ -4,225 +4,342 @@
 package main
 
 import (
-	"crypto/tls"
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/seh-msft/cfg"
+	"github.com/seh-msft/generator/pkg/generator"
 	"github.com/seh-msft/openapi"
+	"google.golang.org/grpc"
 )
 
-// Response represents an HTTP response - omit the interface from "http" package
-// For serialization
-type Response struct {
-	Status           string
-	StatusCode       int
-	Proto            string
-	ProtoMajor       int
-	ProtoMinor       int
-	Header           http.Header
-	Body             string
-	ContentLength    int64
-	TransferEncoding []string
-	Close            bool
-	Uncompressed     bool
-	TLS              *tls.ConnectionState
-}
-
-// Set pairs a request and response for output formatting
-type Set struct {
-	*Request
-	*Response
-}
-
 const utf8 = `<meta charset="utf-8">
 
 `
 
 // Handle '/' requests
 func rootHandler(w http.ResponseWriter, r *http.Request) {
+	if isV1(r) {
+		writeError(w, r, &apiError{http.StatusNotFound, "Error: no such route → " + r.URL.Path, false})
+		return
+	}
+
 	splash := utf8 + `
 <html>
 <h1>Generator API</h1>
 
-<p>You probably want to <code>POST /generator</code>.</p>
+<p>You probably want to <code>POST /v1/generator</code>.</p>
+
+<p>For large specifications, <code>POST /v1/jobs</code> with the same body, then poll <code>GET /v1/jobs/{id}</code> until it's done and fetch <code>GET /v1/jobs/{id}/result</code>.</p>
+
+<p>The options are described at <a href="/docs">/docs</a>, and the API itself at <a href="/openapi.json">/openapi.json</a>.</p>
 </html>
 `
 	w.Header().Add("Content-Type", "text/html")
 	fmt.Fprint(w, splash)
 }
 
-// Handle '/gen' API requests
-func genHandler(w http.ResponseWriter, r *http.Request) {
-	type Options struct {
-		Cfg     string `json:"cfg"`
-		CfgPath string `json:"cfgpath"`
-		API     string `json:"api"`
-		Auth    string `json:"auth"`
+// JobOptions is the body of a generation request, to POST /generator or POST /jobs
+type JobOptions struct {
+	Profile string `json:"profile"`
+	Cfg     string `json:"cfg"`
+	CfgPath string `json:"cfgpath"`
+	API     string `json:"api"`
+	Auth    string `json:"auth"`
+	Cookie  string `json:"cookie"`
+
+	// How requests are built and replayed, defaulting to the listener's flags
+	// Format is json, jsonl, junit, summary, ado, or gha, otherwise as per Accept
+	Options
+	ADO bool `json:"ado"`
+	GHA bool `json:"gha"`
+
+	Callback     string `json:"callback"`     // URL to post the job to once it's finished
+	CallbackFull bool   `json:"callbackfull"` // Include the job's output in the callback
+
+	cfgName string // File a profile's or upload's db was read from
+	spec    []byte // Uploaded spec, if any
+	trusted bool   // The db is a profile's, not the caller's
+	basic   string // The listener's -basic credentials, for targets it may send them to
+	apiKey  string // The listener's -apikey, likewise
+}
 
-		Target        string   `json:"target"`
-		NoAuth        bool     `json:"noauth"`
-		NoReplay      bool     `json:"noreplay"`
-		IgnoreMethods []string `json:"ignoremethods"`
-		ADO           bool     `json:"ado"`
+// The Authorization header value for the job's requests, if any
+func (opts JobOptions) authorization() string {
+	switch {
+	case opts.Auth != "":
+		return "Bearer " + opts.Auth
+	case opts.basic != "":
+		return basicAuthorization(opts.basic)
 	}
-	var opts Options
 
-	usage := `Usage via POST method:
+	return ""
+}
 
+// The format results were asked for in, if any
+func (opts JobOptions) format() string {
+	switch {
+	case opts.Format != "":
+		return opts.Format
+	case opts.ADO:
+		return "ado"
+	case opts.GHA:
+		return "gha"
+	}
 
-__OPTIONS__
+	return ""
+}
 
+// Output is the result of a generation request
+type Output struct {
+	ContentType string
+	Body        []byte
 
-{
-	"cfgpath":          string,            // URL for CFG file
-	"cfg":              string,            // Literal CFG file string
-	"api":              string,            // URL for OpenAPI JSON specification file
-	"auth":             string,            // Authorization: Bearer [thispart]
-	"target":           string,            // Hostname to replay built requests to
-	"noauth":           bool,              // Strip Authorization: and Cookie: headers
-	"noreplay":         bool,              // Do not replay built requests
-	"ignoremethods":    array of string,   // HTTP methods to ignore (PUT, PATCH, etc.)
-	"ado":              bool               // Use ADO output format for warnings, errors, etc. 
+	format string    // As per -format
+	report *Findings // What was found, if replayed, to write in other formats
 }
 
-Required fields: (cfg ⊻ cfgpath) ∧ (auth ⊻ noauth) ∧ api
+// apiError is a failed generation request, with the HTTP status to answer with
+type apiError struct {
+	Code    int
+	Message string
+	Usage   bool // Follow the message with where the options are described
+}
 
+func (e *apiError) Error() string {
+	return e.Message
+}
 
-__EXAMPLES__
+// Write an error, with usage if it calls for it, and note it in the audit entry
+// The versioned API answers with an envelope instead
+func writeError(w http.ResponseWriter, r *http.Request, e *apiError) {
+	auditFor(r).Error = redact(e.Message)
+	if isV1(r) {
+		writeEnvelope(w, e)
+		return
+	}
 
+	w.WriteHeader(e.Code)
+	fmt.Fprint(w, e.Message+"\n")
+	if e.Usage {
+		fmt.Fprint(w, "\n"+seeDocs+"\n")
+	}
+}
 
-Minimum required fields:
+// Handle '/gen' API requests
+func genHandler(w http.ResponseWriter, r *http.Request) {
+	// We only allow POST
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		writeError(w, r, &apiError{http.StatusMethodNotAllowed, "Error: generate with POST → " + seeDocs, false})
+		return
+	}
 
-{
-	"cfgpath":"http://somewhere/path/to.cfg",
-	"api":"http://somewhere/path/to/api.json",
-	"auth":"eyzSomeKindOfOauthKeyForAuth"
-}
+	opts, e := decodeOptions(w, r)
+	if e != nil {
+		writeError(w, r, e)
+		return
+	}
+	auditOptions(r, opts)
 
-Pass the literal CFG, change the target, don't use any auth:
+	// The caller is waiting for the answer already
+	if opts.Callback != "" {
+		writeError(w, r, &apiError{http.StatusBadRequest, "Error: callbacks are for jobs, POST /jobs instead", true})
+		return
+	}
 
-{
-	"cfg":"a=b\nc=d",
-	"api":"http://somewhere/path/to/api.json",
-	"auth":"",
-	"noauth": true,
-	"target": "1.2.3.4"
-}
+	// Answer in a format the caller can take, or don't start
+	format, e := resultFormat(r, opts)
+	if e != nil {
+		writeError(w, r, e)
+		return
+	}
 
-Don't replay and ignore PUT and PATCH methods:
+	// Wait our turn, as jobs do
+	err := enqueue()
+	if err != nil {
+		writeRefusal(w, r, err)
+		return
+	}
+	// A caller who gives up stops their requests
+	if !acquire(r.Context()) {
+		running.Done()
+		return
+	}
+	out, e := runJob(r.Context(), opts, nil)
+	release()
+	if e != nil {
+		writeError(w, r, e)
+		return
+	}
+	out, err = out.as(format)
+	if err != nil {
+		writeError(w, r, &apiError{http.StatusInternalServerError, "Error: could not marshal requests → " + err.Error(), false})
+		return
+	}
 
-{
-	"cfgpath":"http://somewhere/path/to.cfg",
-	"api":"http://somewhere/path/to/api.json",
-	"auth":"eyzSomeKindOfOauthKeyForAuth",
-	"noreplay": true,
-	"ignoremethods": ["PUT", "PATCH"]
+	w.Header().Set("Vary", "Accept")
+	w.Header().Add("Content-Type", out.ContentType)
+	w.Write(out.Body)
 }
 
+// Read and check the options of a generation request
+func decodeOptions(w http.ResponseWriter, r *http.Request) (JobOptions, *apiError) {
+	opts := JobOptions{Options: serverDefaults()}
 
-__RESPONSE FORMAT__
+	// Read POST body, within reason, as JSON or an upload of the documents themselves
+	r.Body = http.MaxBytesReader(w, r.Body, *maxBody)
+	var err error
+	if isUpload(r) {
+		err = decodeUpload(r, &opts)
+	} else {
+		err = json.NewDecoder(r.Body).Decode(&opts)
+	}
+	if err != nil && strings.Contains(err.Error(), "request body too large") {
+		return opts, &apiError{http.StatusRequestEntityTooLarge, fmt.Sprint("Error: request body is larger than ", *maxBody, " bytes"), false}
+	}
+	if err != nil && err != io.EOF {
+		return opts, &apiError{http.StatusBadRequest, fmt.Sprint("Error:", err), true}
+	}
 
+	e := prepareOptions(r.Context(), &opts)
+	return opts, e
+}
 
-JSON scheme:
+// Fill in and check the options of a generation request, however it came
+func prepareOptions(ctx context.Context, opts *JobOptions) *apiError {
+	// A profile fills in what the caller left out
+	if opts.Profile != "" {
+		p, ok := profiles[opts.Profile]
+		if !ok {
+			return &apiError{http.StatusBadRequest, "Error: no such profile → " + opts.Profile, false}
+		}
 
-{
-	"Info": {
-		"Server": "somewhere/something",
-		"Missed":{"someThing":4,"aValue":1}
-	},
-	"Conformant": [
-		{
-		"Method": "GET",
-		"HTTPCode": 401,
-		"Path": "/firstpath/orange",
-		"Body": ""
-		},
-		{
-		"Method": "POST",
-		"HTTPCode": 500,
-		"Path": "/secondpath/banana",
-		"Body": "don't mind me"
-		},
-	],
-	"Suspicious": [
-		{
-			"Method": "GET",
-			"HTTPCode": 200,
-			"Path": "/thirdpath/dubious/call",
-			"Body": ""
-		},
-		{
-			"Method": "POST",
-			"HTTPCode": 200,
-			"Path": "/fourthpath/account/update",
-			"Body": "some kind of body"
-		},
-	]
-}  
+		err := p.apply(ctx, opts)
+		if err == errServerToken {
+			return &apiError{http.StatusForbidden, "Error: " + err.Error(), true}
+		}
+		if err != nil {
+			return &apiError{http.StatusInternalServerError, "Error: could not use profile " + opts.Profile + " → " + err.Error(), false}
+		}
+	}
 
-`
-	// We only allow POST
-	if r.Method != "POST" {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		fmt.Fprint(w, usage)
-		return
+	// Fall back to the listener's -authfile token, for targets the caller can't choose freely
+	own := opts.Auth != "" || opts.Cookie != ""
+	if opts.Auth == "" && !opts.NoAuth && authFile != nil {
+		if !egress.allowListed(ctx, opts.Target) {
+			return &apiError{http.StatusForbidden, "Error: " + errServerToken.Error(), true}
+		}
+		token, err := authFile.Token()
+		if err != nil {
+			return &apiError{http.StatusInternalServerError, "Error: could not read token file → " + err.Error(), false}
+		}
+		opts.Auth = token
 	}
 
-	// Read POST body
-	dec := json.NewDecoder(r.Body)
-	err := dec.Decode(&opts)
-	if err != nil && err != io.EOF {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, "Error:", err, "\n\n")
-		fmt.Fprintln(w, usage)
-		return
+	// The listener's own -basic and -apikey go only where its token may, and only if the caller gave no credentials
+	if !own && !opts.NoAuth && (*basic != "" || *apiKey != "") {
+		if !egress.allowListed(ctx, opts.Target) {
+			return &apiError{http.StatusForbidden, "Error: " + errServerToken.Error(), true}
+		}
+		if opts.Auth == "" {
+			opts.basic = *basic
+		}
+		opts.apiKey = *apiKey
 	}
 
 	// Combinatorics
-	if (opts.CfgPath == "" && opts.Cfg == "") || opts.API == "" || (opts.Auth == "" && !opts.NoAuth) {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, "Error: all JSON fields are mandatory (cfg ⊻ cfgPath)\n\n")
-		fmt.Fprintln(w, usage)
-		return
+	if (opts.CfgPath == "" && opts.Cfg == "") || (opts.API == "" && opts.spec == nil) || (opts.Auth == "" && opts.Cookie == "" && opts.basic == "" && opts.apiKey == "" && !opts.NoAuth) {
+		return &apiError{http.StatusBadRequest, "Error: all JSON fields are mandatory (cfg ⊻ cfgPath)", true}
 	}
 
 	// We _need_ a CFG
 	if opts.Cfg != "" && opts.CfgPath != "" {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, "Error: provide cfg ⊻ cfgPath\n\n")
-		fmt.Fprintln(w, usage)
-		return
+		return &apiError{http.StatusBadRequest, "Error: provide cfg ⊻ cfgPath", true}
+	}
+
+	if _, ok := formatTypes[opts.Format]; opts.Format != "" && !ok {
+		return &apiError{http.StatusBadRequest, "Error: unknown format → " + opts.Format, true}
+	}
+
+	err := opts.Options.check()
+	if err == nil {
+		err = opts.Options.bound()
+	}
+	if err != nil {
+		return &apiError{http.StatusBadRequest, "Error: bad replay options → " + err.Error(), true}
 	}
 
-	/* Valid request format */
+	if opts.Callback != "" {
+		err = checkCallback(opts.Callback)
+		if err != nil {
+			return &apiError{http.StatusBadRequest, "Error: bad callback → " + err.Error(), true}
+		}
+	}
+
+	// We only connect where we're allowed to
+	urls := []struct{ what, url string }{{"api", opts.API}, {"cfgPath", opts.CfgPath}, {"target", opts.Target}, {"callback", opts.Callback}}
+	for _, u := range urls {
+		if u.url == "" || (u.what == "api" && opts.spec != nil) {
+			continue
+		}
+		if e := refuseHost(ctx, u.what, u.url); e != nil {
+			return e
+		}
+	}
+
+	return nil
+}
+
+// Generate, and optionally replay, as per a request's options
+// Progress, if not nil, is told of each request as it's built, replayed, and judged
+func runJob(ctx context.Context, opts JobOptions, progress func(JobEvent)) (*Output, *apiError) {
+	if progress == nil {
+		progress = func(JobEvent) {}
+	}
+
+	// The job ends at its deadline, if not before
+	ctx, cancel := opts.withDeadline(ctx)
+	defer cancel()
 
 	// If we got a CfgPath, call out and read into response.Cfg
 	var dbr io.Reader
 	if opts.Cfg == "" {
-		resp, err := http.Get(opts.CfgPath)
+		req, err := http.NewRequestWithContext(ctx, "GET", opts.CfgPath, nil)
+		if err != nil {
+			return nil, &apiError{http.StatusBadRequest, "Error: request for cfgPath failed → " + err.Error(), true}
+		}
+		resp, err := fetchClient().Do(req)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprint(w, "Error: request for cfgPath failed → "+err.Error()+"\n\n")
-			fmt.Fprintln(w, usage)
-			return
+			return nil, &apiError{fetchFailed(err, http.StatusInternalServerError), "Error: request for cfgPath failed → " + err.Error(), true}
 		}
+		defer resp.Body.Close()
+
 		// If we don't get a 200 OK
 		if resp.StatusCode != 200 {
-			w.WriteHeader(http.StatusBadRequest)
-			buf, _ := ioutil.ReadAll(resp.Body)
+			buf, _ := readLimited(resp.Body, *maxFetch)
 			contents := string(buf)
-			fmt.Fprint(w, "Error: request for cfgPath denied → "+contents+"\n\n")
-			log.Println("fail: cfg request → ", contents)
-			return
+			return nil, &apiError{http.StatusBadRequest, "Error: request for cfgPath denied → " + contents, false}
 		}
 
-		dbr = resp.Body
+		buf, err := readLimited(resp.Body, *maxFetch)
+		if err != nil {
+			return nil, &apiError{fetchFailed(err, http.StatusBadRequest), "Error: reading cfgPath failed → " + err.Error(), false}
+		}
+		dbr = bytes.NewReader(buf)
 
 	} else {
 		// Got full Cfg
@@ -230,130 +347,374 @@ JSON scheme:
 	}
 
 	// Load DB via cfg
-	db, err := cfg.Load(dbr)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, "cfg load failed → "+err.Error()+"\n\n")
-		fmt.Fprintln(w, usage)
-		return
+	name := opts.CfgPath
+	if name == "" {
+		name = opts.cfgName
 	}
-
-	// Expose response.API URL to a io.Reader
-	resp, err := http.Get(opts.API)
+	if !opts.trusted {
+		// The server would decrypt it with its own key, and hand back what it says
+		br := bufio.NewReader(dbr)
+		if encrypted, _ := ageEncrypted(br); encrypted {
+			return nil, &apiError{http.StatusBadRequest, "Error: cfg not allowed → encrypted dbs are decrypted with the server's key, so only its own dbs may be encrypted", true}
+		}
+		dbr = br
+	}
+	db, err := loadDb(dbr, name)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, "Error: request for API JSON failed → "+err.Error()+"\n\n")
-		fmt.Fprintln(w, usage)
-		return
+		return nil, &apiError{http.StatusBadRequest, "cfg load failed → " + err.Error(), true}
 	}
-	// If we don't get a 200 OK
-	if resp.StatusCode != 200 {
-		w.WriteHeader(http.StatusBadRequest)
-		buf, _ := ioutil.ReadAll(resp.Body)
-		contents := string(buf)
-		fmt.Fprint(w, "Error: request for API JSON denied → "+contents+"\n\n")
-		log.Println("fail: api request → ", contents)
-		return
+	if !opts.trusted {
+		err = checkCallerDb(db)
+		if err != nil {
+			return nil, &apiError{http.StatusBadRequest, "Error: cfg not allowed → " + err.Error(), true}
+		}
 	}
 
-	// Load openapi spec
-	api, err := openapi.Parse(resp.Body)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, "Error: parsing OpenAPI specification failed → "+err.Error()+"\n\n")
-		return
+	// Fetch and parse the spec, unless we have lately or it was uploaded
+	var parsed ParsedSpec
+	if opts.spec != nil {
+		p, err := parseSpec(opts.spec)
+		if err != nil {
+			return nil, &apiError{http.StatusBadRequest, "Error: parsing OpenAPI specification failed → " + err.Error(), false}
+		}
+		parsed = *p
+	} else {
+		var e *apiError
+		parsed, e = fetchSpec(ctx, opts.API)
+		if e != nil {
+			return nil, e
+		}
 	}
+	api := parsed.API
+	opts.Formats = parsed.Formats
 
 	// Override target
 	if opts.Target != "" {
 		api.Servers = []openapi.Server{{URL: opts.Target}}
 	}
 
-	// Remove relevant methods from API signatures
-	for _, method := range opts.IgnoreMethods {
-		for _, methods := range api.Paths {
-
-			down := strings.ToLower(method)
-			delete(methods, down)
-		}
-	}
+	// Remove paths and methods the caller didn't ask for
+	opts.narrow(&api)
 
 	// Insert auth to db
-	if !opts.NoAuth {
+	if !opts.NoAuth && opts.Auth != "" {
 		db.Records = append(db.Records, &cfg.Record{Tuples: []*cfg.Tuple{{Attributes: []*cfg.Attribute{{Name: "Authorization", Value: "Bearer " + opts.Auth}}}}})
 	}
 	db.BuildMap()
 
 	// Invoke generator
-	requests, missed, totalPossible, err := generate(api, db)
+	requests, missed, totalPossible, err := generator.Generate(ctx, api, db, opts.Options.Options)
+	if ctx.Err() != nil {
+		return nil, jobEnded(ctx)
+	}
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, "Error: generation failed → "+err.Error()+"\n\n")
-		fmt.Fprintln(w, usage)
-		return
+		return nil, &apiError{generationFailed(err), "Error: generation failed → " + err.Error(), true}
 	}
+	requests = opts.shuffle(opts.sample(requests))
 	if requests == nil {
 		requests = []*Request{}
 	}
+	progress(JobEvent{Type: "built", Total: len(requests)})
+
+	// Headers and credentials go on as a run's would
+	err = prepare(requests, db, opts.Options, api.Info.Title, Preparation{Headers: headerFlags, Security: parsed.Security, Authorization: opts.authorization(), APIKey: opts.apiKey, Cookie: opts.Cookie})
+	if err != nil {
+		return nil, &apiError{http.StatusBadRequest, "Error: " + err.Error(), true}
+	}
+
+	var buf bytes.Buffer
 
 	// Return built requests if we don't want to replay
-	if *&opts.NoReplay {
-		enc := json.NewEncoder(w)
-		err = enc.Encode([]interface{}{requests2strings(requests), missed, totalPossible})
+	if opts.NoReplay {
+		enc := newEncoder(&buf, opts.Indent)
+		err = enc.Encode([]interface{}{requests2strings(requests, opts.PrintReqs), missed, totalPossible})
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprint(w, "Error: response JSON encode failed → "+err.Error()+"\n\n")
-			fmt.Fprintln(w, usage)
-			return
+			return nil, &apiError{http.StatusInternalServerError, "Error: response JSON encode failed → " + err.Error(), true}
 		}
-		return
+		return &Output{ContentType: "application/json", Body: buf.Bytes()}, nil
 	}
 
-	// Optionally replay requests
-	results := make(map[*Request]*Response)
+	// The api document may name servers we aren't allowed to reach
+	checked := make(map[string]bool)
 	for _, request := range requests {
-		resp := replay(request.Request, nil)
-		results[request] = &resp
+		if checked[request.Host] {
+			continue
+		}
+		checked[request.Host] = true
+		if e := refuseHost(ctx, "target", opts.Proto+"://"+request.Host); e != nil {
+			return nil, e
+		}
 	}
 
-	sus, ok, err := validate(results)
+	// Optionally replay requests, as the job paces them
+	pacing, _ := opts.pacing()
+	results := make(map[*Request]*Response)
+	err = replayer(pacing).Replay(ctx, requests, func(request *Request, resp Response) {
+		results[request] = &resp
+
+		event := requestEvent("replayed", request)
+		event.HTTPCode, event.Done, event.Total = resp.StatusCode, len(results), len(requests)
+		progress(event)
+	})
+	if ctx.Err() != nil {
+		return nil, jobEnded(ctx)
+	}
+	var denied *Denied
+	if errors.As(err, &denied) {
+		return nil, &apiError{http.StatusForbidden, "Error: target is not allowed → " + denied.Error(), false}
+	}
+	var hook *generator.HookError
+	var sign *generator.SignError
+	if errors.As(err, &hook) || errors.As(err, &sign) {
+		return nil, &apiError{http.StatusInternalServerError, "Error: " + err.Error(), false}
+	}
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, "Error: could not parse expected code → "+err.Error()+"\n\n")
-		return
+		return nil, &apiError{http.StatusBadGateway, "Error: could not make request → " + err.Error(), false}
 	}
 
-	if opts.ADO {
-		w.Header().Add("Content-Type", "text/plain")
-		printADO(w, requests, missed, sus, ok)
-		return
+	sus, ok, err := generator.Validate(ctx, requests, results)
+	if ctx.Err() != nil {
+		return nil, jobEnded(ctx)
+	}
+	if err != nil {
+		return nil, &apiError{http.StatusInternalServerError, "Error: could not parse expected code → " + err.Error(), false}
+	}
+	for _, set := range sus {
+		event := requestEvent("verdict", set.Request)
+		event.HTTPCode, event.Verdict = set.Response.StatusCode, "suspicious"
+		progress(event)
+	}
+	for _, set := range ok {
+		event := requestEvent("verdict", set.Request)
+		event.HTTPCode, event.Verdict = set.Response.StatusCode, "conformant"
+		progress(event)
 	}
 
 	// Emit JSON by default for HTTP
-	w.Header().Add("Content-Type", "application/json")
-	err = printJSON(w, requests, missed, sus, ok)
+	found := &Findings{requests, totalPossible, missed, sus, ok, opts.Indent, opts.Full, opts.Strict}
+	out, err := found.render(opts.format())
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, "Error: could not marshal requests → "+err.Error()+"\n\n")
-		return
+		return nil, &apiError{http.StatusInternalServerError, "Error: could not marshal requests → " + err.Error(), false}
+	}
+
+	return out, nil
+}
+
+// The status for generation which failed, the caller's fault if it's their db or spec
+func generationFailed(err error) int {
+	var dbErr *generator.DbError
+	var missing *generator.MissingError
+	if errors.As(err, &dbErr) || errors.As(err, &missing) || errors.Is(err, generator.ErrNoServers) {
+		return http.StatusBadRequest
+	}
+
+	return http.StatusInternalServerError
+}
+
+// Most redirects followed fetching a cfgpath or api document
+const maxRedirects = 5
+
+// Client for fetching cfgpath and api documents, which must arrive within -fetchtimeout
+func fetchClient() *http.Client {
+	return &http.Client{
+		Transport: egress.roundTripper(),
+		Timeout:   *fetchTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			return nil
+		},
+	}
+}
+
+// The status for a document we couldn't fetch, a timeout being its server's fault
+func fetchFailed(err error, status int) int {
+	if e, ok := err.(net.Error); ok && e.Timeout() {
+		return http.StatusGatewayTimeout
+	}
+	return status
+}
+
+// Read all of a body, failing if it's larger than max bytes
+func readLimited(r io.Reader, max int64) ([]byte, error) {
+	buf, err := ioutil.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(buf)) > max {
+		return nil, fmt.Errorf("larger than %d bytes", max)
 	}
+
+	return buf, nil
 }
 
 // Listen for HTTP requests
 func listen(port, cert, key string) {
-	http.HandleFunc("/", rootHandler)
-	http.HandleFunc("/generator", genHandler)
-
-	var err error = nil
-	if cert != key {
-		// TLS
-		emit("Listening on https://localhost" + port + " …")
-		err = http.ListenAndServeTLS(port, cert, key, nil)
-	} else {
-		emit("Listening on http://localhost" + port + " …")
-		err = http.ListenAndServe(port, nil)
+	if *maxJobs < 1 || *queueSize < 0 {
+		fatal("err: -maxjobs must be at least 1 and -queue at least 0")
+	}
+	if *jobTTL <= 0 || *keepJobs < 0 {
+		fatal("err: -jobttl must be positive and -keepjobs not negative")
 	}
+	if *rateLimit < 0 || (*rateLimit > 0 && *rateBurst < 1) {
+		fatal("err: -ratelimit must not be negative and -rateburst must be at least 1")
+	}
+	startQueue(*maxJobs)
+	go sweepJobs()
+
+	// Callers can't have us reach hosts we shouldn't
+	var err error
+	egress, err = newEgress(*allowHosts, *denyHosts)
 	if err != nil {
+		fatal("err: bad -allowhosts or -denyhosts →", err)
+	}
+	if transport == nil {
+		transport = egress.roundTripper()
+	}
+	if *allowHosts == "" {
+		warn("warn: no -allowhosts, so callers may have the server connect to any host not in -denyhosts")
+		if authFile != nil {
+			warn("warn: no -allowhosts, so the -authfile token goes to no caller's target")
+		}
+		if *basic != "" || *apiKey != "" {
+			warn("warn: no -allowhosts, so -basic and -apikey go to no caller's target")
+		}
+	}
+
+	if *profilesName != "" {
+		profiles, err = loadProfiles(*profilesName)
+		if err != nil {
+			fatal("err: could not load profiles →", err)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", rootHandler)
+	mux.HandleFunc("/openapi.json", specHandler)
+	mux.HandleFunc("/docs", docsHandler)
+	mux.HandleFunc(apiV1+"/generator", genHandler)
+	mux.HandleFunc(apiV1+"/jobs", jobsHandler)
+	mux.HandleFunc(apiV1+"/jobs/", jobHandler)
+	mux.HandleFunc("/generator", genHandler)
+	mux.HandleFunc("/jobs", jobsHandler)
+	mux.HandleFunc("/jobs/", jobHandler)
+
+	// No one caller may hog the server, or use it to flood a target
+	var handler http.Handler = mux
+	var rpc GRPCServer
+	if *rateLimit > 0 {
+		rpc.Limit = &RateLimit{PerMinute: *rateLimit, Burst: *rateBurst}
+		handler = rpc.Limit.Wrap(handler)
+	}
+
+	// Callers must say who they are, if we know who may call
+	if *serverKeys != "" || *serverTenant != "" {
+		// Any token of the tenant would do otherwise, whatever it was issued for
+		if *serverTenant != "" && *audience == "" {
+			fatal("err: -servertenant requires -serveraudience")
+		}
+		sa := &ServerAuth{Tenant: *serverTenant, Audience: *audience}
+		rpc.Auth = sa
+		if *serverKeys != "" {
+			keys, err := loadServerKeys(*serverKeys)
+			if err != nil {
+				fatal("err: could not load server keys →", err)
+			}
+			sa.Keys = keys
+		}
+		handler = sa.Wrap(handler)
+	} else {
+		warn("warn: no -serverkeys or -servertenant, so anyone who can reach the server may use it")
+	}
+
+	// Browsers on other origins may call, if allowed
+	if *corsOrigins != "" {
+		handler = (&CORS{Origins: splitList(*corsOrigins), Methods: splitList(*corsMethods)}).Wrap(handler)
+	}
+
+	// Every call and job is audited
+	auditOut = os.Stderr
+	if *auditLog == "-" {
+		auditOut = os.Stdout
+	} else if *auditLog != "" {
+		f, err := os.OpenFile(*auditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			fatal("err: could not open audit log →", err)
+		}
+		auditOut = f
+	}
+	handler = audit(handler)
+
+	// A slow or stalled client can't hold a connection forever
+	// Writes are bounded loosely, as results and event streams take as long as replay does
+	srv := &http.Server{
+		Addr:              port,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       *readTimeout,
+		WriteTimeout:      *writeTimeout,
+		IdleTimeout:       2 * time.Minute,
+	}
+	failed := make(chan error, 2)
+	go func() {
+		if cert != key {
+			// TLS
+			emit("Listening on https://localhost" + port + " …")
+			failed <- srv.ListenAndServeTLS(cert, key)
+		} else {
+			emit("Listening on http://localhost" + port + " …")
+			failed <- srv.ListenAndServe()
+		}
+	}()
+
+	// Generation over gRPC, for services which speak it
+	var gs *grpc.Server
+	if *grpcPort != "" {
+		var err error
+		gs, err = newGRPCServer(&rpc, cert, key)
+		if err != nil {
+			fatal("err: could not set up gRPC →", err)
+		}
+		go serveGRPC(gs, *grpcPort, failed)
+	}
+
+	// Run until told to stop
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err := <-failed:
 		fatal("err: listen failed →", err)
+	case sig := <-stop:
+		emit("Shutting down on " + sig.String() + ", draining for up to " + drainTimeout.String() + " …")
+	}
+
+	shutdown(srv, gs, *drainTimeout)
+}
+
+// Stop taking calls and jobs, then wait for those under way to finish
+func shutdown(srv *http.Server, gs *grpc.Server, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	drainJobs()
+
+	// Calls under way, including those waiting on results, are answered
+	err := srv.Shutdown(ctx)
+	if err != nil {
+		warn("warn: calls were still under way at shutdown →", err)
+	}
+	if gs != nil {
+		stopGRPC(ctx, gs)
+	}
+
+	// Jobs nobody is waiting on finish too
+	if n := waitJobs(ctx); n > 0 {
+		warn(fmt.Sprintf("warn: %d jobs were still running at shutdown, their results are lost", n))
+	}
+
+	if f, ok := auditOut.(*os.File); ok && f != os.Stderr {
+		f.Sync()
+		f.Close()
 	}
 }
@@ -0,0 +1,89 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"errors"
+
+	"github.com/seh-msft/cfg"
+	"github.com/seh-msft/generator/pkg/generator"
+)
+
+// Identity is a named credential set from the db, such as:
+//
+//	identity=reader
+//		auth=eyJ…
+//		tenantId=b2a4…
+//
+// Credentials stand in for the flags of the same name
+// Other attributes take precedence over the db's own identifiers
+type Identity struct {
+	Name        string
+	Credentials map[string]string
+	Overrides   []*cfg.Record
+}
+
+// Attribute names which are credentials, by the flag they stand in for
+var identityCredentials = map[string]*string{
+	"auth":   auth,
+	"basic":  basic,
+	"ntlm":   ntlm,
+	"apikey": apiKey,
+	"cookie": cookie,
+}
+
+// Find a named identity in the db
+func findIdentity(db cfg.Cfg, name string) (*Identity, error) {
+	for _, record := range db.Records {
+		if !generator.IsIdentity(record) || record.Tuples[0].Attributes[0].Value != name {
+			continue
+		}
+
+		id := &Identity{Name: name, Credentials: make(map[string]string)}
+		for _, tuple := range record.Tuples {
+			for _, attr := range tuple.Attributes {
+				switch {
+				case attr.Name == "identity":
+				case identityCredentials[attr.Name] != nil:
+					id.Credentials[attr.Name] = attr.Value
+				default:
+					id.Overrides = append(id.Overrides, &cfg.Record{Tuples: []*cfg.Tuple{{Attributes: []*cfg.Attribute{{Name: attr.Name, Value: attr.Value}}}}})
+				}
+			}
+		}
+
+		return id, nil
+	}
+
+	return nil, errors.New("no identity in the db named " + name)
+}
+
+// Fill credential flags from the identity, flags given explicitly win
+func (id *Identity) apply() {
+	for name, value := range id.Credentials {
+		if flag := identityCredentials[name]; *flag == "" {
+			*flag = value
+		}
+		if !*noRedact {
+			redactSecret(value)
+		}
+	}
+}
+
+// Remove identities from the db so they aren't mistaken for identifiers
+// The chosen identity's overrides are appended, so they win over earlier records
+func withIdentity(db cfg.Cfg, id *Identity) cfg.Cfg {
+	var records []*cfg.Record
+	for _, record := range db.Records {
+		if !generator.IsIdentity(record) {
+			records = append(records, record)
+		}
+	}
+	if id != nil {
+		records = append(records, id.Overrides...)
+	}
+	db.Records = records
+
+	return db
+}
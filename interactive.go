@@ -0,0 +1,167 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/seh-msft/cfg"
+	"github.com/seh-msft/openapi"
+)
+
+// Prompter asks on the terminal for values the db has nothing for, as per -interactive
+type Prompter struct {
+	Examples map[string]string // Parameter examples from the spec, by "method path name"
+
+	answers map[string]string // Answers by parameter name, empty if skipped
+	in      *bufio.Reader
+	out     io.Writer
+}
+
+// Ask on the terminal when the db has no values, if -interactive
+var prompter *Prompter
+
+// New prompter reading the terminal, with examples from the spec
+func newPrompter(spec []byte) *Prompter {
+	return &Prompter{
+		Examples: specExamples(spec),
+		answers:  make(map[string]string),
+		in:       bufio.NewReader(os.Stdin),
+		out:      os.Stderr,
+	}
+}
+
+// Ask for a parameter's value, each parameter is asked for once
+// An empty answer skips the parameter
+func (p *Prompter) Ask(method, path string, parameter openapi.Parameter) (string, bool) {
+	if answer, asked := p.answers[parameter.Name]; asked {
+		return answer, answer != ""
+	}
+
+	stderr.Flush()
+	fmt.Fprintf(p.out, "\n%s %s needs %s parameter %s", strings.ToUpper(method), path, strings.ToLower(parameter.In), parameter.Name)
+	if parameter.Description != "" {
+		fmt.Fprintf(p.out, ": %s", parameter.Description)
+	}
+	fmt.Fprintln(p.out)
+	if parameter.Type != "" {
+		fmt.Fprintf(p.out, "  type: %s\n", parameter.Type)
+	}
+	if len(parameter.Enums) > 0 {
+		fmt.Fprintf(p.out, "  one of: %s\n", strings.Join(parameter.Enums, ", "))
+	}
+	if example, ok := p.Examples[strings.ToLower(method)+" "+path+" "+parameter.Name]; ok {
+		fmt.Fprintf(p.out, "  example: %s\n", example)
+	}
+	fmt.Fprintf(p.out, "%s (empty to skip) = ", parameter.Name)
+
+	line, _ := p.in.ReadString('\n')
+	answer := strings.TrimSpace(line)
+	p.answers[parameter.Name] = answer
+
+	return answer, answer != ""
+}
+
+// Offer to append the answers to the db
+func (p *Prompter) Offer(ctx context.Context, name string) error {
+	var names []string
+	for id, answer := range p.answers {
+		if answer != "" {
+			names = append(names, id)
+		}
+	}
+	if len(names) < 1 {
+		return nil
+	}
+	sort.Strings(names)
+
+	// Only files can be appended to
+	if _, _, ok := sqliteDb(name); ok || strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://") {
+		return nil
+	}
+
+	fmt.Fprintf(p.out, "\nAppend answers for %s to %s? [y/N] ", strings.Join(names, ", "), name)
+	line, _ := p.in.ReadString('\n')
+	if reply := strings.ToLower(strings.TrimSpace(line)); reply != "y" && reply != "yes" {
+		return nil
+	}
+
+	answers := make(map[string]string)
+	for _, id := range names {
+		answers[id] = p.answers[id]
+	}
+
+	// JSON, YAML, and encrypted dbs are written whole, cfg is appended to so comments are kept
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json", ".yaml", ".yml", ".age":
+		return writeDb(ctx, name, name, answers)
+	}
+
+	f, err := os.OpenFile(name, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fmt.Fprintln(f)
+	for _, id := range names {
+		attr := cfg.Attribute{Name: id, Value: answers[id]}
+		_, err = fmt.Fprintln(f, attr.String())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Parameter examples from a spec, by "method path name"
+// The openapi package doesn't keep them
+func specExamples(spec []byte) map[string]string {
+	examples := make(map[string]string)
+
+	var doc struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if json.Unmarshal(spec, &doc) != nil {
+		return examples
+	}
+
+	for path, methods := range doc.Paths {
+		for method, raw := range methods {
+			var op struct {
+				Parameters []struct {
+					Name    string      `json:"name"`
+					Example interface{} `json:"example"`
+					Schema  struct {
+						Example interface{} `json:"example"`
+						Default interface{} `json:"default"`
+					} `json:"schema"`
+				} `json:"parameters"`
+			}
+			if json.Unmarshal(raw, &op) != nil {
+				continue
+			}
+
+			for _, param := range op.Parameters {
+				for _, example := range []interface{}{param.Example, param.Schema.Example, param.Schema.Default} {
+					if example != nil {
+						examples[strings.ToLower(method)+" "+path+" "+param.Name] = jsonString(example)
+						break
+					}
+				}
+			}
+		}
+	}
+
+	return examples
+}
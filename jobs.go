@@ -0,0 +1,537 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Job states
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobDone      = "done"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+)
+
+// Job is a generation request run in the background, as per POST /jobs
+type Job struct {
+	ID       string     `json:"id"`
+	Status   string     `json:"status"`
+	Created  time.Time  `json:"created"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Error    string     `json:"error,omitempty"`
+
+	opts    JobOptions
+	owner   string // Caller who submitted it, only they may see it
+	ctx     context.Context
+	cancel  context.CancelFunc // Stops the job, as per DELETE /jobs/{id}
+	output  *Output
+	code    int           // HTTP status of a failure
+	events  []JobEvent    // Progress so far
+	changed chan struct{} // Closed, and replaced, on each event
+}
+
+// JobEvent is progress of a job, as sent by GET /jobs/{id}/events
+type JobEvent struct {
+	Type      string `json:"type"` // "built", "replayed", "verdict", "done", "failed", or "cancelled"
+	Method    string `json:"method,omitempty"`
+	Path      string `json:"path,omitempty"`
+	Variant   string `json:"variant,omitempty"`
+	RequestID string `json:"requestId,omitempty"` // Correlation id sent as X-Request-ID
+	HTTPCode  int    `json:"code,omitempty"`
+	Verdict   string `json:"verdict,omitempty"` // "suspicious" or "conformant"
+	Done      int    `json:"done,omitempty"`    // Requests replayed so far
+	Total     int    `json:"total,omitempty"`   // Requests built
+	Error     string `json:"error,omitempty"`
+}
+
+// An event about a request
+func requestEvent(kind string, request *Request) JobEvent {
+	return JobEvent{
+		Type:      kind,
+		Method:    strings.ToUpper(request.Request.Method),
+		Path:      request.URL.Path,
+		Variant:   request.Variant,
+		RequestID: requestID(request),
+	}
+}
+
+// Jobs by ID
+var (
+	jobs     = make(map[string]*Job)
+	jobsMu   sync.Mutex
+	running  sync.WaitGroup // Jobs, and generation requests, not yet finished
+	draining bool           // No new jobs, we're shutting down
+	slots    chan struct{}  // One per job which may run at once
+	waiting  int            // Jobs waiting for a slot
+)
+
+// Reasons a job is refused
+var (
+	errDraining  = errors.New("the server is shutting down")
+	errQueueFull = errors.New("too many jobs are waiting to run")
+)
+
+// How a cancelled job answers for its result
+var errCancelled = &apiError{http.StatusGone, "Error: the job was cancelled", false}
+
+// Why a job's context ended, its deadline passing or it being cancelled
+func jobEnded(ctx context.Context) *apiError {
+	if ctx.Err() == context.DeadlineExceeded {
+		return &apiError{http.StatusGatewayTimeout, "Error: the job passed its deadline", false}
+	}
+
+	return errCancelled
+}
+
+// Run up to max jobs at once
+func startQueue(max int) {
+	slots = make(chan struct{}, max)
+}
+
+// Take a place in the queue, unless it's full or we're shutting down
+func enqueue() error {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+
+	if draining {
+		return errDraining
+	}
+	// Free slots are as good as places in the queue
+	if waiting >= *queueSize+cap(slots)-len(slots) {
+		return errQueueFull
+	}
+	waiting++
+	running.Add(1)
+
+	return nil
+}
+
+// Wait for a slot to run in, once queued, unless the context ends first
+// Either way, the caller is still running until it says it's done
+func acquire(ctx context.Context) bool {
+	ok := true
+	select {
+	case slots <- struct{}{}:
+	case <-ctx.Done():
+		ok = false
+	}
+
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	waiting--
+
+	return ok
+}
+
+// Give up a slot, once finished
+func release() {
+	<-slots
+	running.Done()
+}
+
+// Queue a job for a caller, returning it as submitted
+func submitJob(opts JobOptions, owner string) (Job, error) {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return Job{}, errors.New("could not use rand → " + err.Error())
+	}
+
+	job := &Job{ID: hex.EncodeToString(buf), Status: jobQueued, Created: time.Now().UTC(), opts: opts, owner: owner, changed: make(chan struct{})}
+	err = enqueue()
+	if err != nil {
+		return Job{}, err
+	}
+	job.ctx, job.cancel = context.WithCancel(context.Background())
+
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	jobs[job.ID] = job
+
+	go job.run()
+	return *job, nil
+}
+
+// Run a job to completion, or until it's cancelled, then tell its callback, if any
+func (job *Job) run() {
+	defer job.cancel()
+
+	var out *Output
+	var e *apiError
+	ran := acquire(job.ctx)
+	if ran {
+		jobsMu.Lock()
+		job.Status = jobRunning
+		started := time.Now().UTC()
+		job.Started = &started
+		jobsMu.Unlock()
+
+		out, e = runJob(job.ctx, job.opts, job.publish)
+	}
+
+	jobsMu.Lock()
+	finished := time.Now().UTC()
+	job.Finished = &finished
+	switch {
+	case job.ctx.Err() != nil:
+		job.Status = jobCancelled
+		job.Error = errCancelled.Message
+		job.code = errCancelled.Code
+	case e != nil:
+		job.Status = jobFailed
+		job.Error = e.Message
+		job.code = e.Code
+	default:
+		job.Status = jobDone
+		job.output = out
+	}
+	auditJob(job)
+	evictJobs(time.Now())
+	jobsMu.Unlock()
+
+	job.publish(JobEvent{Type: job.Status, Error: job.Error})
+
+	// Callbacks don't hold up other jobs, but are waited for on shutdown
+	if ran {
+		<-slots
+	}
+	if job.opts.Callback != "" {
+		job.callback()
+	}
+	running.Done()
+}
+
+// Cancel a caller's job, if it hasn't finished
+func cancelJob(id, owner string) (Job, *apiError) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+
+	job, ok := jobs[id]
+	if !ok || job.owner != owner {
+		return Job{}, &apiError{http.StatusNotFound, "Error: no such job → " + id, false}
+	}
+	if job.Status != jobQueued && job.Status != jobRunning {
+		return *job, &apiError{http.StatusConflict, "Error: the job has already finished → " + job.Status, false}
+	}
+
+	job.cancel()
+	return *job, nil
+}
+
+// Record an event and wake those following the job
+func (job *Job) publish(event JobEvent) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+
+	job.events = append(job.events, event)
+	close(job.changed)
+	job.changed = make(chan struct{})
+}
+
+// Events from the nth on, and a channel closed when there are more
+func (job *Job) follow(n int) ([]JobEvent, <-chan struct{}) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+
+	if n > len(job.events) {
+		n = len(job.events)
+	}
+	return job.events[n:], job.changed
+}
+
+// A copy of a job, safe to read, and the job itself
+func findJob(id string) (Job, *Job, bool) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+
+	job, ok := jobs[id]
+	if !ok {
+		return Job{}, nil, false
+	}
+	return *job, job, true
+}
+
+// Forget finished jobs older than -jobttl, then the oldest beyond -keepjobs, with their results
+// Jobs forgotten are no longer found, as if they'd never been
+// Call with jobsMu held
+func evictJobs(now time.Time) {
+	var finished []*Job
+	for id, job := range jobs {
+		if job.Finished == nil {
+			continue
+		}
+		if now.Sub(*job.Finished) > *jobTTL {
+			delete(jobs, id)
+			continue
+		}
+		finished = append(finished, job)
+	}
+
+	if len(finished) <= *keepJobs {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool { return finished[i].Finished.Before(*finished[j].Finished) })
+	for _, job := range finished[:len(finished)-*keepJobs] {
+		delete(jobs, job.ID)
+	}
+}
+
+// Forget jobs as they pass -jobttl, though no others finish
+func sweepJobs() {
+	every := *jobTTL / 10
+	if every < time.Second {
+		every = time.Second
+	}
+
+	for now := range time.Tick(every) {
+		jobsMu.Lock()
+		evictJobs(now)
+		jobsMu.Unlock()
+	}
+}
+
+// Refuse new jobs and generation requests
+func drainJobs() {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	draining = true
+}
+
+// Refuse a call as we're shutting down or busy
+func writeRefusal(w http.ResponseWriter, r *http.Request, err error) {
+	code := http.StatusTooManyRequests
+	w.Header().Set("Retry-After", "10")
+	switch err {
+	case errQueueFull:
+	case errDraining:
+		code = http.StatusServiceUnavailable
+		w.Header().Set("Retry-After", "30")
+	default:
+		code = http.StatusInternalServerError
+		w.Header().Del("Retry-After")
+	}
+	writeError(w, r, &apiError{code, "Error: " + err.Error(), false})
+}
+
+// Wait for running jobs to finish, returning how many are left if the context ends first
+func waitJobs(ctx context.Context) int {
+	done := make(chan struct{})
+	go func() {
+		running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return 0
+	case <-ctx.Done():
+	}
+
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	n := 0
+	for _, job := range jobs {
+		if job.Status == jobQueued || job.Status == jobRunning {
+			n++
+		}
+	}
+	return n
+}
+
+// Handle '/jobs' requests, to submit a job
+func jobsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		writeError(w, r, &apiError{http.StatusMethodNotAllowed, "Error: submit jobs with POST, with the body as per POST /generator", false})
+		return
+	}
+
+	opts, e := decodeOptions(w, r)
+	if e != nil {
+		writeError(w, r, e)
+		return
+	}
+	auditOptions(r, opts)
+
+	job, err := submitJob(opts, caller(r))
+	if err != nil {
+		writeRefusal(w, r, err)
+		return
+	}
+	auditFor(r).Job = job.ID
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", versioned(r, "/jobs/"+job.ID))
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// Handle '/jobs/{id}', '/jobs/{id}/result', '/jobs/{id}/summary', '/jobs/{id}/results', and '/jobs/{id}/events' requests
+// DELETE '/jobs/{id}' cancels a job
+func jobHandler(w http.ResponseWriter, r *http.Request) {
+	id, view := strings.TrimPrefix(route(r), "/jobs/"), ""
+	if i := strings.Index(id, "/"); i >= 0 {
+		id, view = id[:i], id[i+1:]
+	}
+
+	if r.Method != "GET" && (r.Method != "DELETE" || view != "") {
+		w.Header().Set("Allow", "GET, DELETE")
+		writeError(w, r, &apiError{http.StatusMethodNotAllowed, "Error: jobs are read with GET and cancelled with DELETE", false})
+		return
+	}
+
+	auditFor(r).Job = id
+	if r.Method == "DELETE" {
+		job, e := cancelJob(id, caller(r))
+		if e != nil {
+			writeError(w, r, e)
+			return
+		}
+
+		// It stops shortly, follow it to see when
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(job)
+		return
+	}
+
+	job, live, ok := findJob(id)
+	if !ok || job.owner != caller(r) || (view != "" && view != "result" && view != "summary" && view != "results" && view != "events") {
+		writeError(w, r, &apiError{http.StatusNotFound, "Error: no such job → " + id, false})
+		return
+	}
+
+	switch view {
+	case "":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(job)
+		return
+
+	case "events":
+		streamEvents(w, r, live)
+		return
+	}
+
+	switch {
+	case job.Status == jobDone && view == "result":
+		format, e := resultFormat(r, job.opts)
+		if e != nil {
+			writeError(w, r, e)
+			return
+		}
+		out, err := job.output.as(format)
+		if err != nil {
+			writeError(w, r, &apiError{http.StatusInternalServerError, "Error: could not marshal requests → " + err.Error(), false})
+			return
+		}
+		w.Header().Set("Vary", "Accept")
+		w.Header().Set("Content-Type", out.ContentType)
+		if download, _ := strconv.ParseBool(r.URL.Query().Get("download")); download {
+			w.Header().Set("Content-Disposition", `attachment; filename="generator-`+job.ID+"."+formatExtensions[out.format]+`"`)
+		}
+		w.Write(out.Body)
+
+	case job.Status == jobDone:
+		writePage(w, r, job, view)
+
+	case job.Status == jobFailed || job.Status == jobCancelled:
+		writeError(w, r, &apiError{job.code, job.Error, false})
+
+	default:
+		// Not yet, check back
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", "5")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(job)
+	}
+}
+
+// Write a finished job's summary, or a page of its results
+func writePage(w http.ResponseWriter, r *http.Request, job Job, view string) {
+	found := job.output.report
+	if found == nil {
+		writeError(w, r, &apiError{http.StatusNotFound, "Error: the job has no results to page, it didn't replay, GET " + versioned(r, "/jobs/"+job.ID+"/result") + " instead", false})
+		return
+	}
+	base := versioned(r, "/jobs/"+job.ID)
+
+	var v interface{} = found.summary(base)
+	if view == "results" {
+		offset, limit, e := pageBounds(r)
+		if e != nil {
+			writeError(w, r, e)
+			return
+		}
+		page, err := found.page(base, offset, limit)
+		if err != nil {
+			writeError(w, r, &apiError{http.StatusInternalServerError, "Error: could not marshal results → " + err.Error(), false})
+			return
+		}
+		if page.Next != "" {
+			w.Header().Set("Link", "<"+page.Next+`>; rel="next"`)
+		}
+		v = page
+	}
+
+	// Links are kept readable
+	enc := newEncoder(w, job.opts.Indent)
+	enc.SetEscapeHTML(false)
+	w.Header().Set("Content-Type", "application/json")
+	enc.Encode(v)
+}
+
+// Stream a job's events as Server-Sent Events until it's finished
+// Events are numbered, so a client reconnecting with Last-Event-ID resumes after the last it saw
+func streamEvents(w http.ResponseWriter, r *http.Request, job *Job) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, r, &apiError{http.StatusInternalServerError, "Error: streaming is unsupported", false})
+		return
+	}
+
+	n := 0
+	if last, err := strconv.Atoi(r.Header.Get("Last-Event-ID")); err == nil {
+		n = last + 1
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		events, changed := job.follow(n)
+		for _, event := range events {
+			buf, _ := json.Marshal(event)
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", n, event.Type, buf)
+			n++
+
+			if event.Type == jobDone || event.Type == jobFailed || event.Type == jobCancelled {
+				flusher.Flush()
+				return
+			}
+		}
+		flusher.Flush()
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,493 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/seh-msft/cfg"
+	"github.com/seh-msft/generator/pkg/generator"
+	"gopkg.in/yaml.v3"
+)
+
+// Load a db as cfg, JSON, or YAML, which may be age-encrypted
+// JSON is told apart by its leading '{', YAML by a name ending in .yaml or .yml
+func loadDb(r io.Reader, name string) (cfg.Cfg, error) {
+	// The name may be a URL
+	name = strings.SplitN(name, "?", 2)[0]
+
+	r, name, err := decryptDb(r, name)
+	if err != nil {
+		return cfg.Cfg{}, err
+	}
+
+	switch strings.ToLower(path.Ext(name)) {
+	case ".yaml", ".yml":
+		return loadYAMLDb(r)
+	}
+
+	br := bufio.NewReader(r)
+	for {
+		c, _, err := br.ReadRune()
+		if err == io.EOF {
+			return cfg.Load(br)
+		}
+		if err != nil {
+			return cfg.Cfg{}, err
+		}
+		if unicode.IsSpace(c) {
+			continue
+		}
+
+		br.UnreadRune()
+		if c == '{' {
+			return loadJSONDb(br)
+		}
+		return cfg.Load(br)
+	}
+}
+
+// JSONEntry is an identifier in a JSON db, the equivalent of a cfg record
+type JSONEntry struct {
+	Value      interface{}   `json:"value,omitempty"`
+	Permit     JSONRules     `json:"permit,omitempty"`
+	Disallow   JSONRules     `json:"disallow,omitempty"`
+	Override   JSONRules     `json:"override,omitempty"`
+	Extract    JSONRules     `json:"extract,omitempty"`
+	Properties []string      `json:"properties,omitempty"`
+	Values     []interface{} `json:"values,omitempty"`
+	Alias      JSONStrings   `json:"alias,omitempty"`
+}
+
+// JSONRule is a permit, disallow, override, or extract rule, the equivalent of a cfg tuple
+type JSONRule struct {
+	Regex     bool        `json:"regex,omitempty"`
+	Title     JSONStrings `json:"title,omitempty"`
+	Path      JSONStrings `json:"path,omitempty"`
+	Operation JSONStrings `json:"operation,omitempty"`
+	Value     interface{} `json:"value,omitempty"`  // Overrides only
+	Field     string      `json:"field,omitempty"`  // Extracts only
+	Header    string      `json:"header,omitempty"` // Extracts only
+}
+
+// JSONRules is one rule or a list of rules
+type JSONRules []JSONRule
+
+func (r *JSONRules) UnmarshalJSON(buf []byte) error {
+	if strings.HasPrefix(strings.TrimSpace(string(buf)), "[") {
+		return json.Unmarshal(buf, (*[]JSONRule)(r))
+	}
+
+	var rule JSONRule
+	err := json.Unmarshal(buf, &rule)
+	*r = JSONRules{rule}
+	return err
+}
+
+// JSONStrings is one string or a list of strings
+type JSONStrings []string
+
+func (s *JSONStrings) UnmarshalJSON(buf []byte) error {
+	if strings.HasPrefix(strings.TrimSpace(string(buf)), "[") {
+		return json.Unmarshal(buf, (*[]string)(s))
+	}
+
+	var one string
+	err := json.Unmarshal(buf, &one)
+	*s = JSONStrings{one}
+	return err
+}
+
+func (s JSONStrings) MarshalJSON() ([]byte, error) {
+	if len(s) == 1 {
+		return json.Marshal(s[0])
+	}
+
+	return json.Marshal([]string(s))
+}
+
+// Load a JSON db, such as:
+//
+//	{
+//		"userId": "abc-123",
+//		"tenant": [{"value": "456-768", "disallow": {"regex": true, "path": ".*/accessible"}}, "678-231"],
+//		"number": {"values": [2, 4, 6, 8]},
+//		"plan": {"values": [{"value": "free", "weight": 5}, {"value": "pro", "weight": 1}], "properties": ["fuzz"]},
+//		"userId": {"value": "abc-123", "alias": ["user_id", "uid"]},
+//		"identities": {"reader": {"auth": "eyJ…", "tenantId": "b2a4"}},
+//		"defaults": [{"format": "date-time", "value": "2024-01-01T00:00:00Z"}, {"type": "integer", "value": 1}],
+//		"headers": {"X-Env": "staging"}
+//	}
+//
+// An identifier is a value, an entry object, or a list of either, each of which is a record
+func loadJSONDb(r io.Reader) (cfg.Cfg, error) {
+	var c cfg.Cfg
+
+	var doc map[string]json.RawMessage
+	err := json.NewDecoder(r).Decode(&doc)
+	if err != nil {
+		return c, err
+	}
+
+	// Keep the file's names in a stable order
+	var names []string
+	for name := range doc {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		raw := doc[name]
+
+		if name == "identities" {
+			records, err := jsonIdentities(raw)
+			if err != nil {
+				return c, errors.New("identities: " + err.Error())
+			}
+			c.Records = append(c.Records, records...)
+			continue
+		}
+
+		if name == "headers" {
+			record, err := jsonHeaders(raw)
+			if err != nil {
+				return c, errors.New("headers: " + err.Error())
+			}
+			c.Records = append(c.Records, record)
+			continue
+		}
+
+		if name == "defaults" {
+			record, err := jsonDefaults(raw)
+			if err != nil {
+				return c, errors.New("defaults: " + err.Error())
+			}
+			c.Records = append(c.Records, record)
+			continue
+		}
+
+		var list []json.RawMessage
+		if json.Unmarshal(raw, &list) != nil {
+			list = []json.RawMessage{raw}
+		}
+
+		for _, item := range list {
+			record, err := jsonRecord(name, item)
+			if err != nil {
+				return c, errors.New(name + ": " + err.Error())
+			}
+			c.Records = append(c.Records, record)
+		}
+	}
+
+	return c, nil
+}
+
+// Build a record from a value or entry object
+func jsonRecord(name string, raw json.RawMessage) (*cfg.Record, error) {
+	var entry JSONEntry
+	if strings.HasPrefix(strings.TrimSpace(string(raw)), "{") {
+		err := json.Unmarshal(raw, &entry)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		err := json.Unmarshal(raw, &entry.Value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	record := &cfg.Record{Tuples: []*cfg.Tuple{{Attributes: []*cfg.Attribute{{Name: name, Value: jsonString(entry.Value)}}}}}
+
+	rules := func(keyword string, rules JSONRules) {
+		for _, rule := range rules {
+			attrs := []*cfg.Attribute{{Name: keyword}}
+			if rule.Regex {
+				attrs = append(attrs, &cfg.Attribute{Name: "regex"})
+			}
+			for _, title := range rule.Title {
+				attrs = append(attrs, &cfg.Attribute{Name: "title", Value: title})
+			}
+			for _, path := range rule.Path {
+				attrs = append(attrs, &cfg.Attribute{Name: "path", Value: path})
+			}
+			for _, operation := range rule.Operation {
+				attrs = append(attrs, &cfg.Attribute{Name: "operation", Value: operation})
+			}
+			if keyword == "override" {
+				attrs = append(attrs, &cfg.Attribute{Name: "value", Value: jsonString(rule.Value)})
+			}
+			if rule.Field != "" {
+				attrs = append(attrs, &cfg.Attribute{Name: "field", Value: rule.Field})
+			}
+			if rule.Header != "" {
+				attrs = append(attrs, &cfg.Attribute{Name: "header", Value: rule.Header})
+			}
+			record.Tuples = append(record.Tuples, &cfg.Tuple{Attributes: attrs})
+		}
+	}
+	rules("disallow", entry.Disallow)
+	rules("permit", entry.Permit)
+	rules("override", entry.Override)
+	rules("extract", entry.Extract)
+
+	if len(entry.Alias) > 0 {
+		record.Tuples = append(record.Tuples, &cfg.Tuple{Attributes: []*cfg.Attribute{{Name: "alias", Value: strings.Join(entry.Alias, ",")}}})
+	}
+
+	if len(entry.Properties) > 0 {
+		attrs := []*cfg.Attribute{{Name: "properties"}}
+		for _, property := range entry.Properties {
+			// Such as "length=8-16"
+			kv := strings.SplitN(property, "=", 2)
+			attr := &cfg.Attribute{Name: kv[0]}
+			if len(kv) > 1 {
+				attr.Value = kv[1]
+			}
+			attrs = append(attrs, attr)
+		}
+		record.Tuples = append(record.Tuples, &cfg.Tuple{Attributes: attrs})
+	}
+
+	// Enumerated values are names in cfg
+	if len(entry.Values) > 0 {
+		attrs := []*cfg.Attribute{{Name: "values"}}
+		for _, value := range entry.Values {
+			attrs = append(attrs, jsonValue(value))
+		}
+		record.Tuples = append(record.Tuples, &cfg.Tuple{Attributes: attrs})
+	}
+
+	return record, nil
+}
+
+// JSONValue is an enumerated value with a weight
+type JSONValue struct {
+	Value  interface{} `json:"value"`
+	Weight int         `json:"weight"`
+}
+
+// An enumerated value as a cfg attribute, weights are attribute values
+func jsonValue(v interface{}) *cfg.Attribute {
+	object, ok := v.(map[string]interface{})
+	if !ok {
+		return &cfg.Attribute{Name: jsonString(v)}
+	}
+
+	attr := &cfg.Attribute{Name: jsonString(object["value"])}
+	if weight, ok := object["weight"]; ok {
+		attr.Value = jsonString(weight)
+	}
+	return attr
+}
+
+// Build identity records from a map of identity names to attributes
+func jsonIdentities(raw json.RawMessage) ([]*cfg.Record, error) {
+	var identities map[string]map[string]interface{}
+	err := json.Unmarshal(raw, &identities)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for name := range identities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var records []*cfg.Record
+	for _, name := range names {
+		record := &cfg.Record{Tuples: []*cfg.Tuple{{Attributes: []*cfg.Attribute{{Name: "identity", Value: name}}}}}
+
+		var attrs []string
+		for attr := range identities[name] {
+			attrs = append(attrs, attr)
+		}
+		sort.Strings(attrs)
+
+		for _, attr := range attrs {
+			record.Tuples = append(record.Tuples, &cfg.Tuple{Attributes: []*cfg.Attribute{{Name: attr, Value: jsonString(identities[name][attr])}}})
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// The JSON db layout of a db, the reverse of loadJSONDb
+func jsonDb(c cfg.Cfg) map[string]interface{} {
+	doc := make(map[string]interface{})
+	entries := make(map[string][]interface{})
+	identities := make(map[string]map[string]string)
+	var defaults []map[string]string
+	headers := make(map[string]string)
+
+	for _, record := range c.Records {
+		if generator.IsIdentity(record) {
+			attrs := make(map[string]string)
+			for _, tuple := range record.Tuples[1:] {
+				for _, attr := range tuple.Attributes {
+					attrs[attr.Name] = attr.Value
+				}
+			}
+			identities[record.Tuples[0].Attributes[0].Value] = attrs
+			continue
+		}
+		if generator.IsDefaults(record) {
+			for _, tuple := range record.Tuples[1:] {
+				d := make(map[string]string)
+				for _, attr := range tuple.Attributes {
+					d[attr.Name] = attr.Value
+				}
+				defaults = append(defaults, d)
+			}
+			continue
+		}
+		if isHeaders(record) {
+			for _, h := range dbHeaders(cfg.Cfg{Records: []*cfg.Record{record}}) {
+				headers[h[0]] = h[1]
+			}
+			continue
+		}
+
+		name := record.PrimaryKey()
+		entries[name] = append(entries[name], jsonEntry(record))
+	}
+
+	for name, list := range entries {
+		if len(list) == 1 {
+			doc[name] = list[0]
+		} else {
+			doc[name] = list
+		}
+	}
+	if len(identities) > 0 {
+		doc["identities"] = identities
+	}
+	if len(defaults) > 0 {
+		doc["defaults"] = defaults
+	}
+	if len(headers) > 0 {
+		doc["headers"] = headers
+	}
+
+	return doc
+}
+
+// The entry object for a record, or its value alone if it has no rules
+func jsonEntry(record *cfg.Record) interface{} {
+	var entry JSONEntry
+	if value := record.Tuples[0].Attributes[0].Value; value != "" {
+		entry.Value = value
+	}
+	entry.Alias = generator.Aliases(record)
+	if len(record.Tuples) < 2 && len(entry.Alias) < 1 {
+		return entry.Value
+	}
+
+	for _, tuple := range record.Tuples[1:] {
+		if len(tuple.Attributes) < 1 {
+			continue
+		}
+
+		keyword := tuple.Attributes[0].Name
+		switch keyword {
+		case "properties":
+			for _, attr := range tuple.Attributes[1:] {
+				if attr.Value != "" {
+					entry.Properties = append(entry.Properties, attr.Name+"="+attr.Value)
+					continue
+				}
+				entry.Properties = append(entry.Properties, attr.Name)
+			}
+			continue
+		case "alias":
+			continue
+		case "values":
+			for _, attr := range tuple.Attributes[1:] {
+				if attr.Value != "" {
+					weight, _ := strconv.Atoi(attr.Value)
+					entry.Values = append(entry.Values, JSONValue{attr.Name, weight})
+					continue
+				}
+				entry.Values = append(entry.Values, attr.Name)
+			}
+			continue
+		}
+
+		var rule JSONRule
+		for _, attr := range tuple.Attributes[1:] {
+			switch attr.Name {
+			case "regex":
+				rule.Regex = true
+			case "title":
+				rule.Title = append(rule.Title, attr.Value)
+			case "path":
+				rule.Path = append(rule.Path, attr.Value)
+			case "operation":
+				rule.Operation = append(rule.Operation, attr.Value)
+			case "value":
+				rule.Value = attr.Value
+			case "field":
+				rule.Field = attr.Value
+			case "header":
+				rule.Header = attr.Value
+			}
+		}
+
+		switch keyword {
+		case "disallow":
+			entry.Disallow = append(entry.Disallow, rule)
+		case "permit":
+			entry.Permit = append(entry.Permit, rule)
+		case "override":
+			entry.Override = append(entry.Override, rule)
+		case "extract":
+			entry.Extract = append(entry.Extract, rule)
+		}
+	}
+
+	return entry
+}
+
+// Load a YAML db, with the same layout as a JSON db
+func loadYAMLDb(r io.Reader) (cfg.Cfg, error) {
+	var doc map[string]interface{}
+	err := yaml.NewDecoder(r).Decode(&doc)
+	if err != nil {
+		return cfg.Cfg{}, err
+	}
+
+	buf, err := json.Marshal(doc)
+	if err != nil {
+		return cfg.Cfg{}, err
+	}
+
+	return loadJSONDb(bytes.NewReader(buf))
+}
+
+// JSON scalars as db strings, null is an omitted value
+func jsonString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		buf, _ := json.Marshal(v)
+		return string(buf)
+	}
+}
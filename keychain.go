@@ -0,0 +1,78 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Refresh tokens are kept in the OS keychain where there is one
+// The macOS keychain is reached with security, and the Secret Service on Linux with secret-tool
+// Elsewhere, or when neither works, the token cache file is the fallback
+
+// Keychain items are all under this service
+const keychainService = "generator"
+
+// No keychain on this platform
+var errNoKeychain = errors.New("no keychain on " + runtime.GOOS)
+
+// The keychain account for a cache key, as keys are long and hold spaces
+func keychainAccount(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Read a token from the keychain
+func keychainLoad(key string) (string, error) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", keychainService, "-a", keychainAccount(key), "-w")
+	case "linux", "freebsd", "openbsd", "netbsd":
+		cmd = exec.Command("secret-tool", "lookup", "service", keychainService, "account", keychainAccount(key))
+	default:
+		return "", errNoKeychain
+	}
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Keep a token in the keychain
+// The token is given on standard input, never as an argument other users could see
+func keychainStore(key, token string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		if strings.ContainsAny(token, "\"\\ \t\r\n") {
+			return errors.New("token can't be quoted for security")
+		}
+		cmd = exec.Command("security", "-i")
+		cmd.Stdin = strings.NewReader("add-generic-password -U -s " + keychainService + " -a " + keychainAccount(key) + " -l generator -w \"" + token + "\"\n")
+	case "linux", "freebsd", "openbsd", "netbsd":
+		cmd = exec.Command("secret-tool", "store", "--label=generator refresh token", "service", keychainService, "account", keychainAccount(key))
+		cmd.Stdin = strings.NewReader(token)
+	default:
+		return errNoKeychain
+	}
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return errors.New(err.Error() + " → " + msg)
+		}
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Key Vault DNS suffixes, by cloud
+var vaultSuffixes = []string{
+	".vault.azure.net",
+	".vault.azure.cn",
+	".vault.usgovcloudapi.net",
+	".vault.microsoftazure.de",
+}
+
+// KeyVault resolves Key Vault secret URIs with the ambient Azure AD credential
+type KeyVault struct {
+	tokens  map[string]string // Tokens by resource, one per cloud
+	secrets map[string]string // Secrets by URI, each is fetched once
+	client  *http.Client
+}
+
+// Secrets resolved for this run
+var keyVault = &KeyVault{}
+
+// Is the string a Key Vault secret URI, such as https://myvault.vault.azure.net/secrets/name?
+// The vault's resource is returned for minting a token
+func keyVaultResource(s string) (string, bool) {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme != "https" || !strings.HasPrefix(u.Path, "/secrets/") {
+		return "", false
+	}
+
+	host := strings.ToLower(u.Hostname())
+	for _, suffix := range vaultSuffixes {
+		if strings.HasSuffix(host, suffix) {
+			return "https://" + strings.TrimPrefix(suffix, "."), true
+		}
+	}
+
+	return "", false
+}
+
+// Secret fetches the latest, or a given version, of a secret by its URI
+func (k *KeyVault) Secret(uri string) (string, error) {
+	if k.client == nil {
+		k.client = &http.Client{Timeout: 30 * time.Second}
+		k.tokens = make(map[string]string)
+		k.secrets = make(map[string]string)
+	}
+
+	if secret, ok := k.secrets[uri]; ok {
+		return secret, nil
+	}
+
+	resource, ok := keyVaultResource(uri)
+	if !ok {
+		return "", errors.New("not a Key Vault secret URI → " + uri)
+	}
+
+	token, ok := k.tokens[resource]
+	if !ok {
+		a := &AAD{Resource: resource, Credential: *aadCred}
+		var err error
+		token, err = a.Token()
+		if err != nil {
+			return "", err
+		}
+		k.tokens[resource] = token
+	}
+
+	req, err := http.NewRequest("GET", uri+"?api-version=7.4", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	var bundle struct {
+		Value string `json:"value"`
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	json.Unmarshal(buf, &bundle)
+	if resp.StatusCode != 200 {
+		if bundle.Error.Message != "" {
+			return "", errors.New(resp.Status + ": " + bundle.Error.Message)
+		}
+		return "", errors.New("Key Vault responded " + resp.Status)
+	}
+
+	chat("keyvault: resolved " + uri + "\n")
+	k.secrets[uri] = bundle.Value
+	return bundle.Value, nil
+}
@@ -6,28 +6,31 @@ package main
 import (
 	"bufio"
 	"bytes"
-	"crypto/rand"
-	"encoding/json"
+	"context"
 	"errors"
 	"flag"
 	"fmt"
-	"math/big"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"os"
-	"regexp"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/seh-msft/cfg"
+	"github.com/seh-msft/generator/pkg/generator"
 	"github.com/seh-msft/openapi"
 )
 
-// Result indicates the type of result of a lookup
-type Result int
-
+// Exit codes for gating in pipelines
 const (
-	something Result = iota // Something was found (1+ results)
-	nothing                 // Nothing was found
-	fuzzing                 // The caller should invoke contextual fuzzing
+	exitClean       = 0 // Nothing suspicious was found
+	exitFindings    = 1 // Suspicious responses were found
+	exitError       = 2 // Usage or generation error
+	exitUnreachable = 3 // The target could not be reached
+	exitOutput      = 4 // Results could not be written
 )
 
 // RequestStrings is a table of HTTP requests to emit
@@ -36,34 +39,139 @@ type RequestStrings struct {
 	Requests []string
 }
 
-// Request represents an HTTP request and associated meta-information.
-type Request struct {
-	*http.Request                 // HTTP request
-	Method        *openapi.Method // Method related to our request
-}
+// Requests, their responses, and the two together, as the generator package builds them
+type (
+	Request  = generator.Request
+	Response = generator.Response
+	Set      = generator.Set
+)
 
 var (
 	auth          = flag.String("auth", "", "'Authorization: Bearer' header token value")
 	apiName       = flag.String("api", "", "OpenAPI JSON file to parse")
 	dbName        = flag.String("db", "", "key=value database to read identifiers from")
-	chatty        = flag.Bool("D", false, "verbose logging output")
+	envFallback   = flag.Bool("envfallback", false, "Take identifiers missing from the db from GEN_name environment variables")
+	dbKey         = flag.String("dbkey", "", "age identity file to decrypt an encrypted db with, otherwise the passphrase is taken from GEN_DB_PASSPHRASE")
+	dbAuth        = flag.String("dbauth", "", "Authorization header value, or bearer token, for fetching a -db URL")
+	interactive   = flag.Bool("interactive", false, "Prompt on the terminal for parameters the db has no values for")
+	missingDbName = flag.String("missingdb", "", "Write a skeleton db of the parameters which couldn't be filled to this file")
+	writeDbName   = flag.String("writedb", "", "Write the db, updated with values extracted from responses, to this file")
+	csvName       = flag.String("csv", "", "CSV file of identifiers, one column each, to build a request set per row from")
+	chatty        = flag.Bool("D", false, "Same as -vv")
+	verbose       = flag.Bool("v", false, "Log what the run does, such as how many requests were built")
+	veryVerbose   = flag.Bool("vv", false, "Log what -v does, and how each request was built, parameter by parameter")
+	quiet         = flag.Bool("q", false, "Log nothing but errors, only results are written")
 	printReqs     = flag.Bool("printreqs", false, "log HTTP bodies")
 	strict        = flag.Bool("strict", false, "if a value can't be filled, fail")
 	proto         = flag.String("proto", "https", "HTTP protocol to use")
-	outName       = flag.String("o", "-", "file name to write output to")
+	outName       = flag.String("o", "-", "File to write output to, whole or not at all, its extension implying -format: .json, .jsonl, .har, .xml (junit), or .md (markdown)")
+	artifactDir   = flag.String("artifacts", "", "Directory to write everything from a run to, in one of its own: options, requests, responses, reports, and the log")
+	outDir        = flag.String("outdir", "", "Directory to write each request to as a raw HTTP file, with an index")
 	allBodies     = flag.Bool("allbodies", false, "force writing a body for ALL requests")
+	sequence      = flag.Bool("sequence", false, "Build a request per enumerated value of multi-valued parameters, in step")
+	cartesian     = flag.Int("cartesian", 0, "Build up to this many requests per operation from the cross product of multi-valued parameters")
+	concurrency   = flag.Int("concurrency", 1, "Requests to replay at once, at most 32")
+	reqTimeout    = flag.Duration("timeout", 0, "Longest a replayed request may take, including its body, 0 for no limit")
+	replayRate    = flag.Float64("rate", 0, "Requests to start replaying per second, 0 for no limit")
+	bodyLimit     = flag.Int("bodylimit", 0, "Bytes of each response body to keep in results, 0 to keep all")
+	deadline      = flag.Duration("deadline", 0, "Longest a run, or each call and job of -listen, may take, 0 for no limit")
 	port          = flag.String("listen", "", "TCP port to listen on for HTTP (if any)")
+	grpcPort      = flag.String("grpc", "", "TCP port to listen on for gRPC alongside -listen (if any)")
+	serverKeys    = flag.String("serverkeys", "", "File of caller=key API keys, one of which callers of -listen must give")
+	serverTenant  = flag.String("servertenant", "", "Azure AD tenant whose tokens callers of -listen may give")
+	audience      = flag.String("serveraudience", "", "Audience Azure AD tokens for -servertenant must be issued for, required with -servertenant")
+	auditLog      = flag.String("auditlog", "", "File to append an audit record of each call to -listen and job it runs to, as JSON lines, - for stdout (default stderr)")
+	drainTimeout  = flag.Duration("draintimeout", 30*time.Second, "How long -listen waits for calls and jobs to finish when shutting down")
+	maxBody       = flag.Int64("maxbody", 10<<20, "Largest request body -listen accepts, in bytes")
+	maxFetch      = flag.Int64("maxfetch", 32<<20, "Largest cfgpath or api document -listen fetches, in bytes")
+	fetchTimeout  = flag.Duration("fetchtimeout", 30*time.Second, "How long -listen waits for a cfgpath or api document, including its body")
+	readTimeout   = flag.Duration("readtimeout", time.Minute, "How long -listen waits to read a request")
+	writeTimeout  = flag.Duration("writetimeout", 10*time.Minute, "How long -listen may take to answer a request, including streams")
+	maxJobs       = flag.Int("maxjobs", 4, "Jobs -listen runs at once, others wait their turn")
+	queueSize     = flag.Int("queue", 100, "Jobs -listen holds waiting to run before refusing more")
+	jobTTL        = flag.Duration("jobttl", time.Hour, "How long -listen keeps a finished job and its results")
+	keepJobs      = flag.Int("keepjobs", 1000, "Most finished jobs -listen keeps, the oldest going first")
+	rateLimit     = flag.Float64("ratelimit", 60, "Generation requests and jobs each caller of -listen may make a minute, 0 for no limit")
+	rateBurst     = flag.Int("rateburst", 10, "Generation requests and jobs each caller of -listen may make at once, before -ratelimit applies")
+	corsOrigins   = flag.String("corsorigins", "", "Origins browsers may call -listen from, comma-separated, * for any")
+	corsMethods   = flag.String("corsmethods", "GET,POST,DELETE", "Methods browsers may call -listen with from -corsorigins, comma-separated")
+	allowHosts    = flag.String("allowhosts", "", "Hosts, *.domains, and CIDRs -listen may fetch from, replay against, and call back, comma-separated (default any)")
+	denyHosts     = flag.String("denyhosts", privateHosts, "Hosts, *.domains, and CIDRs -listen may never connect to, comma-separated, by default loopback, private, and link-local networks")
+	profilesName  = flag.String("profiles", "", "JSON or YAML file of named profiles callers of -listen may take options from")
+	specTTL       = flag.Duration("specttl", 5*time.Minute, "How long -listen reuses a parsed api document before checking it's changed, 0 to not cache")
 	cert          = flag.String("cert", "", "Certificate (if listening HTTPS)")
 	key           = flag.String("key", "", "Private key (if listening HTTPS)")
 	noReplay      = flag.Bool("noreplay", false, "Do not replay built requests")
+	dryRun        = flag.Bool("dryrun", false, "Print the replay plan, each request in order with where its values came from, sending nothing")
+	userAgent     = flag.String("useragent", "", "User-Agent for every request (default generator/version with the run's id)")
+	noCorrelate   = flag.Bool("nocorrelate", false, "Do not give each request an X-Request-ID and traceparent to find it by in the target's logs")
 	ado           = flag.Bool("ado", false, "Use ADO output mode for replay results")
+	adoRun        = flag.String("adorun", "", "Publish results as an ADO test run with this name")
+	notifyHook    = flag.String("notify", "", "Teams or Slack webhook URL to post a run summary to")
+	notifyLink    = flag.String("notifylink", "", "Report link for -notify (default is the pipeline run, if any)")
+	appInsights   = flag.String("appinsights", os.Getenv("APPLICATIONINSIGHTS_CONNECTION_STRING"), "Application Insights connection string to export per-request telemetry to")
+	otlp          = flag.String("otlp", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "OpenTelemetry OTLP/HTTP endpoint to export spans of the run to, propagating traceparent to the target")
+	elastic       = flag.String("elastic", "", "Elasticsearch/OpenSearch URL to bulk-index results into")
+	elasticIndex  = flag.String("elasticindex", "generator", "Elasticsearch/OpenSearch index for -elastic")
+	sqliteName    = flag.String("sqlite", "", "SQLite database file to persist results into")
+	gha           = flag.Bool("gha", false, "Use GitHub Actions output mode for replay results")
+	jsonl         = flag.Bool("jsonl", false, "Stream one JSON object per line as each request completes")
+	failOn        = flag.String("fail-on", "suspicious>0", "Thresholds which fail the run (suspicious>0,missing>10,coverage<80%)")
+	indent        = flag.Bool("indent", false, "Indent JSON output for humans")
+	full          = flag.Bool("full", false, "Include complete requests and responses in JSON results")
+	format        = flag.String("format", "", "Output format: json, jsonl, ado, gha, junit, summary, markdown, har, or template (default as per -o, or summary on a terminal when replaying, otherwise json)")
+	tmplName      = flag.String("template", "", "Go text/template file to execute against results for output")
 	ignoreMethods = flag.String("ignoremethods", "", "HTTP methods to not build (PUT,PATCH)")
+	methods       = flag.String("methods", "", "HTTP methods to build, otherwise all (GET,HEAD)")
+	watch         = flag.Bool("watch", false, "Run again whenever the -api, -db, -csv, -template, or config file changes, until interrupted")
+	showProgress  = flag.Bool("progress", false, "Show replay progress on stderr even when it isn't a terminal, as a line every 10s")
+	limit         = flag.Int("limit", 0, "Most requests to build and replay, 0 for no limit")
+	sample        = flag.String("sample", "first", "Which requests -limit keeps: first or random")
+	stream        = flag.Bool("stream", false, "Parse the spec and build requests a path item at a time, so specs too large to hold at once fit in little memory")
+	specCacheDir  = flag.String("speccache", "", "Directory to keep parsed specs in, by their contents, so runs against an unchanged -api skip parsing it")
+	since         = flag.String("since", "", "Older OpenAPI JSON file, such as a pull request's base, to build only operations added or changed since")
+	paths         = flag.String("paths", "", "Path templates to build, regular expressions or globs, comma-separated (/users/.*,/orders/{orderId})")
+	oauthFlow     = flag.String("oauth", "", "Acquire -auth interactively with an OAuth flow: device or authcode")
+	oauthIssuer   = flag.String("oauthissuer", "", "OpenID Connect issuer URL for -oauth")
+	oauthClient   = flag.String("oauthclient", "", "OAuth public client ID for -oauth")
+	oauthScope    = flag.String("oauthscope", "openid offline_access", "OAuth scopes for -oauth")
+	basic         = flag.String("basic", "", "HTTP Basic credentials as user:pass (default from the db's basic entries)")
+	ntlm          = flag.String("ntlm", "", "Windows integrated (NTLM/Negotiate) credentials as DOMAIN\\user:pass")
+	apiKey        = flag.String("apikey", "", "Key for the spec's apiKey security schemes (default from the db)")
+	aadResource   = flag.String("aad", "", "Mint -auth from Azure AD for this resource or scope")
+	aadCred       = flag.String("aadcred", "chain", "Azure AD credential for -aad: chain, env, managedidentity, or azcli")
+	cookie        = flag.String("cookie", "", "'Cookie:' header value for session cookies, such as 'session=abc; csrf=def'")
+	signSpec      = flag.String("sign", "", "Sign each request on replay: hmac, or exec:command to run a signer")
+	signKey       = flag.String("signkey", "", "HMAC key for -sign hmac")
+	signHeader    = flag.String("signheader", "X-Signature", "Header for the -sign hmac signature")
+	identity      = flag.String("identity", "", "Named identity in the db to take credentials and identifiers from")
+	authFileName  = flag.String("authfile", "", "File to read the -auth token from, read again whenever it changes")
 	noAuth        = flag.Bool("noauth", false, "Strip Authorization: and Cookie: headers")
 	target        = flag.String("target", "", "Hostname to force target replay to")
+	noRedact      = flag.Bool("noredact", false, "Do not redact secrets from output and logs")
+	configName    = flag.String("config", "", "YAML file of flags to run with, flags given override it (default .generator.yaml, if present)")
+
+	runID       string      // Unique to this run, for correlating exported results
+	runStarted  time.Time   // When this run started
+	sinks       Sinks       // Outputs, as per -output
+	redactFlags RedactFlags // Extra secret patterns, as per -redact
+	hookFlags   HookFlags   // Commands to run around each request, as per -hook
+	headerFlags HeaderFlags // Headers for every request, as per -H
+	shuffle     ShuffleFlag // Seed to shuffle replay order with, as per -shuffle
+	refresher   *Refresher  // Renews the token mid-run, if it came from a provider
 
 	stderr *bufio.Writer
 )
 
+func init() {
+	flag.Var(&sinks, "output", "Output as format=file, repeatable (ado=- for stdout)")
+	flag.Var(&redactFlags, "redact", "Regular expression for secrets to redact from output, repeatable")
+	flag.Var(&shuffle, "shuffle", "Replay requests in a random order, or that of -shuffle=seed, logging the seed")
+	flag.Var(&headerFlags, "H", "Header for every request, such as 'X-Env: staging', repeatable")
+	flag.Var(&hookFlags, "hook", "Command to run before each replayed request and after each response, repeatable")
+	flag.Usage = usage
+}
+
 // Generator is a tool to generate HTTP requests from an OpenAPI specification.
 func main() {
 	flag.Parse()
@@ -71,505 +179,758 @@ func main() {
 	stderr = bufio.NewWriter(os.Stderr)
 	defer stderr.Flush()
 
-	// TODO - output file flag
-	var out *bufio.Writer = bufio.NewWriter(os.Stdout)
-	defer out.Flush()
+	// `generator db check` lints a db instead
+	// Other subcommands take only the flags which apply to them
+	var cmd *Subcommand
+	checking := false
+	if args := flag.Args(); len(args) > 1 && args[0] == "db" && args[1] == "check" {
+		flag.CommandLine.Parse(args[2:])
+		checking = true
+	} else if len(args) > 0 {
+		if cmd = findSubcommand(args[0]); cmd != nil {
+			err := cmd.parse(args[1:])
+			if err != nil {
+				fatal("err:", err)
+			}
+		}
+	}
 
-	// Generator As A Service
-	// TODO - propagate flags as setting defaults for listener?
-	if *port != "" {
-		if (*cert != "" || *key != "") && (*cert == "" || *key == "") {
-			fatal("err: if using TLS, both -key and -cert must be provided")
+	// Flags not given may come from the environment, then a file committed with the spec
+	err := applyEnv(cmd)
+	if err != nil {
+		fatal("err: could not apply environment →", err)
+	}
+	err = applyConfig(*configName, cmd)
+	if err != nil {
+		fatal("err: could not apply config →", err)
+	}
+
+	// Each subcommand is a mode of the flags
+	switch cmd.name() {
+	case "generate":
+		*noReplay = true
+	case "replay":
+		// Results are kept for report to judge the run by
+		*failOn = ""
+		if *format == "" && formatFor(*outName) == "" {
+			*format = "jsonl"
+		}
+	case "serve":
+		if *port == "" {
+			fatal("err: serve requires -listen")
 		}
-		listen(*port, *cert, *key)
-		return
 	}
 
-	// TODO - 'Cookie:' header
-	if (*auth == "" && !*noAuth) || *apiName == "" || *dbName == "" {
-		fatal("err: must supply all of -auth, -api, and -db ")
+	// Inner loop development runs again on every save
+	// Runs -watch starts only run, whatever their config or environment says, or each would start its own
+	if *watch && watchChild() {
+		*watch = false
+	}
+	if *watch {
+		if *port != "" {
+			fatal("err: -watch is for runs, not -listen")
+		}
+		stderr.Flush()
+		os.Exit(watchRuns())
 	}
 
-	f, err := os.Open(*apiName)
-	if err != nil {
-		fatal("err: could not open API file →", err)
+	if checking {
+		stderr.Flush()
+		os.Exit(dbCheck(os.Stdout))
 	}
 
-	api, err := openapi.Parse(f)
+	// Secrets may be referenced from the environment or Key Vault rather than given
+	err = expandFlags()
 	if err != nil {
-		fatal("err: could not parse API →", err)
+		fatal("err: could not resolve flag →", err)
 	}
 
-	// Override target
-	if *target != "" {
-		api.Servers = []openapi.Server{{URL: *target}}
+	runID = randomID(16)
+	runStarted = time.Now()
+	tracer := newTracer(*otlp)
+
+	// Secrets stay out of every output and log line
+	if !*noRedact {
+		err := initRedactions(redactFlags, *auth, *basic, *apiKey, *cookie, *ntlm, *signKey, *dbAuth, os.Getenv(dbPassphraseEnv))
+		if err != nil {
+			fatal("err: could not compile -redact pattern →", err)
+		}
 	}
 
-	// Remove relevant methods from API signatures
-	if len(*ignoreMethods) > 0 {
-		for _, method := range strings.Split(*ignoreMethods, ",") {
-			for _, methods := range api.Paths {
+	// Everything from the run in one place, for CI to archive
+	var artifacts *Artifacts
+	if *artifactDir != "" {
+		if *port != "" {
+			fatal("err: -artifacts is for runs, not -listen")
+		}
 
-				down := strings.ToLower(method)
-				delete(methods, down)
-			}
+		artifacts, err = newArtifacts(*artifactDir)
+		if err != nil {
+			fatal("err: could not make -artifacts directory →", err)
 		}
+		err = artifacts.Options()
+		if err != nil {
+			die(exitOutput, "err: could not write options to -artifacts →", err)
+		}
+		chat("Writing artifacts to " + artifacts.Dir)
 	}
 
-	db := ingestDb(*dbName)
-	// Insert authorization
-	// TODO - Make cleaner as per https://github.com/seh-msft/cfg/issues/1
-	if !*noAuth {
-		// TODO - this might need to be stubbed for after to permit api path building
-		db.Records = append(db.Records, &cfg.Record{Tuples: []*cfg.Tuple{{Attributes: []*cfg.Attribute{{Name: "Authorization", Value: "Bearer " + *auth}}}}})
+	// Individual format flags are shorthand for -format
+	switch {
+	case *ado:
+		*format = "ado"
+	case *gha:
+		*format = "gha"
+	case *jsonl:
+		*format = "jsonl"
+	case *tmplName != "":
+		*format = "template"
 	}
-	db.BuildMap()
 
-	requests, missing, totalPossible, err := generate(api, db)
+	thresholds, err := parseThresholds(*failOn)
 	if err != nil {
-		fatal("fatal: generation failed ⇒ ", err)
+		fatal("err: could not parse -fail-on →", err)
 	}
 
-	chat(fmt.Sprintf("Built %d/%d requests (%.0f%%)\n", len(requests), totalPossible, 100*(float64(len(requests))/float64(totalPossible))))
-	chat(fmt.Sprintf("Parameters missed: %v\n", missing))
+	// Humans get a summary, machines get JSON, files what their name says
+	if *format == "" {
+		*format = formatFor(*outName)
+	}
+	if *format == "" {
+		*format = "json"
+		if *outName == "-" && isTerminal(os.Stdout) && !*noReplay {
+			*format = "summary"
+		}
+	}
 
-	// If we don't replay, emit built requests
-	if *noReplay {
-		enc := json.NewEncoder(out)
-		enc.Encode(requests2strings(requests))
+	err = checkFormat(*format)
+	if err != nil {
+		fatal("err:", err)
+	}
+
+	var out *bufio.Writer = bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	// Results of earlier runs are written again
+	if cmd.name() == "report" {
+		writeReport(out, flag.Args(), thresholds)
 		return
 	}
 
-	// Optionally replay requests
-	results := make(map[*Request]*Response)
-	for _, request := range requests {
-		resp := replay(request.Request, nil)
-		results[request] = &resp
+	// Sign requests as they go out, a run's and -listen jobs' alike
+	if *signSpec != "" {
+		s, err := newSigner(*signSpec, *signKey, *signHeader)
+		if err != nil {
+			fatal("err: could not set up signing →", err)
+		}
+		signer = s
+		sensitiveHeaders[http.CanonicalHeaderKey(*signHeader)] = true
 	}
 
-	// Optionally validate against spec
-	sus, ok, err := validate(results)
+	// Hooks may mutate requests and fail responses
+	hooks, err = newHooks(hookFlags)
+	if err != nil {
+		fatal("err: could not set up hooks →", err)
+	}
+
+	// Generator As A Service
+	// The token may be kept in a file by something else
+	if *authFileName != "" && *auth == "" && !*noAuth {
+		authFile = &AuthFile{Name: *authFileName}
+		token, err := authFile.Token()
+		if err != nil {
+			fatal("err: could not read -authfile →", err)
+		}
+		*auth = token
+		refresher = &Refresher{Source: authFile, Token: token}
+	}
 
-	// Emit ADO format
-	if *ado {
-		printADO(out, requests, missing, sus, ok)
+	// TODO - propagate flags as setting defaults for listener?
+	if *port != "" {
+		if (*cert != "" || *key != "") && (*cert == "" || *key == "") {
+			fatal("err: if using TLS, both -key and -cert must be provided")
+		}
+		listen(*port, *cert, *key)
 		return
 	}
 
-	// Emit as JSON by default
-	err = printJSON(out, requests, missing, sus, ok)
-	if err != nil {
-		fatal("err: could not marshal requests →", err)
+	// The run stops early if interrupted, or at -deadline
+	ctx, stop := runContext()
+	defer stop()
+
+	// Credentials may come from a named identity in the db
+	var id *Identity
+	if *identity != "" {
+		if *dbName == "" {
+			fatal("err: -identity requires -db")
+		}
+
+		var err error
+		id, err = findIdentity(ingestDb(ctx, *dbName), *identity)
+		if err != nil {
+			fatal("err: could not use identity →", err)
+		}
+		id.apply()
 	}
 
-	out.Flush()
-}
+	// Sign in to get a token, if asked
+	if *oauthFlow != "" && *auth == "" && !*noAuth {
+		if *oauthIssuer == "" || *oauthClient == "" {
+			fatal("err: -oauth requires -oauthissuer and -oauthclient")
+		}
 
-// Convert []requests → []string
-func requests2strings(requests []*Request) RequestStrings {
-	var reqStrings []string
-	for _, request := range requests {
-		reqStrings = append(reqStrings, prettyRequest(request.Request))
+		o := &OAuth{Issuer: *oauthIssuer, ClientID: *oauthClient, Scope: *oauthScope}
+		token, err := o.Acquire(*oauthFlow)
+		if err != nil {
+			fatal("err: could not acquire OAuth token →", err)
+		}
+		*auth = token
+		refresher = &Refresher{Source: &oauthSource{o, *oauthFlow}, Token: token}
 
-		if *printReqs {
-			emit(prettyRequest(request.Request) + "\n\n")
+		if !*noRedact {
+			redactSecret(token)
 		}
 	}
-	return RequestStrings{reqStrings}
-}
 
-// Do generation step, all we need is an api and a db
-func generate(api openapi.API, db cfg.Cfg) ([]*Request, map[string]uint64, uint64, error) {
+	// Mint an Azure AD token, if asked
+	if *aadResource != "" && *auth == "" && !*noAuth {
+		a := &AAD{Resource: *aadResource, Credential: *aadCred}
+		token, err := a.Token()
+		if err != nil {
+			fatal("err: could not acquire Azure AD token →", err)
+		}
+		*auth = token
+		refresher = &Refresher{Source: a, Token: token}
 
-	failed := make(map[string]error)
-	var requests []*Request
-	totalPossible := uint64(0)
-	missing := make(map[string]uint64)
-
-	// "/foo/bar", map["get"]Method{}
-	for path, methods := range api.Paths {
-		chat(path + ":\n")
-
-		// "get", Method{}
-	methods:
-		for httpMethod, method := range methods {
-			totalPossible++
-			// TODO - openapi parse "requestBody" for POST, etc.
-			chat("\t" + httpMethod + ":\n")
-
-			chat("\t\t" + method.Summary + "\n\n")
-
-			// Were all the parameters filled from the db?
-			var paths, queries, headers []openapi.Parameter
-			var body bytes.Buffer
-
-			// Scan parameters for where they will be substituted in the request to build
-			// Parameter.In = "path", "query", or "header"
-			for _, param := range method.Parameters {
-				if !param.Required {
-					// TODO - attempt to fill non-required parameters
-					// Might be non-trivial
-					continue
-				}
+		if !*noRedact {
+			redactSecret(token)
+		}
+	}
 
-				switch strings.ToLower(param.In) {
-				case "path":
-					paths = append(paths, param)
+	if (*auth == "" && *basic == "" && *ntlm == "" && *apiKey == "" && *cookie == "" && !*noAuth) || *apiName == "" || (*dbName == "" && *csvName == "") {
+		fatal("err: must supply all of -auth (or -basic, -ntlm, -apikey, or -cookie), -api, and -db (or -csv)")
+	}
 
-				case "query":
-					queries = append(queries, param)
+	if (*auth != "" && *basic != "") || (*auth != "" && *ntlm != "") || (*basic != "" && *ntlm != "") {
+		fatal("err: provide only one of -auth, -basic, or -ntlm")
+	}
 
-				case "header":
-					headers = append(headers, param)
-				}
+	if *writeDbName != "" && *dbName == "" {
+		fatal("err: -writedb requires -db")
+	}
 
-				chat("\t\t" + param.In + " — " + param.Name + "\n")
-			}
+	// The Authorization header for every request, if any
+	authorization := ""
+	switch {
+	case *basic != "":
+		authorization = basicAuthorization(*basic)
+	case *ntlm != "" && !*noAuth:
+		// The handshake is negotiated from Basic credentials on replay
+		authorization = basicAuthorization(*ntlm)
+		transport = negotiator()
+	case *auth != "":
+		authorization = "Bearer " + *auth
+	}
 
-			// Insert path parameters
-			// TODO - build URL/request for each server if multiple servers exist
-			if len(api.Servers) < 1 {
-				return nil, nil, 0, errors.New("err: need at least one server to call, none provided")
+	// Without -output flags, -format goes to -o
+	// A dry run writes only its plan, to standard output
+	if *dryRun {
+		sinks = nil
+	}
+	addOutName()
+	if !*dryRun {
+		sinks = append(sinks, artifacts.Sinks(sinks)...)
+	}
+	if *noReplay && !*dryRun {
+		for _, sink := range sinks {
+			err := checkRequestsFormat(sink.Format)
+			if err != nil {
+				fatal("err:", err)
 			}
+		}
+	}
 
-			fullPath := *proto + api.Servers[0].URL + path
-			for _, parameter := range paths {
-				values, r := lookup(db, parameter.Name, path, api.Info.Title)
-				switch r {
-				case something:
-					apiForm := fmt.Sprintf(`{%s}`, parameter.Name)
-					// TODO - sequencing
-					fullPath = strings.ReplaceAll(fullPath, apiForm, values[0])
-
-				case nothing:
-					if *strict {
-						return nil, nil, 0, errors.New("err: could not find path parameter →" + parameter.Name)
-					}
-
-					missing[parameter.Name]++
-					failed[path] = errors.New(fmt.Sprint("could not find query parameters → ", parameter))
-					continue methods
-				case fuzzing:
-					// TODO - fuzz - maybe should remove this 'feature' skeleton
-				default:
-				}
-			}
+	// Open outputs early so mistakes don't waste a run
+	for _, sink := range sinks {
+		err := sink.Open(out)
+		if err != nil {
+			fatal("err: could not open output →", err)
+		}
+	}
 
-			// Build body, if required
-			if method.RequestBody.Required || *allBodies {
-				// TODO - break out different formats
-				ref := method.RequestBody.Content["application/json"]["schema"].Ref
-				// We get #/components/schemas/ as a prefix sometimes
-				refLess := strings.TrimPrefix(ref, "#/components/schemas/")
-
-				found := false
-				var target openapi.Type
-
-				// Find our definition by ref
-			search:
-				// All types in the schema table
-				for typeName, t := range api.Components["schemas"] {
-
-					// Properties are elements in the body
-					for _, property := range t.Properties {
-						schema := property.Items
-						if schema.Ref == ref || schema.Ref == refLess || typeName == ref || typeName == refLess {
-							// We found our type ref
-							target = t
-							found = true
-
-							break search
-						}
-					}
-				}
+	span := tracer.Start("parse spec", nil)
+	var spec []byte
+	var api openapi.API
+	var security Security
+	formats := make(generator.Formats)
+	if *stream {
+		// Paths are read as they're built
+		err = checkStream()
+		if err != nil {
+			fatal("err: could not stream API →", err)
+		}
 
-				// Start constructing JSON for the body
-				// TODO - an actual recursive object builder?
-				//		"object" could trigger a new map[] level
-				obj := make(map[string]string)
-				if found {
-					// We know the scheme, fill all we can
-					for name, property := range target.Properties {
-						// Fill values we know
-						values, r := lookup(db, name, path, api.Info.Title)
-						switch r {
-						case something:
-							// TODO - sequencing?
-							obj[name] = values[0]
-
-						case nothing:
-							fallthrough
-						case fuzzing:
-							obj = randProperty(obj, name, property)
-						}
-					}
-				} else {
-					// Unknown scheme - let object be {}
-					// TODO - strict mode fatal?
-				}
+		api, security, err = specHead(*apiName)
+		if err != nil {
+			fatal("err: could not parse API →", err)
+		}
+	} else {
+		spec, err = ioutil.ReadFile(*apiName)
+		if err != nil {
+			fatal("err: could not open API file →", err)
+		}
 
-				enc := json.NewEncoder(&body)
-				enc.Encode(obj)
+		// Runs against an unchanged spec needn't parse it again
+		parsed := readSpecCache(*specCacheDir, spec)
+		span.Set("generator.cached", parsed != nil)
+		if parsed == nil {
+			parsed, err = parseSpec(spec)
+			if err != nil {
+				fatal("err: could not parse API →", err)
 			}
 
-			// Generate request structure
-			httpReq, err := http.NewRequest(strings.ToUpper(httpMethod), fullPath, &body)
+			err = writeSpecCache(*specCacheDir, spec, parsed)
 			if err != nil {
-				if *strict {
-					return nil, nil, 0, errors.New("err: could not build request → " + err.Error())
-				}
-
-				failed[path] = err
-				continue methods
+				warn("warn: could not cache parsed API →", err)
 			}
+		}
+		api, security, formats = parsed.API, parsed.Security, parsed.Formats
+		span.Set("generator.operations", len(api.Paths))
+	}
+	span.Set("generator.api", api.Info.Title)
+	span.Finish()
 
-			// Insert query parameters
-			vals := httpReq.URL.Query()
-			for _, parameter := range queries {
-				values, r := lookup(db, parameter.Name, path, api.Info.Title)
-				switch r {
-				case something:
+	// The same options a caller of -listen may give
+	opts := flagOptions()
+	err = opts.check()
+	if err != nil {
+		fatal("err: bad options →", err)
+	}
+	pacing, _ := opts.pacing()
 
-					// TODO - sequencing/fuzzing?
-					vals[parameter.Name] = []string{values[0]}
+	// Override target
+	if opts.Target != "" {
+		api.Servers = []openapi.Server{{URL: opts.Target}}
+	}
 
-				case nothing:
-					if *strict {
-						return nil, nil, 0, errors.New("err: could not find query parameter → " + parameter.Name)
-					}
+	// Remove paths and methods we weren't asked for
+	opts.narrow(&api)
 
-					missing[parameter.Name]++
-					failed[path] = errors.New(fmt.Sprint("could not find query parameters → ", parameter))
-					continue methods
+	// Only what changed, such as in a pull request
+	if *since != "" {
+		old, err := ioutil.ReadFile(*since)
+		if err != nil {
+			fatal("err: could not open -since API file →", err)
+		}
 
-				case fuzzing:
-					// TODO - fuzzing?
-				}
+		changed, err := changedOperations(old, spec)
+		if err != nil {
+			fatal("err: could not compare API files →", err)
+		}
+		onlyChanged(&api, changed)
+		if len(changed) < 1 {
+			warn("warn: no operations changed since " + *since)
+		} else {
+			chat("Operations changed since " + *since + ": " + strings.Join(changedNames(changed), ", "))
+		}
+	}
 
-			}
-			httpReq.URL.RawQuery = vals.Encode()
-
-			// Override HTTP headers
-			for _, parameter := range headers {
-				values, r := lookup(db, parameter.Name, path, api.Info.Title)
-
-				switch r {
-				case something:
-					// TODO - sequencing
-					httpReq.Header[parameter.Name] = []string{values[0]}
-
-				case nothing:
-					if *strict {
-						return nil, nil, 0, errors.New("err: could not find header parameter → " + parameter.Name)
-					}
-
-					missing[parameter.Name]++
-					failed[path] = errors.New(fmt.Sprint("could not find header parameter - ", parameter))
-					continue methods
-				case fuzzing:
-					// TODO - fuzzing?
-				}
-			}
+	var db cfg.Cfg
+	if *dbName != "" {
+		db = ingestDb(ctx, *dbName)
+	}
+	db = withIdentity(db, id)
+	// Insert authorization
+	// TODO - Make cleaner as per https://github.com/seh-msft/cfg/issues/1
+	if !*noAuth && authorization != "" {
+		// TODO - this might need to be stubbed for after to permit api path building
+		db.Records = append(db.Records, &cfg.Record{Tuples: []*cfg.Tuple{{Attributes: []*cfg.Attribute{{Name: "Authorization", Value: authorization}}}}})
+	}
+	db.BuildMap()
+
+	opts.Formats = formats
 
-			requests = append(requests, &Request{httpReq, &method})
+	// Ask for what the db is missing
+	if *interactive {
+		if !isTerminal(os.Stdin) {
+			fatal("err: -interactive requires a terminal")
 		}
+		prompter = newPrompter(spec)
+		opts.Ask = prompter.Ask
+	}
 
-		chat("\n")
+	span = tracer.Start("generate", nil)
+	var requests []*Request
+	var missing map[string]uint64
+	var totalPossible uint64
+	generate := func(api openapi.API) ([]*Request, map[string]uint64, uint64, error) {
+		if *csvName != "" {
+			return generateRows(ctx, api, db, *csvName, opts.Options)
+		}
+		return generator.Generate(ctx, api, db, opts.Options)
 	}
+	prep := Preparation{Headers: headerFlags, Security: security, Authorization: authorization, APIKey: *apiKey, Cookie: *cookie}
+
+	// Streamed specs are replayed as each path item is built, below, unless every request is needed first
+	piped := *stream && !opts.NoReplay && !*dryRun && opts.Limit < 1 && opts.Shuffle == nil
+	switch {
+	case piped:
+	case *stream:
+		missing, totalPossible, err = streamSpec(*apiName, api, security, opts, generate, func(built []*Request) error {
+			requests = append(requests, built...)
+			return nil
+		})
+	default:
+		requests, missing, totalPossible, err = generate(api)
+	}
+	if ctx.Err() != nil {
+		fatal("err: run stopped →", ctx.Err())
+	}
+	if err != nil {
+		fatal("fatal: generation failed ⇒ ", err)
+	}
+	requests = opts.sample(requests)
 
-	return requests, missing, totalPossible, nil
-}
+	// Some bugs only show when operations run in an unusual order
+	if opts.Shuffle != nil {
+		requests = opts.shuffle(requests)
+		warn(fmt.Sprintf("shuffle: replaying in the order of seed %d, -shuffle=%d replays it again", *opts.Shuffle, *opts.Shuffle))
+	}
 
-// Lookup an identifier name for a given path in a given API
-// Return the set of values which are usable and an 'ok' indicator
-// Path should be in the original OpenAPI {someId} form
-func lookup(c cfg.Cfg, name, path, title string) ([]string, Result) {
-	//chat("≡ lookup ⇒ ", name, path, title)
-	var out []string
-	hasRegex := func(tuple *cfg.Tuple) bool {
-		_, has := tuple.Map["regex"]
-		return has
+	// A head start on filling the db
+	if *missingDbName != "" {
+		err := writeSkeleton(*missingDbName, missingParameters(api, db, opts.Options))
+		if err != nil {
+			die(exitOutput, "err: could not write missing db →", err)
+		}
 	}
 
-	// The attributes for record 'name' with the tuple 'name'
-	primaryAttributes, ok := c.Map[name][name]
-	if !ok {
-		return out, nothing
+	if prompter != nil && *dbName != "" {
+		err := prompter.Offer(ctx, *dbName)
+		if err != nil {
+			fatal("err: could not append answers to db →", err)
+		}
 	}
-	primaryValue, hasValue := primaryAttributes[name]
-	if hasValue {
-		// Only true if we contain at least one element
-		hasValue = len(primaryValue) > 0
+
+	err = prepare(requests, db, opts, api.Info.Title, prep)
+	if err != nil {
+		fatal("err:", err)
 	}
 
-	// Get properties for record 'name'
-	properties, hasProperties := c.Map[name]["properties"]
+	span.Set("generator.built", len(requests))
+	span.Set("generator.possible", totalPossible)
+	span.Finish()
 
-	// Short circuit if 'name' has no rules and no enumerated values
-	_, hasDisallows := c.Map[name]["disallow"]
-	_, hasPermits := c.Map[name]["permit"]
-	_, hasEnums := c.Map[name]["values"]
+	// What was built, once it all has been
+	summarize := func() {
+		chat(fmt.Sprintf("Built %d/%d requests (%.0f%%)\n", len(requests), totalPossible, 100*(float64(len(requests))/float64(totalPossible))))
+		chat(fmt.Sprintf("Parameters missed: %v\n", missing))
 
-	if !hasValue && !hasEnums {
-		// Value omitted for this identifier
-		// TODO - maybe a flag to handle this case?
-		return out, nothing
+		// Write each request to its own file
+		if *outDir != "" {
+			err := writeOutdir(*outDir, opts.Proto, requests)
+			if err != nil {
+				die(exitOutput, "err: could not write requests to directory →", err)
+			}
+		}
+	}
+	if !piped {
+		summarize()
 	}
 
-	if !hasDisallows && !hasPermits && !hasEnums && hasValue {
-		// Just the value
-		return primaryValue, something
+	// A safety check before pointing at a new environment
+	if *dryRun {
+		var plan bytes.Buffer
+		printPlan(io.MultiWriter(out, &plan), requests)
+		err := artifacts.Write("plan.txt", plan.Bytes())
+		if err != nil {
+			die(exitOutput, "err: could not write plan to -artifacts →", err)
+		}
+		return
 	}
 
-	// Records are identified by the identifier name
-	records, ok := c.Lookup(name)
-	if !ok {
-		return out, nothing
+	if !piped {
+		err = artifacts.Requests(opts.Proto, requests)
+		if err != nil {
+			die(exitOutput, "err: could not write requests to -artifacts →", err)
+		}
 	}
 
-	fuzz := false
+	// If we don't replay, emit built requests
+	if opts.NoReplay {
+		for _, sink := range sinks {
+			err := sink.Requests(requests)
+			if err != nil {
+				die(exitOutput, "err: could not emit requests →", err)
+			}
+		}
 
-	// Determine if the identifier is valid
-	// We do costly lookups here to guarantee ordering of 'permit', 'disallow', and 'values'
-	// As they are ordered and maps play with ordering
-recordSearch:
-	for _, record := range records {
-		// Sees if the tuple set has a matching attribute
-		match := func(tuples []*cfg.Tuple) bool {
-			for _, tuple := range tuples {
-				attributes := tuple.Attributes
-				// Strip 'except' or 'permit'
-				if len(attributes) > 1 {
-					attributes = attributes[1:]
-				}
+		closeSinks()
+		err := tracer.Export(*otlp)
+		if err != nil {
+			die(exitOutput, "err: could not export to OpenTelemetry →", err)
+		}
+		if !gate(thresholds, metrics(requests, totalPossible, missing, nil, nil)) {
+			stderr.Flush()
+			os.Exit(exitFindings)
+		}
+		return
+	}
 
-				// Valid determines if a given attribute entry and our name/path/title are compatible
-				valid := func(value, other string) bool {
-					return value == other
-				}
+	// Optionally replay requests
+	// Pick up a token the -authfile has been updated with
+	if authFile != nil && refresher != nil {
+		refresher.Reload()
+	}
 
-				// Use regex to test equality if requested
-				if len(attributes) > 1 && hasRegex(tuple) {
-					valid = func(value, other string) bool {
-						regex, err := regexp.Compile(value)
-						if err != nil {
-							fatal(`err: could not compile regex "`+value+`" →`, err)
-						}
+	// Each request is a span the target's traces can carry on from
+	span = tracer.Start("replay", nil)
+	if tracer != nil {
+		hooks = append([]generator.Hook{tracer.hook(span)}, hooks...)
+	}
 
-						return regex.MatchString(other)
-					}
+	// Operators can tell a long replay from a hung one
+	var prog *Progress
+	if *showProgress || (isTerminal(os.Stderr) && verbosity() >= 0) {
+		// Streamed requests are counted as they're built
+		prog = newProgress(len(requests))
+		hooks = append(hooks, prog.hook())
+	}
 
-					// Strip 'regex'
-					attributes = attributes[1:]
-				}
+	// Renewed tokens go on each request as it's sent
+	if refresher != nil {
+		hooks = append([]generator.Hook{refresher.Hook()}, hooks...)
+	}
 
-				result := false
-
-				// Search attributes in the tuple
-			searchAttributes:
-				for _, attr := range attributes {
-					test := ""
-					switch attr.Name {
-					case "title":
-						test = title
-					case "path":
-						test = path
-					default:
-						// Unknown keyword
-						// Skip
-						continue searchAttributes
-					}
-
-					if valid(attr.Value, test) {
-						// Valid and we had an invalid result
-						result = true
-					} else {
-						// Invalid and result was true
-						// A rule in the tuple was violated
-						result = false
-						break searchAttributes
-					}
-				}
+	// Retries go out as the run's requests do
+	rp := replayer(pacing)
+	if refresher != nil {
+		refresher.Replayer = rp
+	}
+
+	results := make(map[*Request]*Response)
+	done := func(request *Request, resp Response) {
+		// Tokens may expire on long runs, renew and retry once
+		if refresher != nil && refresher.Expired(request, &resp) {
+			retried, err := refresher.Retry(request, &resp)
+			if err != nil {
+				replayFailed(err)
+			}
+			resp = retried
+		}
+		results[request] = &resp
+		prog.Done()
+
+		// Stream results as they complete so partial runs are kept
+		for _, sink := range sinks {
+			err := sink.Stream(request, &resp)
+			if err != nil {
+				die(exitOutput, "err: could not emit result →", err)
+			}
+		}
+
+		if authFile != nil && refresher != nil {
+			refresher.Reload()
+		}
 
-				if result {
-					return true
+		// Streamed requests are kept for the report as they're done, in the order they were built
+		if piped {
+			requests = append(requests, request)
+		}
+	}
+	var buildErr error
+	if piped {
+		err = replayStream(ctx, rp, func(yield func([]*Request) error) {
+			missing, totalPossible, buildErr = streamSpec(*apiName, api, security, opts, generate, func(built []*Request) error {
+				err := prepare(built, db, opts, api.Info.Title, prep)
+				if err != nil {
+					return err
 				}
+				prog.Add(len(built))
+				return yield(built)
+			})
+		}, done)
+	} else {
+		err = rp.Replay(ctx, requests, done)
+	}
+	prog.Finish()
+	if piped {
+		// A build which failed, rather than being stopped by replay, is told as generation failing
+		if buildErr != nil && err == nil && ctx.Err() == nil {
+			fatal("fatal: generation failed ⇒ ", buildErr)
+		}
+		summarize()
+		if err := artifacts.Requests(opts.Proto, requests); err != nil {
+			die(exitOutput, "err: could not write requests to -artifacts →", err)
+		}
+	}
+	if err := artifacts.Responses(opts.Proto, requests, results); err != nil {
+		die(exitOutput, "err: could not write responses to -artifacts →", err)
+	}
+	if ctx.Err() != nil {
+		// Results streamed so far are kept, while reports not yet whole leave any earlier ones be
+		for _, sink := range sinks {
+			if sink.Format != "jsonl" {
+				sink.abandon()
+				continue
 			}
+			err := sink.Close()
+			if err != nil {
+				die(exitOutput, "err: could not write output →", err)
+			}
+		}
+		fatal("err: run stopped →", ctx.Err())
+	}
+	if err != nil {
+		replayFailed(err)
+	}
+	span.Finish()
 
-			// Do not match by default
-			return false
+	// Keep state for the next run
+	if *writeDbName != "" {
+		extracted, err := extractValues(db, api.Info.Title, requests, results)
+		if err != nil {
+			fatal("err: could not extract values →", err)
+		}
+		err = writeDb(ctx, *writeDbName, *dbName, extracted)
+		if err != nil {
+			die(exitOutput, "err: could not write db →", err)
 		}
+	}
 
-		exceptions, ok := record.Lookup("disallow")
-		if ok && match(exceptions) {
-			// We are an exception
-			continue recordSearch
+	// Optionally validate against spec
+	span = tracer.Start("validate", nil)
+	sus, ok, err := generator.Validate(ctx, requests, results)
+	if err != nil {
+		fatal("err: could not validate responses →", err)
+	}
+	tracer.verdicts(sus, ok)
+	span.Set("generator.suspicious", len(sus))
+	span.Set("generator.conformant", len(ok))
+	span.Finish()
+
+	for _, sink := range sinks {
+		err := sink.Results(requests, totalPossible, missing, sus, ok)
+		if err != nil {
+			die(exitOutput, "err: could not emit results →", err)
 		}
+	}
+
+	closeSinks()
 
-		constraints, ok := record.Lookup("permit")
-		if ok && !match(constraints) {
-			// We are not in scope
-			continue recordSearch
+	// Results land in the Tests tab of a pipeline
+	if *adoRun != "" {
+		a, err := adoFromEnv()
+		if err != nil {
+			die(exitOutput, "err: could not publish ADO test run →", err)
 		}
 
-		// Populate properties
-		if hasProperties {
-			if _, hasFuzz := properties["fuzz"]; hasFuzz {
-				fuzz = true
-			}
+		id, err := a.Publish(*adoRun, requests, missing, sus, ok)
+		if err != nil {
+			die(exitOutput, "err: could not publish ADO test run →", err)
 		}
+		chat(fmt.Sprintf("Published ADO test run %d\n", id))
+	}
 
-		// Search for enumerated values - ordered
-		values, ok := record.Lookup("values")
-		var vals []string
+	// Telemetry for dashboards
+	if *appInsights != "" {
+		err := exportAppInsights(*appInsights, sus, ok)
+		if err != nil {
+			die(exitOutput, "err: could not export to Application Insights →", err)
+		}
+	}
 
-		// Build table of enumerated values
-		if ok {
-			for _, tuple := range values {
-				attributes := tuple.Attributes
-				if len(attributes) > 1 {
-					for _, v := range attributes[1:] {
-						vals = append(vals, v.Name)
-					}
-				}
-			}
+	// Spans to correlate with the target's traces
+	if tracer != nil {
+		err := tracer.Export(*otlp)
+		if err != nil {
+			die(exitOutput, "err: could not export to OpenTelemetry →", err)
 		}
+	}
 
-		// Insert an enumerated value if any was supplied, short circuit
-		if len(vals) > 0 {
-			if fuzz {
-				// Select at random
-				index, err := rand.Int(rand.Reader, big.NewInt(int64(len(vals))))
-				if err != nil {
-					fatal("err: could not rng for value fuzz -", err)
-				}
+	// Results for search and dashboards across runs
+	if *elastic != "" {
+		err := exportElastic(*elastic, *elasticIndex, api.Info.Title, sus, ok)
+		if err != nil {
+			die(exitOutput, "err: could not export to Elasticsearch →", err)
+		}
+	}
 
-				// One, single, randomly selected, value
-				// TODO - just shuffle and append?
-				out = append(out, vals[int(index.Int64())])
-				continue recordSearch
-			}
+	// Results for historical queries
+	if *sqliteName != "" {
+		err := exportSQLite(*sqliteName, api.Info.Title, runStarted, requests, totalPossible, missing, sus, ok)
+		if err != nil {
+			die(exitOutput, "err: could not persist results to SQLite →", err)
+		}
+	}
 
-			// All values, in order
-			out = append(out, vals...)
-			continue recordSearch
+	// Tell the humans
+	if *notifyHook != "" {
+		link := *notifyLink
+		if link == "" {
+			link = runLink()
 		}
 
-		// Insert the primary value for this identifier
-		if !fuzz && len(primaryValue) > 0 {
-			out = append(out, primaryValue...)
-			continue recordSearch
+		err := notify(*notifyHook, link, requests, totalPossible, missing, sus, ok)
+		if err != nil {
+			die(exitOutput, "err: could not notify →", err)
 		}
+	}
 
-		// TODO - fuzzing?
+	// Findings fail the run
+	if !gate(thresholds, metrics(requests, totalPossible, missing, sus, ok)) {
+		stderr.Flush()
+		os.Exit(exitFindings)
 	}
+}
+
+// Flush and close all outputs
+func closeSinks() {
+	for _, sink := range sinks {
+		err := sink.Close()
+		if err != nil {
+			die(exitOutput, "err: could not write output →", err)
+		}
+	}
+}
 
-	r := nothing
-	if fuzz {
-		r = fuzzing
-	} else if len(out) > 0 {
-		r = something
+// End a run whose replay failed, as the target's fault unless it was our own hook or signer
+func replayFailed(err error) {
+	var hook *generator.HookError
+	var sign *generator.SignError
+	if errors.As(err, &hook) || errors.As(err, &sign) {
+		fatal("err:", err)
 	}
 
-	return out, r
+	die(exitUnreachable, "err: could not make request →", err)
+}
+
+// A context for the run, ended by an interrupt, SIGTERM, or -deadline
+// A second interrupt ends the program at once
+func runContext() (context.Context, context.CancelFunc) {
+	signalled, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signalled.Done()
+		stopSignals()
+	}()
+
+	ctx, cancel := flagOptions().withDeadline(signalled)
+	return ctx, func() {
+		cancel()
+		stopSignals()
+	}
+}
+
+// Convert []requests → []string
+// If print is true, each is logged too
+func requests2strings(requests []*Request, print bool) RequestStrings {
+	var reqStrings []string
+	for _, request := range requests {
+		reqStrings = append(reqStrings, prettyRequest(request.Request))
+
+		if print {
+			emit(prettyRequest(request.Request) + "\n\n")
+		}
+	}
+	return RequestStrings{reqStrings}
 }
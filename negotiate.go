@@ -0,0 +1,151 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Media types results may be asked for with Accept, and the format of each
+var mediaFormats = []struct{ mediaType, format string }{
+	{"application/json", "json"},
+	{"application/x-ndjson", "jsonl"},
+	{"application/xml", "junit"},
+	{"text/xml", "junit"},
+	{"text/plain", "summary"},
+}
+
+// Content type of each format -listen answers in
+var formatTypes = map[string]string{
+	"json":    "application/json",
+	"jsonl":   "application/x-ndjson",
+	"junit":   "application/xml",
+	"summary": "text/plain",
+	"ado":     "text/plain",
+	"gha":     "text/plain",
+}
+
+// Findings are what a job found, which may be answered in any format
+type Findings struct {
+	requests      []*Request
+	totalPossible uint64
+	missed        map[string]uint64
+	sus, ok       []Set
+	indent, full  bool
+	strict        bool
+}
+
+// Write findings in a format
+func (f *Findings) render(format string) (*Output, error) {
+	var buf bytes.Buffer
+	var err error
+
+	switch format {
+	case "jsonl":
+		// Results in the order they were built, then the run info
+		for _, set := range f.results() {
+			if err == nil {
+				err = printJSONL(&buf, f.full, set.Request, set.Response)
+			}
+		}
+		if err == nil {
+			err = printJSONLInfo(&buf, f.requests, f.totalPossible, f.missed)
+		}
+
+	case "ado":
+		printADO(&buf, f.requests, f.missed, f.sus, f.ok)
+
+	case "gha":
+		printGHA(&buf, nil, f.strict, f.requests, f.missed, f.sus, f.ok)
+
+	case "junit":
+		err = printJUnit(&buf, f.requests, f.sus, f.ok)
+
+	case "summary":
+		printSummary(&buf, false, f.requests, f.totalPossible, f.missed, f.sus, f.ok)
+
+	default:
+		format = "json"
+		err = printJSON(&buf, f.indent, f.full, f.requests, f.missed, f.sus, f.ok)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Output{ContentType: formatTypes[format], Body: buf.Bytes(), format: format, report: f}, nil
+}
+
+// The output in another format, if it has results to write again
+func (o *Output) as(format string) (*Output, error) {
+	if o.report == nil || o.format == format {
+		return o, nil
+	}
+
+	return o.report.render(format)
+}
+
+// The format results are answered in, as the options say, or else Accept
+func resultFormat(r *http.Request, opts JobOptions) (string, *apiError) {
+	if format := opts.format(); format != "" {
+		return format, nil
+	}
+
+	format, ok := negotiate(r.Header.Get("Accept"))
+	if !ok {
+		return "", &apiError{http.StatusNotAcceptable, "Error: results can't be given as any of → " + r.Header.Get("Accept") + ", accept application/json, application/x-ndjson, application/xml, or text/plain", false}
+	}
+
+	return format, nil
+}
+
+// The format an Accept header most prefers, JSON if it has no preference
+func negotiate(accept string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return "json", true
+	}
+
+	type choice struct {
+		mediaType string
+		q         float64
+	}
+	var choices []choice
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		c := choice{strings.ToLower(strings.TrimSpace(params[0])), 1}
+		for _, param := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) == 2 && strings.ToLower(kv[0]) == "q" {
+				q, err := strconv.ParseFloat(kv[1], 64)
+				if err == nil {
+					c.q = q
+				}
+			}
+		}
+		if c.q > 0 {
+			choices = append(choices, c)
+		}
+	}
+
+	// Most preferred first, ties as listed
+	sort.SliceStable(choices, func(i, j int) bool {
+		return choices[i].q > choices[j].q
+	})
+
+	for _, c := range choices {
+		if c.mediaType == "*/*" || c.mediaType == "application/*" {
+			return "json", true
+		}
+		for _, mf := range mediaFormats {
+			if c.mediaType == mf.mediaType || c.mediaType == strings.SplitN(mf.mediaType, "/", 2)[0]+"/*" {
+				return mf.format, true
+			}
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Most suspicious findings listed in a notification
+const notifyFindings = 5
+
+// Link to the pipeline run we're in, if we can tell
+func runLink() string {
+	// GitHub Actions
+	if server, repo, id := os.Getenv("GITHUB_SERVER_URL"), os.Getenv("GITHUB_REPOSITORY"), os.Getenv("GITHUB_RUN_ID"); server != "" && repo != "" && id != "" {
+		return server + "/" + repo + "/actions/runs/" + id
+	}
+
+	// Azure DevOps
+	if collection, project, id := os.Getenv("SYSTEM_COLLECTIONURI"), os.Getenv("SYSTEM_TEAMPROJECT"), os.Getenv("BUILD_BUILDID"); collection != "" && project != "" && id != "" {
+		return strings.TrimSuffix(collection, "/") + "/" + url.PathEscape(project) + "/_build/results?buildId=" + id
+	}
+
+	return ""
+}
+
+// Post a run summary to a Teams or Slack incoming webhook
+// Slack and Teams both take a "text" field, but differ in markup
+func notify(hook, link string, requests []*Request, totalPossible uint64, missed map[string]uint64, sus, ok []Set) error {
+	u, err := url.Parse(hook)
+	if err != nil {
+		return err
+	}
+	slack := strings.HasSuffix(u.Hostname(), "slack.com")
+
+	bold := func(s string) string {
+		if slack {
+			return "*" + s + "*"
+		}
+		return "**" + s + "**"
+	}
+	// Teams needs blank lines between lines
+	nl := "\n\n"
+	if slack {
+		nl = "\n"
+	}
+
+	server := ""
+	if len(requests) > 0 {
+		server = requests[0].Host
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s%s", bold("Generator results for "+server), nl)
+	fmt.Fprintf(&b, "Built %d/%d requests, %d suspicious, %d conformant, %d parameters missed%s", len(requests), totalPossible, len(sus), len(ok), len(missed), nl)
+
+	if len(sus) > 0 {
+		worst := append([]Set{}, sus...)
+		sort.Slice(worst, func(i, j int) bool {
+			if worst[i].Request.URL.Path == worst[j].Request.URL.Path {
+				return worst[i].Request.Request.Method < worst[j].Request.Request.Method
+			}
+			return worst[i].Request.URL.Path < worst[j].Request.URL.Path
+		})
+
+		fmt.Fprintf(&b, "%s%s", bold("Suspicious responses"), nl)
+		for i, bad := range worst {
+			if i >= notifyFindings {
+				fmt.Fprintf(&b, "… and %d more%s", len(worst)-notifyFindings, nl)
+				break
+			}
+			fmt.Fprintf(&b, "• `HTTP %d` for `%s %s`%s", bad.Response.StatusCode, strings.ToUpper(bad.Request.Request.Method), bad.Request.URL.Path, nl)
+		}
+	}
+
+	if link != "" {
+		if slack {
+			fmt.Fprintf(&b, "<%s|Full report>", link)
+		} else {
+			fmt.Fprintf(&b, "[Full report](%s)", link)
+		}
+	}
+
+	buf, err := json.Marshal(map[string]string{"text": redact(b.String())})
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(hook, "application/json", bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("webhook responded %s → %s", resp.Status, string(body))
+	}
+
+	return nil
+}
@@ -0,0 +1,403 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// OAuth acquires user-context tokens interactively from an OpenID Connect issuer
+type OAuth struct {
+	Issuer   string // Issuer URL, endpoints are discovered from it
+	ClientID string // Public client identifier
+	Scope    string // Space-separated scopes, include offline_access for refresh tokens
+
+	authorize string // Authorization endpoint
+	token     string // Token endpoint
+	device    string // Device authorization endpoint
+	client    *http.Client
+}
+
+// Token is a token endpoint response
+type Token struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	Error        string `json:"error"`
+	Description  string `json:"error_description"`
+}
+
+// Discover endpoints from the issuer's OpenID configuration
+func (o *OAuth) discover() error {
+	o.client = &http.Client{Timeout: 30 * time.Second}
+
+	resp, err := o.client.Get(strings.TrimSuffix(o.Issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return errors.New("OpenID discovery responded " + resp.Status)
+	}
+
+	var conf struct {
+		Authorize string `json:"authorization_endpoint"`
+		Token     string `json:"token_endpoint"`
+		Device    string `json:"device_authorization_endpoint"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&conf)
+	if err != nil {
+		return err
+	}
+
+	o.authorize, o.token, o.device = conf.Authorize, conf.Token, conf.Device
+	if o.token == "" {
+		return errors.New("issuer has no token endpoint")
+	}
+
+	return nil
+}
+
+// Post a form to the token endpoint
+func (o *OAuth) exchange(form url.Values) (Token, error) {
+	var t Token
+
+	form.Set("client_id", o.ClientID)
+	resp, err := o.client.PostForm(o.token, form)
+	if err != nil {
+		return t, err
+	}
+	defer resp.Body.Close()
+
+	err = json.NewDecoder(resp.Body).Decode(&t)
+	if err != nil {
+		return t, err
+	}
+
+	if t.Error == "" && t.AccessToken == "" {
+		t.Error = "no access token in response " + resp.Status
+	}
+
+	return t, nil
+}
+
+// Acquire a token with the given flow, "device" or "authcode"
+// A cached refresh token is used first if there is one
+func (o *OAuth) Acquire(flow string) (string, error) {
+	err := o.discover()
+	if err != nil {
+		return "", err
+	}
+
+	key := o.Issuer + " " + o.ClientID + " " + o.Scope
+	if refresh := loadRefreshToken(key); refresh != "" {
+		t, err := o.exchange(url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {refresh},
+			"scope":         {o.Scope},
+		})
+		if err == nil && t.Error == "" {
+			o.save(key, t)
+			return t.AccessToken, nil
+		}
+		chat("oauth: cached refresh token rejected, signing in again\n")
+	}
+
+	var t Token
+	switch flow {
+	case "device":
+		t, err = o.deviceCode()
+	case "authcode":
+		t, err = o.authCode()
+	default:
+		return "", errors.New("unknown OAuth flow → " + flow)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	o.save(key, t)
+	return t.AccessToken, nil
+}
+
+// Keep a refresh token for next time, if we got one
+func (o *OAuth) save(key string, t Token) {
+	if t.RefreshToken == "" {
+		return
+	}
+
+	err := storeRefreshToken(key, t.RefreshToken)
+	if err != nil {
+		warn("warn: could not store refresh token →", err)
+	}
+}
+
+// Device code flow - the user signs in on another device
+func (o *OAuth) deviceCode() (Token, error) {
+	var t Token
+	if o.device == "" {
+		return t, errors.New("issuer has no device authorization endpoint")
+	}
+
+	resp, err := o.client.PostForm(o.device, url.Values{
+		"client_id": {o.ClientID},
+		"scope":     {o.Scope},
+	})
+	if err != nil {
+		return t, err
+	}
+	defer resp.Body.Close()
+
+	var code struct {
+		DeviceCode string `json:"device_code"`
+		UserCode   string `json:"user_code"`
+		URI        string `json:"verification_uri"`
+		Interval   int    `json:"interval"`
+		ExpiresIn  int    `json:"expires_in"`
+		Message    string `json:"message"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&code)
+	if err != nil {
+		return t, err
+	}
+	if code.DeviceCode == "" {
+		return t, errors.New("device authorization responded " + resp.Status)
+	}
+
+	if code.Message != "" {
+		emit(code.Message)
+	} else {
+		emit("To sign in, visit " + code.URI + " and enter the code " + code.UserCode)
+	}
+
+	interval := time.Duration(code.Interval) * time.Second
+	if interval < time.Second {
+		interval = 5 * time.Second
+	}
+	// Without expires_in, the code's lifetime is unknown, so poll until the issuer refuses it
+	lifetime := time.Duration(code.ExpiresIn) * time.Second
+	if code.ExpiresIn <= 0 {
+		lifetime = 15 * time.Minute
+	}
+	deadline := time.Now().Add(lifetime)
+
+	// Poll until the user has signed in
+	for time.Now().Before(deadline) {
+		time.Sleep(interval)
+
+		t, err = o.exchange(url.Values{
+			"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+			"device_code": {code.DeviceCode},
+		})
+		if err != nil {
+			return t, err
+		}
+
+		switch t.Error {
+		case "":
+			return t, nil
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		default:
+			return t, errors.New(t.Error + ": " + t.Description)
+		}
+	}
+
+	return t, errors.New("device code expired before sign in")
+}
+
+// Authorization code flow with PKCE - the user signs in with a local browser
+func (o *OAuth) authCode() (Token, error) {
+	var t Token
+	if o.authorize == "" {
+		return t, errors.New("issuer has no authorization endpoint")
+	}
+
+	// Loopback redirect on any free port
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return t, err
+	}
+	defer l.Close()
+	redirect := "http://" + l.Addr().String() + "/"
+
+	verifier := randomURLSafe(32)
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+	state := randomURLSafe(16)
+
+	u := o.authorize + "?" + url.Values{
+		"client_id":             {o.ClientID},
+		"response_type":         {"code"},
+		"redirect_uri":          {redirect},
+		"scope":                 {o.Scope},
+		"state":                 {state},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+	}.Encode()
+	emit("To sign in, open this URL in a browser:\n\n" + u + "\n")
+
+	type Callback struct {
+		Code string
+		Err  error
+	}
+	done := make(chan Callback, 1)
+
+	// Only the first callback is waited for, later ones mustn't block the handler
+	finish := func(cb Callback) {
+		select {
+		case done <- cb:
+		default:
+		}
+	}
+
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch {
+		case q.Get("state") != state:
+			http.Error(w, "State mismatch", http.StatusBadRequest)
+			return
+		case q.Get("error") != "":
+			fmt.Fprintln(w, "Sign in failed, you may close this window.")
+			finish(Callback{Err: errors.New(q.Get("error") + ": " + q.Get("error_description"))})
+		default:
+			fmt.Fprintln(w, "Signed in, you may close this window.")
+			finish(Callback{Code: q.Get("code")})
+		}
+	})}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	var cb Callback
+	select {
+	case cb = <-done:
+	case <-time.After(5 * time.Minute):
+		return t, errors.New("timed out waiting for sign in")
+	}
+	if cb.Err != nil {
+		return t, cb.Err
+	}
+
+	t, err = o.exchange(url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {cb.Code},
+		"redirect_uri":  {redirect},
+		"code_verifier": {verifier},
+	})
+	if err != nil {
+		return t, err
+	}
+	if t.Error != "" {
+		return t, errors.New(t.Error + ": " + t.Description)
+	}
+
+	return t, nil
+}
+
+// Random base64url string from n bytes
+func randomURLSafe(n int) string {
+	buf := make([]byte, n)
+	rand.Read(buf)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// Refresh tokens without a keychain live in a file only the user may read
+func tokenCacheName() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "generator", "tokens.json"), nil
+}
+
+// Read the token cache
+func readTokenCache() map[string]string {
+	cache := make(map[string]string)
+
+	name, err := tokenCacheName()
+	if err != nil {
+		return cache
+	}
+
+	buf, err := ioutil.ReadFile(name)
+	if err != nil {
+		return cache
+	}
+	json.Unmarshal(buf, &cache)
+
+	return cache
+}
+
+// Load a cached refresh token, if any, from the keychain or else the file
+func loadRefreshToken(key string) string {
+	token, err := keychainLoad(key)
+	if err == nil && token != "" {
+		return token
+	}
+
+	return readTokenCache()[key]
+}
+
+// Store a refresh token in the keychain, or in the file if there's no keychain to be had
+func storeRefreshToken(key, token string) error {
+	err := keychainStore(key, token)
+	if err == nil {
+		// Drop any copy the file kept from before
+		if _, ok := readTokenCache()[key]; ok {
+			return writeTokenCache(key, "")
+		}
+		return nil
+	}
+	warn("warn: no keychain, caching refresh token in a file →", err)
+
+	return writeTokenCache(key, token)
+}
+
+// Set a token in the cache file, an empty token removing it
+func writeTokenCache(key, token string) error {
+	name, err := tokenCacheName()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(name), 0700)
+	if err != nil {
+		return err
+	}
+
+	cache := readTokenCache()
+	cache[key] = token
+	if token == "" {
+		delete(cache, key)
+	}
+
+	buf, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+
+	// Write privately, then move into place
+	tmp := name + ".tmp"
+	err = ioutil.WriteFile(tmp, buf, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, name)
+}
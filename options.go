@@ -0,0 +1,308 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	mrand "math/rand"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/seh-msft/generator/pkg/generator"
+	"github.com/seh-msft/openapi"
+)
+
+// Options shape how requests are built and replayed, whether asked for by flags or by a caller of -listen
+// Each is the flag of the same name, and the server's option of its JSON name
+type Options struct {
+	generator.Options // strict, allbodies, proto, sequence, and cartesian
+
+	Target        string   `json:"target"`        // Server to replay against, instead of the spec's
+	IgnoreMethods []string `json:"ignoremethods"` // HTTP methods to not build
+	Methods       []string `json:"methods"`       // HTTP methods to build, otherwise all
+	Paths         []string `json:"paths"`         // Path templates to build, regular expressions or globs, otherwise all
+	Limit         int      `json:"limit"`         // Most requests to build, 0 for no limit
+	Sample        string   `json:"sample"`        // Which requests a limit keeps: first or random
+	Shuffle       *int64   `json:"shuffle"`       // Seed to shuffle the order requests are replayed in with, otherwise as built
+	NoAuth        bool     `json:"noauth"`        // Strip credentials
+	NoReplay      bool     `json:"noreplay"`      // Only build requests
+	PrintReqs     bool     `json:"printreqs"`     // Log built requests
+	Full          bool     `json:"full"`          // Complete requests and responses in JSON results
+	Indent        bool     `json:"indent"`        // Indent JSON results
+	Format        string   `json:"format"`        // Format of results, as per -format
+
+	Concurrency int     `json:"concurrency"` // Requests in flight at once
+	Timeout     string  `json:"timeout"`     // Longest a request may take, such as "30s"
+	Rate        float64 `json:"rate"`        // Requests started per second
+	BodyLimit   int     `json:"bodylimit"`   // Bytes of each response body kept
+	Deadline    string  `json:"deadline"`    // Longest the run may take, such as "10m"
+}
+
+// Options as per flags
+func flagOptions() Options {
+	o := serverDefaults()
+	o.EnvFallback = *envFallback
+	o.Target, o.NoAuth, o.NoReplay = *target, *noAuth, *noReplay
+	o.PrintReqs, o.Full, o.Indent, o.Format = *printReqs, *full, *indent, *format
+	o.Limit, o.Sample = *limit, *sample
+	if shuffle.On {
+		seed := shuffle.Seed
+		o.Shuffle = &seed
+	}
+	if *ignoreMethods != "" {
+		o.IgnoreMethods = strings.Split(*ignoreMethods, ",")
+	}
+	if *methods != "" {
+		o.Methods = strings.Split(*methods, ",")
+	}
+	if *paths != "" {
+		o.Paths = strings.Split(*paths, ",")
+	}
+
+	return o
+}
+
+// Options the listener's flags give its callers, which say how, but not what, to generate
+func serverDefaults() Options {
+	o := Options{
+		Options: generator.Options{
+			Strict:    *strict,
+			AllBodies: *allBodies,
+			Proto:     *proto,
+			Sequence:  *sequence,
+			Cartesian: *cartesian,
+			Log:       func(s string) { trace(strings.TrimSuffix(s, "\n")) },
+		},
+		Concurrency: *concurrency,
+		Rate:        *replayRate,
+		BodyLimit:   *bodyLimit,
+	}
+	if *reqTimeout > 0 {
+		o.Timeout = reqTimeout.String()
+	}
+	if *deadline > 0 {
+		o.Deadline = deadline.String()
+	}
+
+	return o
+}
+
+// Hold a caller's options to the listener's limits, which callers may tighten but not loosen
+func (o *Options) bound() error {
+	if *deadline > 0 {
+		d, _ := time.ParseDuration(o.Deadline)
+		if d <= 0 {
+			return errors.New("deadline must be set, the listener allows at most " + deadline.String())
+		}
+		if d > *deadline {
+			o.Deadline = deadline.String()
+		}
+	}
+
+	// No faster, nor more at once, than the listener replays
+	if *replayRate > 0 && (o.Rate <= 0 || o.Rate > *replayRate) {
+		o.Rate = *replayRate
+	}
+	if o.Concurrency > *concurrency {
+		o.Concurrency = *concurrency
+	}
+
+	return nil
+}
+
+// Check options are within reason
+func (o Options) check() error {
+	if o.Proto != "http" && o.Proto != "https" {
+		return errors.New("proto must be http or https")
+	}
+	if o.Cartesian < 0 {
+		return errors.New("cartesian must not be negative")
+	}
+
+	if o.Deadline != "" {
+		d, err := time.ParseDuration(o.Deadline)
+		if err != nil {
+			return err
+		}
+		if d < 0 {
+			return errors.New("deadline must not be negative")
+		}
+	}
+
+	if o.Limit < 0 {
+		return errors.New("limit must not be negative")
+	}
+	switch o.Sample {
+	case "", "first", "random":
+	default:
+		return errors.New("sample must be first or random")
+	}
+
+	for _, pattern := range o.Paths {
+		_, err := pathPattern(pattern)
+		if err != nil {
+			return errors.New("bad path pattern → " + err.Error())
+		}
+	}
+
+	_, err := o.pacing()
+	return err
+}
+
+// Narrow an api to the paths and methods the options ask for
+func (o Options) narrow(api *openapi.API) {
+	for _, method := range o.IgnoreMethods {
+		for _, methods := range api.Paths {
+			delete(methods, strings.ToLower(method))
+		}
+	}
+
+	if len(o.Methods) > 0 {
+		allowed := make(map[string]bool)
+		for _, method := range o.Methods {
+			allowed[strings.ToLower(strings.TrimSpace(method))] = true
+		}
+		for _, methods := range api.Paths {
+			for method := range methods {
+				if !allowed[method] {
+					delete(methods, method)
+				}
+			}
+		}
+	}
+
+	if len(o.Paths) < 1 {
+		return
+	}
+	var patterns []*regexp.Regexp
+	for _, pattern := range o.Paths {
+		re, _ := pathPattern(pattern)
+		patterns = append(patterns, re)
+	}
+
+paths:
+	for path := range api.Paths {
+		for _, re := range patterns {
+			if re.MatchString(path) {
+				continue paths
+			}
+		}
+		delete(api.Paths, path)
+	}
+}
+
+// Keep at most the options' limit of requests, the first built or a random sample, in the order they were built
+func (o Options) sample(requests []*Request) []*Request {
+	if o.Limit < 1 || len(requests) <= o.Limit {
+		return requests
+	}
+	if o.Sample != "random" {
+		return requests[:o.Limit]
+	}
+
+	r := mrand.New(mrand.NewSource(time.Now().UnixNano()))
+	picked := r.Perm(len(requests))[:o.Limit]
+	sort.Ints(picked)
+
+	out := make([]*Request, 0, o.Limit)
+	for _, i := range picked {
+		out = append(out, requests[i])
+	}
+
+	return out
+}
+
+// Requests in the order the options' seed shuffles them to, if any, the same for the same seed
+func (o Options) shuffle(requests []*Request) []*Request {
+	if o.Shuffle == nil {
+		return requests
+	}
+
+	out := append([]*Request{}, requests...)
+	r := mrand.New(mrand.NewSource(*o.Shuffle))
+	r.Shuffle(len(out), func(i, j int) {
+		out[i], out[j] = out[j], out[i]
+	})
+
+	return out
+}
+
+// ShuffleFlag is -shuffle, alone for a seed of its own, or -shuffle=seed to replay an order again
+type ShuffleFlag struct {
+	On   bool
+	Seed int64
+}
+
+func (s *ShuffleFlag) String() string {
+	if s == nil || !s.On {
+		return ""
+	}
+
+	return strconv.FormatInt(s.Seed, 10)
+}
+
+func (s *ShuffleFlag) Set(v string) error {
+	switch v {
+	case "true":
+		s.On, s.Seed = true, time.Now().UnixNano()
+		return nil
+	case "false":
+		s.On = false
+		return nil
+	}
+
+	seed, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return errors.New("seed must be a whole number")
+	}
+	s.On, s.Seed = true, seed
+
+	return nil
+}
+
+// Given alone, -shuffle picks a seed
+func (s *ShuffleFlag) IsBoolFlag() bool {
+	return true
+}
+
+// A pattern for whole path templates, such as "/users/.*" or "/orders/*"
+// Patterns with regular expression syntax are regular expressions, others are globs whose * and ? stay within a segment
+func pathPattern(pattern string) (*regexp.Regexp, error) {
+	pattern = strings.TrimSpace(pattern)
+	if !strings.ContainsAny(pattern, `^$()|[]+\`) && !strings.Contains(pattern, ".*") {
+		pattern = regexp.QuoteMeta(pattern)
+		pattern = strings.ReplaceAll(pattern, `\*`, `[^/]*`)
+		pattern = strings.ReplaceAll(pattern, `\?`, `[^/]`)
+	}
+
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
+
+// A context which ends at the options' deadline, if any
+func (o Options) withDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	d, _ := time.ParseDuration(o.Deadline)
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, d)
+}
+
+// Pacing for replaying requests
+func (o Options) pacing() (generator.Pacing, error) {
+	p := generator.Pacing{Concurrency: o.Concurrency, Rate: o.Rate, BodyLimit: o.BodyLimit, Proto: o.Proto}
+	if o.Timeout != "" {
+		timeout, err := time.ParseDuration(o.Timeout)
+		if err != nil {
+			return p, err
+		}
+		p.Timeout = timeout
+	}
+
+	return p, p.Check()
+}
@@ -0,0 +1,331 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/seh-msft/generator/pkg/generator"
+)
+
+// Most spans sent per call
+const otlpBatch = 1000
+
+// Kinds of span, as per OTLP
+const (
+	spanInternal = 1
+	spanClient   = 3
+)
+
+// Tracer keeps a run's spans, as per -otlp, to export once the run is over
+// The run is the trace, its ID the run's, so traces line up with -appinsights operations
+// A nil Tracer, and the nil Spans it starts, trace nothing
+type Tracer struct {
+	mu    sync.Mutex
+	root  *Span
+	spans []*Span
+	byID  map[string]*Span
+}
+
+// Span is a timed step of a run
+type Span struct {
+	ID         string
+	Parent     string
+	Name       string
+	Kind       int
+	Start, End time.Time
+	Attributes map[string]interface{}
+	Error      string // Why the step failed, if it did
+}
+
+// A tracer for a run, started now, if there's an endpoint to export to
+func newTracer(endpoint string) *Tracer {
+	if endpoint == "" {
+		return nil
+	}
+
+	t := &Tracer{byID: make(map[string]*Span)}
+	t.root = t.Start("generator", nil)
+
+	return t
+}
+
+// Start a step of the run under a parent, the run itself if nil
+func (t *Tracer) Start(name string, parent *Span) *Span {
+	return t.startID(randomID(8), name, parent)
+}
+
+// Start a step of the run with a span ID of its own, such as a request's correlation id
+func (t *Tracer) startID(id, name string, parent *Span) *Span {
+	if t == nil {
+		return nil
+	}
+
+	s := &Span{ID: id, Name: name, Kind: spanInternal, Start: time.Now(), Attributes: make(map[string]interface{})}
+	if parent == nil {
+		parent = t.root
+	}
+	if parent != nil {
+		s.Parent = parent.ID
+	}
+
+	t.mu.Lock()
+	t.spans = append(t.spans, s)
+	t.byID[s.ID] = s
+	t.mu.Unlock()
+
+	return s
+}
+
+// The span a replayed request's traceparent header names, if any
+func (t *Tracer) find(traceparent string) *Span {
+	if t == nil {
+		return nil
+	}
+
+	id := traceSpan(traceparent)
+	if id == "" {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.byID[id]
+}
+
+// A hook giving each replayed request a span under parent, and a traceparent header naming it for the target to carry on
+func (t *Tracer) hook(parent *Span) generator.Hook {
+	return generator.HookFuncs{
+		BeforeFunc: func(req *http.Request) error {
+			// The span is the request's correlation id, unless a retry has taken it
+			id := traceSpan(req.Header.Get("traceparent"))
+			if id == "" || t.find(req.Header.Get("traceparent")) != nil {
+				id = randomID(8)
+			}
+
+			s := t.startID(id, req.Method+" "+req.URL.Path, parent)
+			s.Kind = spanClient
+			s.Set("http.request.method", req.Method)
+			s.Set("url.full", redact(req.URL.String()))
+			s.Set("server.address", req.URL.Hostname())
+
+			req.Header.Set("traceparent", "00-"+runID+"-"+s.ID+"-01")
+			return nil
+		},
+		AfterFunc: func(req *http.Request, resp *generator.Response) error {
+			s := t.find(req.Header.Get("traceparent"))
+			s.Set("http.response.status_code", resp.StatusCode)
+			s.Finish()
+			return nil
+		},
+	}
+}
+
+// Name each request's span for its operation and record how it was judged
+func (t *Tracer) verdicts(sus, ok []Set) {
+	mark := func(set Set, verdict string) {
+		s := t.find(set.Request.Header.Get("traceparent"))
+		if s == nil {
+			return
+		}
+
+		s.Name = strings.ToUpper(set.Request.Request.Method) + " " + set.Request.Path
+		s.Set("generator.operation_id", set.Request.Method.OperationID)
+		s.Set("generator.verdict", verdict)
+		if set.Request.Variant != "" {
+			s.Set("generator.variant", set.Request.Variant)
+		}
+		if verdict == "suspicious" {
+			s.Error = "suspicious response"
+		}
+	}
+
+	// A request may be conformant for one expected code and suspicious for another, suspicious wins
+	for _, set := range ok {
+		mark(set, "conformant")
+	}
+	for _, set := range sus {
+		mark(set, "suspicious")
+	}
+}
+
+// Set an attribute of a step, a string, bool, or integer
+func (s *Span) Set(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+
+	s.Attributes[key] = value
+}
+
+// Finish a step, now
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+
+	s.End = time.Now()
+}
+
+// A span as per OTLP/JSON
+func (s *Span) otlp() map[string]interface{} {
+	end := s.End
+	if end.IsZero() {
+		// Requests which never got a response
+		end = s.Start
+		if s.Error == "" {
+			s.Error = "no response"
+		}
+	}
+
+	var keys []string
+	for key := range s.Attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	attributes := []map[string]interface{}{}
+	for _, key := range keys {
+		attributes = append(attributes, otlpAttribute(key, s.Attributes[key]))
+	}
+
+	status := map[string]interface{}{"code": 1}
+	if s.Error != "" {
+		status = map[string]interface{}{"code": 2, "message": s.Error}
+	}
+
+	out := map[string]interface{}{
+		"traceId":           runID,
+		"spanId":            s.ID,
+		"name":              s.Name,
+		"kind":              s.Kind,
+		"startTimeUnixNano": strconv.FormatInt(s.Start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(end.UnixNano(), 10),
+		"attributes":        attributes,
+		"status":            status,
+	}
+	if s.Parent != "" {
+		out["parentSpanId"] = s.Parent
+	}
+
+	return out
+}
+
+// An attribute as per OTLP/JSON, whose integers are strings
+func otlpAttribute(key string, value interface{}) map[string]interface{} {
+	var v map[string]interface{}
+	switch value := value.(type) {
+	case bool:
+		v = map[string]interface{}{"boolValue": value}
+	case int:
+		v = map[string]interface{}{"intValue": strconv.Itoa(value)}
+	case uint64:
+		v = map[string]interface{}{"intValue": strconv.FormatUint(value, 10)}
+	default:
+		v = map[string]interface{}{"stringValue": fmt.Sprint(value)}
+	}
+
+	return map[string]interface{}{"key": key, "value": v}
+}
+
+// Headers to export with, as per OTEL_EXPORTER_OTLP_HEADERS, such as "api-key=abc,team=def"
+func otlpHeaders() http.Header {
+	h := make(http.Header)
+	for _, pair := range strings.Split(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"), ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		value, err := url.QueryUnescape(strings.TrimSpace(kv[1]))
+		if err != nil {
+			value = strings.TrimSpace(kv[1])
+		}
+		h.Set(strings.TrimSpace(kv[0]), value)
+	}
+
+	return h
+}
+
+// Finish the run and export its spans to an OTLP/HTTP collector, such as http://localhost:4318
+func (t *Tracer) Export(endpoint string) error {
+	if t == nil {
+		return nil
+	}
+	t.root.Finish()
+
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	if !strings.HasSuffix(endpoint, "/v1/traces") {
+		endpoint += "/v1/traces"
+	}
+	headers := otlpHeaders()
+
+	resource := map[string]interface{}{
+		"attributes": []map[string]interface{}{
+			otlpAttribute("service.name", "generator"),
+		},
+	}
+
+	spans := t.spans
+	client := &http.Client{Timeout: 30 * time.Second}
+	for len(spans) > 0 {
+		n := len(spans)
+		if n > otlpBatch {
+			n = otlpBatch
+		}
+
+		var batch []map[string]interface{}
+		for _, s := range spans[:n] {
+			batch = append(batch, s.otlp())
+		}
+		spans = spans[n:]
+
+		buf, err := json.Marshal(map[string]interface{}{
+			"resourceSpans": []map[string]interface{}{{
+				"resource": resource,
+				"scopeSpans": []map[string]interface{}{{
+					"scope": map[string]interface{}{"name": "generator"},
+					"spans": batch,
+				}},
+			}},
+		})
+		if err != nil {
+			return err
+		}
+
+		req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(buf))
+		if err != nil {
+			return err
+		}
+		for name, values := range headers {
+			req.Header[name] = values
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("collector responded %s → %s", resp.Status, string(body))
+		}
+	}
+
+	return nil
+}
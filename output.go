@@ -0,0 +1,266 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// Sink is an output destination and the format written to it
+type Sink struct {
+	Format   string             // Output format, as per -format
+	Name     string             // File name, "-" for standard output
+	Template *template.Template // Template to execute, for the "template" format
+
+	w    *bufio.Writer
+	f    *os.File
+	temp string // Written to, then renamed to Name once whole
+}
+
+// Sinks is a set of outputs, as per repeated `-output format=file` flags
+type Sinks []*Sink
+
+func (s *Sinks) String() string {
+	var out []string
+	for _, sink := range *s {
+		out = append(out, sink.Format+"="+sink.Name)
+	}
+
+	return strings.Join(out, ",")
+}
+
+// Set adds a sink in the form `format=file` or `format` for standard output
+func (s *Sinks) Set(v string) error {
+	sink := &Sink{Format: v, Name: "-"}
+	if i := strings.Index(v, "="); i >= 0 {
+		sink.Format = v[:i]
+		sink.Name = v[i+1:]
+	}
+
+	err := checkFormat(sink.Format)
+	if err != nil {
+		return err
+	}
+
+	*s = append(*s, sink)
+	return nil
+}
+
+// Formats implied by the extension of -o
+var extFormats = map[string]string{".json": "json", ".jsonl": "jsonl", ".har": "har", ".xml": "junit", ".md": "markdown"}
+
+// The format a file name implies, "" for none
+func formatFor(name string) string {
+	return extFormats[strings.ToLower(filepath.Ext(name))]
+}
+
+// Add -o to the outputs, which are -format to standard output without it or -output
+func addOutName() {
+	if *outName != "-" || len(sinks) < 1 {
+		sinks = append(sinks, &Sink{Format: *format, Name: *outName})
+	}
+}
+
+// Check that an output format is known
+func checkFormat(format string) error {
+	switch format {
+	case "json", "jsonl", "ado", "gha", "junit", "summary", "markdown", "har", "template":
+		return nil
+	}
+
+	return errors.New("unknown output format → " + format)
+}
+
+// Check that an output format can show built requests, as -noreplay emits, rather than results
+func checkRequestsFormat(format string) error {
+	switch format {
+	case "json", "jsonl":
+		return nil
+	}
+
+	return errors.New("-noreplay emits built requests, which are json or jsonl, not " + format)
+}
+
+// Open a sink for writing, standard output is shared
+func (s *Sink) Open(stdout *bufio.Writer) error {
+	if s.Format == "template" && s.Template == nil {
+		if *tmplName == "" {
+			return errors.New("template format requires -template")
+		}
+
+		t, err := loadTemplate(*tmplName)
+		if err != nil {
+			return err
+		}
+		s.Template = t
+	}
+
+	if s.Name == "-" || s.Name == "" {
+		s.w = stdout
+		return nil
+	}
+
+	// JSON Lines are written in place, so partial results survive crashes, as are devices such as /dev/stderr
+	// Other formats are written beside the file and renamed over it once whole, so an interrupted run doesn't leave one cut short
+	info, err := os.Stat(s.Name)
+	if s.Format == "jsonl" || (err == nil && !info.Mode().IsRegular()) {
+		f, err := os.Create(s.Name)
+		if err != nil {
+			return err
+		}
+		s.f = f
+		s.w = bufio.NewWriter(f)
+		return nil
+	}
+
+	mode := os.FileMode(0644)
+	if err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(s.Name), "."+filepath.Base(s.Name)+".*")
+	if err != nil {
+		return err
+	}
+	err = f.Chmod(mode)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	s.f, s.temp = f, f.Name()
+	s.w = bufio.NewWriter(f)
+
+	return nil
+}
+
+// Close a sink, flushing any pending output
+func (s *Sink) Close() error {
+	err := s.w.Flush()
+	if s.f == nil {
+		return err
+	}
+
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	if s.temp == "" {
+		return err
+	}
+
+	if err == nil {
+		err = os.Rename(s.temp, s.Name)
+	}
+	if err != nil {
+		os.Remove(s.temp)
+	}
+	s.temp = ""
+
+	return err
+}
+
+// Remove what was written of a sink which won't be whole, leaving any earlier file be
+func (s *Sink) abandon() {
+	if s.temp == "" {
+		return
+	}
+
+	s.f.Close()
+	os.Remove(s.temp)
+	s.temp = ""
+}
+
+// Terminal is true if the sink is standard output on a terminal
+func (s *Sink) Terminal() bool {
+	return s.f == nil && isTerminal(os.Stdout)
+}
+
+// Requests emits built requests when we don't replay
+func (s *Sink) Requests(requests []*Request) error {
+	strs := requests2strings(requests, *printReqs)
+
+	if s.Format == "jsonl" {
+		// One request string per line
+		enc := json.NewEncoder(s.w)
+		for _, request := range strs.Requests {
+			err := enc.Encode(request)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return newEncoder(s.w, *indent).Encode(strs)
+}
+
+// Stream emits a single result as soon as it completes, for streaming formats
+func (s *Sink) Stream(request *Request, response *Response) error {
+	if s.Format != "jsonl" {
+		return nil
+	}
+
+	err := printJSONL(s.w, *full, request, response)
+	if err != nil {
+		return err
+	}
+
+	// Partial results should survive crashes
+	return s.w.Flush()
+}
+
+// Results emits the results of a run
+func (s *Sink) Results(requests []*Request, totalPossible uint64, missed map[string]uint64, sus, ok []Set) error {
+	switch s.Format {
+	case "jsonl":
+		// Results were streamed, the run info goes last
+		return printJSONLInfo(s.w, requests, totalPossible, missed)
+
+	case "ado":
+		printADO(s.w, requests, missed, sus, ok)
+
+	case "gha":
+		// Write a job summary if we're in a workflow
+		var summary io.Writer
+		if name := os.Getenv("GITHUB_STEP_SUMMARY"); name != "" {
+			f, err := os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			summary = f
+		}
+
+		printGHA(s.w, summary, *strict, requests, missed, sus, ok)
+
+	case "junit":
+		return printJUnit(s.w, requests, sus, ok)
+
+	case "har":
+		return printHAR(s.w, *indent, requests, sus, ok)
+
+	case "markdown":
+		printMarkdown(s.w, requests, missed, sus, ok)
+
+	case "summary":
+		// Colored on a terminal
+		printSummary(s.w, s.Terminal(), requests, totalPossible, missed, sus, ok)
+
+	case "template":
+		return printTemplate(s.w, s.Template, requests, totalPossible, missed, sus, ok)
+
+	default:
+		return printJSON(s.w, *indent, *full, requests, missed, sus, ok)
+	}
+
+	return nil
+}
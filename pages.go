@@ -0,0 +1,132 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Results in a page unless a caller asks for fewer, and the most they may ask for
+const (
+	defaultPage = 100
+	maxPage     = 1000
+)
+
+// ResultSummary is what a finished job found, without its results, as per GET /jobs/{id}/summary
+type ResultSummary struct {
+	Built      int               `json:"built"`      // Requests built
+	Possible   uint64            `json:"possible"`   // Requests which could have been built
+	Suspicious int               `json:"suspicious"` // Responses the spec didn't expect
+	Conformant int               `json:"conformant"` // Responses it did
+	Missed     map[string]uint64 `json:"missed"`     // Parameters without values, and how often
+
+	Results  string `json:"results"`  // First page of results
+	Download string `json:"download"` // All of the results, as a file
+}
+
+// ResultPage is some of a finished job's results, in the order their requests were built, as per GET /jobs/{id}/results
+type ResultPage struct {
+	Results []Entry `json:"results"`
+	Offset  int     `json:"offset"`
+	Limit   int     `json:"limit"`
+	Total   int     `json:"total"`
+	Next    string  `json:"next,omitempty"` // Following page, if any
+}
+
+// File extension of each format, for downloads
+var formatExtensions = map[string]string{
+	"json":    "json",
+	"jsonl":   "jsonl",
+	"junit":   "xml",
+	"summary": "txt",
+	"ado":     "txt",
+	"gha":     "txt",
+}
+
+// Results with responses, in the order their requests were built
+func (f *Findings) results() []Set {
+	responses := make(map[*Request]*Response)
+	for _, set := range append(append([]Set{}, f.sus...), f.ok...) {
+		responses[set.Request] = set.Response
+	}
+
+	var sets []Set
+	for _, request := range f.requests {
+		if response, ok := responses[request]; ok {
+			sets = append(sets, Set{Request: request, Response: response})
+		}
+	}
+
+	return sets
+}
+
+// Summarize findings, linking to the results of a job at base, such as /v1/jobs/{id}
+func (f *Findings) summary(base string) ResultSummary {
+	return ResultSummary{
+		Built:      len(f.requests),
+		Possible:   f.totalPossible,
+		Suspicious: len(f.sus),
+		Conformant: len(f.ok),
+		Missed:     f.missed,
+		Results:    pageLink(base, 0, defaultPage),
+		Download:   base + "/result?download=true",
+	}
+}
+
+// A page of results, linking to the next from base
+func (f *Findings) page(base string, offset, limit int) (ResultPage, error) {
+	sets := f.results()
+	p := ResultPage{Results: []Entry{}, Offset: offset, Limit: limit, Total: len(sets)}
+
+	end := offset + limit
+	if end > len(sets) {
+		end = len(sets)
+	}
+	for i := offset; i < end; i++ {
+		entry, err := newEntry(f.full, sets[i].Request, sets[i].Response)
+		if err != nil {
+			return p, err
+		}
+		p.Results = append(p.Results, entry)
+	}
+
+	if end < len(sets) {
+		p.Next = pageLink(base, end, limit)
+	}
+
+	return p, nil
+}
+
+// Link to a page of results
+func pageLink(base string, offset, limit int) string {
+	q := url.Values{}
+	q.Set("offset", strconv.Itoa(offset))
+	q.Set("limit", strconv.Itoa(limit))
+	return base + "/results?" + q.Encode()
+}
+
+// The offset and limit a caller asked for
+func pageBounds(r *http.Request) (int, int, *apiError) {
+	offset, limit := 0, defaultPage
+	q := r.URL.Query()
+
+	var err error
+	if s := q.Get("offset"); s != "" {
+		offset, err = strconv.Atoi(s)
+		if err != nil || offset < 0 {
+			return 0, 0, &apiError{http.StatusBadRequest, "Error: offset must be a whole number → " + s, false}
+		}
+	}
+	if s := q.Get("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > maxPage {
+			return 0, 0, &apiError{http.StatusBadRequest, fmt.Sprint("Error: limit must be between 1 and ", maxPage, " → ", s), false}
+		}
+	}
+
+	return offset, limit, nil
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/seh-msft/generator/pkg/generator"
+	"github.com/seh-msft/openapi"
+)
+
+// ParsedSpec is what a run takes from its spec, as kept in -speccache
+type ParsedSpec struct {
+	API      openapi.API
+	Security Security
+	Formats  generator.Formats
+}
+
+// Parse a spec, as a run does before building
+func parseSpec(spec []byte) (*ParsedSpec, error) {
+	api, err := openapi.Parse(bytes.NewReader(spec))
+	if err != nil {
+		return nil, err
+	}
+
+	security, err := parseSecurity(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ParsedSpec{API: api, Security: security, Formats: generator.SpecFormats(spec)}, nil
+}
+
+// The file a spec's parsed form is kept in under dir
+// Specs are named by their contents and the version of generator, as another may parse them differently
+func specCacheName(dir string, spec []byte) string {
+	h := sha256.New()
+	h.Write([]byte(generatorVersion() + "\n"))
+	h.Write(spec)
+
+	return filepath.Join(dir, hex.EncodeToString(h.Sum(nil))+".gob")
+}
+
+// The parsed form of a spec kept in dir, nil if there isn't one
+func readSpecCache(dir string, spec []byte) *ParsedSpec {
+	if dir == "" {
+		return nil
+	}
+
+	f, err := os.Open(specCacheName(dir, spec))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var parsed ParsedSpec
+	if gob.NewDecoder(f).Decode(&parsed) != nil {
+		return nil
+	}
+	if parsed.Security.Operations == nil {
+		parsed.Security.Operations = make(map[string]*[]SecurityRequirement)
+	}
+	if parsed.Formats == nil {
+		parsed.Formats = make(generator.Formats)
+	}
+
+	return &parsed
+}
+
+// Keep the parsed form of a spec in dir, for the next run against it
+func writeSpecCache(dir string, spec []byte, parsed *ParsedSpec) error {
+	if dir == "" {
+		return nil
+	}
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	err = gob.NewEncoder(&buf).Encode(parsed)
+	if err != nil {
+		return err
+	}
+
+	// Write aside, then move into place, so runs sharing dir never read half an entry
+	tmp, err := ioutil.TempFile(dir, ".spec-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(buf.Bytes())
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), specCacheName(dir, spec))
+}
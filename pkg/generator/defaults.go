@@ -0,0 +1,123 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package generator
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/seh-msft/cfg"
+)
+
+// Formats are parameter formats from a spec, by "method path name"
+type Formats map[string]string
+
+// Is a record the defaults record, such as:
+//
+//	defaults
+//		format=date-time value=2024-01-01T00:00:00Z
+//		type=integer value=1
+//		type=string format=uuid value=@faker:uuid
+//
+// Each tuple is a value for parameters of a type, format, or both
+func IsDefaults(record *cfg.Record) bool {
+	return len(record.Tuples) > 0 && len(record.Tuples[0].Attributes) > 0 && record.Tuples[0].Attributes[0].Name == "defaults"
+}
+
+// The default value for parameters of a type and format, the first matching tuple wins
+func DefaultValue(c cfg.Cfg, kind, format string) (string, bool, error) {
+	value, ok := defaultRaw(c, kind, format)
+	if !ok {
+		return "", false, nil
+	}
+
+	values, err := Synthesize([]string{value})
+	if err != nil {
+		return "", false, &DbError{"defaults", err}
+	}
+	return values[0], true, nil
+}
+
+// The default value for parameters of a type and format as written in the db, before it's synthesized
+func defaultRaw(c cfg.Cfg, kind, format string) (string, bool) {
+	for _, record := range c.Records {
+		if !IsDefaults(record) {
+			continue
+		}
+
+	tuples:
+		for _, tuple := range record.Tuples[1:] {
+			value, scoped, found := "", false, false
+			for _, attr := range tuple.Attributes {
+				switch attr.Name {
+				case "type":
+					if !strings.EqualFold(attr.Value, kind) {
+						continue tuples
+					}
+					scoped = true
+				case "format":
+					if !strings.EqualFold(attr.Value, format) {
+						continue tuples
+					}
+					scoped = true
+				case "value":
+					value, found = attr.Value, true
+				}
+			}
+
+			if scoped && found {
+				return value, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// The format of a parameter, if the spec gives one
+func (f Formats) Of(method, path, name string) string {
+	return f[strings.ToLower(method)+" "+path+" "+name]
+}
+
+// Parameter formats from a spec
+// The openapi package doesn't keep them
+func SpecFormats(spec []byte) Formats {
+	formats := make(Formats)
+
+	var doc struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if json.Unmarshal(spec, &doc) != nil {
+		return formats
+	}
+
+	for path, methods := range doc.Paths {
+		formats.Add(path, methods)
+	}
+
+	return formats
+}
+
+// Add the parameter formats of a path item's operations, for specs read a path item at a time
+func (f Formats) Add(path string, methods map[string]json.RawMessage) {
+	for method, raw := range methods {
+		var op struct {
+			Parameters []struct {
+				Name   string `json:"name"`
+				Schema struct {
+					Format string `json:"format"`
+				} `json:"schema"`
+			} `json:"parameters"`
+		}
+		if json.Unmarshal(raw, &op) != nil {
+			continue
+		}
+
+		for _, param := range op.Parameters {
+			if param.Schema.Format != "" {
+				f[strings.ToLower(method)+" "+path+" "+param.Name] = param.Schema.Format
+			}
+		}
+	}
+}
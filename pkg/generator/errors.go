@@ -0,0 +1,35 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package generator
+
+import (
+	"errors"
+)
+
+// ErrNoServers is a spec which names no server to build requests for
+var ErrNoServers = errors.New("err: need at least one server to call, none provided")
+
+// DbError is a db entry which can't be used as written, such as a bad regex, weight, fuzz strategy, or faker
+type DbError struct {
+	Name string // Identifier whose entry is at fault
+	Err  error  // What's wrong with it
+}
+
+func (e *DbError) Error() string {
+	return `err: bad db entry "` + e.Name + `" → ` + e.Err.Error()
+}
+
+func (e *DbError) Unwrap() error {
+	return e.Err
+}
+
+// MissingError is a parameter nothing could be found for, under Strict
+type MissingError struct {
+	In   string // Where the parameter goes, such as "path"
+	Name string
+}
+
+func (e *MissingError) Error() string {
+	return "err: could not find " + e.In + " parameter → " + e.Name
+}
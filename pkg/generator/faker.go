@@ -0,0 +1,149 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package generator
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	mrand "math/rand"
+	"strings"
+	"time"
+)
+
+// Prefix for db values synthesized per request, such as `email=@faker:email`
+const FakerPrefix = "@faker:"
+
+// Word lists for plausible-looking values
+var (
+	fakeFirstNames = []string{"Ada", "Alan", "Barbara", "Claude", "Dennis", "Edsger", "Frances", "Grace", "Hedy", "Ivan", "Joan", "Ken", "Linus", "Margaret", "Niklaus", "Radia", "Rob", "Shafi", "Tim", "Yukihiro"}
+	fakeLastNames  = []string{"Allen", "Hopper", "Kernighan", "Knuth", "Lamarr", "Liskov", "Lovelace", "Hamilton", "Perlman", "Pike", "Ritchie", "Shannon", "Sutherland", "Thompson", "Turing", "Wirth"}
+	fakeDomains    = []string{"example.com", "example.net", "example.org"}
+	fakeCompanies  = []string{"Contoso", "Fabrikam", "Northwind Traders", "Adventure Works", "Tailspin Toys", "Wide World Importers", "Litware", "Proseware"}
+	fakeCities     = []string{"Amsterdam", "Bangalore", "Dublin", "Nairobi", "Redmond", "São Paulo", "Seoul", "Sydney", "Toronto", "Zürich"}
+	fakeCountries  = []string{"AU", "BR", "CA", "CH", "IE", "IN", "KE", "KR", "NL", "US"}
+	fakeStreets    = []string{"Main St", "High St", "Station Rd", "Park Ave", "Church Ln", "Mill Rd", "Oak Dr", "Lake View"}
+	fakeWords      = []string{"alpha", "bravo", "charlie", "delta", "echo", "foxtrot", "golf", "hotel", "india", "juliet", "kilo", "lima", "mike", "november", "oscar", "papa"}
+)
+
+// Pick a random element
+func pick(list []string) string {
+	return list[randInt(len(list))]
+}
+
+// Random integer in [0, n)
+// Values needn't be secret, so should the system's randomness fail, math/rand will do
+func randInt(n int) int {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return mrand.Intn(n)
+	}
+
+	return int(i.Int64())
+}
+
+// Random integer from min to max, inclusive, for any range an int64 holds
+func randRange(min, max int64) int64 {
+	span := new(big.Int).Sub(big.NewInt(max), big.NewInt(min))
+	span.Add(span, big.NewInt(1))
+
+	i, err := rand.Int(rand.Reader, span)
+	if err != nil {
+		i = new(big.Int).Rand(mrand.New(mrand.NewSource(time.Now().UnixNano())), span)
+	}
+
+	return i.Add(i, big.NewInt(min)).Int64()
+}
+
+// Synthesize a value of a kind, such as "email"
+func Fake(kind string) (string, error) {
+	first, last := pick(fakeFirstNames), pick(fakeLastNames)
+
+	switch strings.ToLower(kind) {
+	case "person", "name":
+		return first + " " + last, nil
+	case "firstname":
+		return first, nil
+	case "lastname":
+		return last, nil
+	case "username":
+		return strings.ToLower(first[:1]+last) + fmt.Sprint(randInt(1000)), nil
+	case "email":
+		return strings.ToLower(first+"."+last) + fmt.Sprint(randInt(1000)) + "@" + pick(fakeDomains), nil
+	case "phone":
+		return fmt.Sprintf("+1-555-%03d-%04d", randInt(1000), randInt(10000)), nil
+	case "company":
+		return pick(fakeCompanies), nil
+	case "street", "address":
+		return fmt.Sprint(1+randInt(9999)) + " " + pick(fakeStreets), nil
+	case "city":
+		return pick(fakeCities), nil
+	case "country":
+		return pick(fakeCountries), nil
+	case "zip", "postcode":
+		return fmt.Sprintf("%05d", randInt(100000)), nil
+	case "url":
+		return "https://" + pick(fakeDomains) + "/" + pick(fakeWords), nil
+	case "ipv4":
+		return fmt.Sprintf("192.0.2.%d", 1+randInt(254)), nil
+	case "word":
+		return pick(fakeWords), nil
+	case "sentence":
+		words := make([]string, 4+randInt(6))
+		for i := range words {
+			words[i] = pick(fakeWords)
+		}
+		sentence := strings.Join(words, " ")
+		return strings.ToUpper(sentence[:1]) + sentence[1:] + ".", nil
+	case "int", "number":
+		return fmt.Sprint(randInt(1000000)), nil
+	case "bool":
+		return fmt.Sprint(randInt(2) == 1), nil
+	case "date":
+		return time.Now().AddDate(0, 0, -randInt(3650)).Format("2006-01-02"), nil
+	case "datetime":
+		return time.Now().Add(-time.Duration(randInt(3650*24)) * time.Hour).UTC().Format(time.RFC3339), nil
+	case "uuid":
+		return randomUUID(), nil
+	}
+
+	return "", fmt.Errorf("unknown faker %q", kind)
+}
+
+// Random version 4 UUID
+func randomUUID() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		mrand.Read(b)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// Synthesize @faker: values and execute value templates, others are as they are
+func Synthesize(values []string) ([]string, error) {
+	var out []string
+	for _, value := range values {
+		if strings.HasPrefix(value, FakerPrefix) {
+			fake, err := Fake(strings.TrimPrefix(value, FakerPrefix))
+			if err != nil {
+				return nil, errors.New("could not synthesize value → " + err.Error())
+			}
+			out = append(out, fake)
+			continue
+		}
+
+		value, err := Evaluate(value)
+		if err != nil {
+			return nil, errors.New("could not evaluate value template → " + err.Error())
+		}
+		out = append(out, value)
+	}
+
+	return out, nil
+}
@@ -0,0 +1,243 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package generator
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/seh-msft/openapi"
+)
+
+// Generate a more random property body
+func randProperty(obj map[string]string, name string, property openapi.Property) map[string]string {
+	switch property.Type {
+	case "string":
+		switch property.Format {
+		case "date-time":
+			obj[name] = "00-00-0000"
+		}
+
+		if len(property.Enums) > 0 {
+			// Select an enum at random
+			obj[name] = property.Enums[randInt(len(property.Enums))]
+
+		} else {
+			obj[name] = "\"\""
+		}
+
+	case "array":
+		obj[name] = "[]"
+
+	case "integer":
+		// Format
+		obj[name] = "0"
+		switch property.Format {
+		case "int32":
+		default:
+		}
+
+	default:
+		obj[name] = `""`
+	}
+
+	return obj
+}
+
+// Character sets for fuzzed strings by name, otherwise a charset is its own characters
+var fuzzCharsets = map[string]string{
+	"alpha":  "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	"alnum":  "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789",
+	"lower":  "abcdefghijklmnopqrstuvwxyz",
+	"upper":  "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	"digits": "0123456789",
+	"hex":    "0123456789abcdef",
+	"ascii":  "!\"#$%&'()*+,-./0123456789:;<=>?@ABCDEFGHIJKLMNOPQRSTUVWXYZ[\\]^_`abcdefghijklmnopqrstuvwxyz{|}~ ",
+}
+
+// Longest a fuzzed string may be, so one line of a db can't take all our memory
+const MaxFuzzLength = 65536
+
+// A range, as "8" or "8-16", with negative numbers allowed
+var fuzzRange = regexp.MustCompile(`^(-?[0-9]+)(?:-(-?[0-9]+))?$`)
+
+// FuzzStrategy is how to fuzz an identifier, as per its properties tuple, such as:
+//
+//	properties fuzz charset=hex length=8-16
+//	properties fuzz range=1-1000
+//	properties fuzz dictionary=names.txt
+type FuzzStrategy struct {
+	Charset    string // Characters strings are made of
+	MinLength  int
+	MaxLength  int
+	Dictionary string // File of values, one per line
+	Numeric    bool   // Integers from Min to Max, inclusive
+	Min        int64
+	Max        int64
+}
+
+// Dictionary files, by name, read once
+var fuzzDictionaries sync.Map
+
+// The fuzz strategy for an identifier's properties, false if it has none
+func ParseFuzz(properties map[string][]string) (FuzzStrategy, bool, error) {
+	s := FuzzStrategy{Charset: fuzzCharsets["alnum"], MinLength: 8, MaxLength: 16}
+	given := false
+
+	first := func(name string) (string, bool) {
+		values, ok := properties[name]
+		if !ok || len(values) < 1 {
+			return "", false
+		}
+		given = true
+		return values[0], true
+	}
+
+	if charset, ok := first("charset"); ok {
+		s.Charset = charset
+		if named, ok := fuzzCharsets[strings.ToLower(charset)]; ok {
+			s.Charset = named
+		}
+		if s.Charset == "" {
+			return s, given, errors.New("empty charset")
+		}
+	}
+
+	if length, ok := first("length"); ok {
+		min, max, err := parseRange(length)
+		if err != nil || min < 0 {
+			return s, given, errors.New("invalid length \"" + length + "\"")
+		}
+		if max > MaxFuzzLength {
+			return s, given, errors.New("length \"" + length + "\" is longer than " + strconv.Itoa(MaxFuzzLength))
+		}
+		s.MinLength, s.MaxLength = int(min), int(max)
+	}
+
+	if r, ok := first("range"); ok {
+		min, max, err := parseRange(r)
+		if err != nil {
+			return s, given, errors.New("invalid range \"" + r + "\"")
+		}
+		s.Numeric, s.Min, s.Max = true, min, max
+	}
+
+	if dictionary, ok := first("dictionary"); ok {
+		s.Dictionary = dictionary
+	}
+
+	return s, given, nil
+}
+
+// Check a strategy can be used, such as its dictionary being readable
+func (s FuzzStrategy) Check() error {
+	if s.Dictionary == "" {
+		return nil
+	}
+
+	_, err := dictionary(s.Dictionary)
+	return err
+}
+
+// Parse "n" or "min-max"
+func parseRange(s string) (int64, int64, error) {
+	m := fuzzRange.FindStringSubmatch(s)
+	if m == nil {
+		return 0, 0, errors.New("not a range")
+	}
+
+	min, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	max := min
+	if m[2] != "" {
+		max, err = strconv.ParseInt(m[2], 10, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	if max < min {
+		return 0, 0, errors.New("range ends before it starts")
+	}
+
+	return min, max, nil
+}
+
+// Fuzz a value as per the strategy
+func (s FuzzStrategy) Value() (string, error) {
+	switch {
+	case s.Dictionary != "":
+		words, err := dictionary(s.Dictionary)
+		if err != nil {
+			return "", err
+		}
+		return words[randInt(len(words))], nil
+
+	case s.Numeric:
+		return strconv.FormatInt(randRange(s.Min, s.Max), 10), nil
+	}
+
+	charset := []rune(s.Charset)
+	length := s.MinLength + randInt(s.MaxLength-s.MinLength+1)
+	out := make([]rune, length)
+	for i := range out {
+		out[i] = charset[randInt(len(charset))]
+	}
+
+	return string(out), nil
+}
+
+// Fuzz a valid value for an OpenAPI type, for identifiers with no strategy
+func FuzzType(kind string) string {
+	var s FuzzStrategy
+	switch strings.ToLower(kind) {
+	case "integer":
+		s = FuzzStrategy{Numeric: true, Min: 0, Max: 1000}
+	case "number":
+		return strconv.FormatFloat(float64(randInt(100000))/100, 'f', -1, 64)
+	case "boolean":
+		return strconv.FormatBool(randInt(2) == 1)
+	default:
+		s = FuzzStrategy{Charset: fuzzCharsets["alnum"], MinLength: 8, MaxLength: 16}
+	}
+
+	v, _ := s.Value()
+	return v
+}
+
+// The non-empty lines of a dictionary file
+func dictionary(name string) ([]string, error) {
+	if words, ok := fuzzDictionaries.Load(name); ok {
+		return words.([]string), nil
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var words []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			words = append(words, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(words) < 1 {
+		return nil, errors.New("dictionary " + name + " is empty")
+	}
+
+	fuzzDictionaries.Store(name, words)
+	return words, nil
+}
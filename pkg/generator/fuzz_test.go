@@ -0,0 +1,92 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package generator
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestParseFuzz(t *testing.T) {
+	max := strconv.Itoa(MaxFuzzLength)
+	tests := []struct {
+		properties map[string][]string
+		want       FuzzStrategy
+		given      bool
+		wantErr    bool
+	}{
+		{
+			properties: map[string][]string{},
+			want:       FuzzStrategy{Charset: fuzzCharsets["alnum"], MinLength: 8, MaxLength: 16},
+		},
+		{
+			properties: map[string][]string{"charset": {"hex"}, "length": {"4-6"}},
+			want:       FuzzStrategy{Charset: fuzzCharsets["hex"], MinLength: 4, MaxLength: 6},
+			given:      true,
+		},
+		{
+			properties: map[string][]string{"charset": {"xyz"}, "length": {"3"}},
+			want:       FuzzStrategy{Charset: "xyz", MinLength: 3, MaxLength: 3},
+			given:      true,
+		},
+		{
+			properties: map[string][]string{"length": {max}},
+			want:       FuzzStrategy{Charset: fuzzCharsets["alnum"], MinLength: MaxFuzzLength, MaxLength: MaxFuzzLength},
+			given:      true,
+		},
+		{
+			properties: map[string][]string{"range": {"-5-1000"}},
+			want:       FuzzStrategy{Charset: fuzzCharsets["alnum"], MinLength: 8, MaxLength: 16, Numeric: true, Min: -5, Max: 1000},
+			given:      true,
+		},
+		{
+			properties: map[string][]string{"dictionary": {"names.txt"}},
+			want:       FuzzStrategy{Charset: fuzzCharsets["alnum"], MinLength: 8, MaxLength: 16, Dictionary: "names.txt"},
+			given:      true,
+		},
+
+		// Lengths which would take all our memory
+		{properties: map[string][]string{"length": {strconv.Itoa(MaxFuzzLength + 1)}}, given: true, wantErr: true},
+		{properties: map[string][]string{"length": {"0-" + strconv.Itoa(MaxFuzzLength+1)}}, given: true, wantErr: true},
+		{properties: map[string][]string{"length": {"1-99999999999999999999"}}, given: true, wantErr: true},
+
+		// Malformed
+		{properties: map[string][]string{"length": {"-1"}}, given: true, wantErr: true},
+		{properties: map[string][]string{"length": {"16-8"}}, given: true, wantErr: true},
+		{properties: map[string][]string{"length": {"many"}}, given: true, wantErr: true},
+		{properties: map[string][]string{"range": {"1-"}}, given: true, wantErr: true},
+		{properties: map[string][]string{"charset": {""}}, given: true, wantErr: true},
+	}
+
+	for _, test := range tests {
+		got, given, err := ParseFuzz(test.properties)
+		if (err != nil) != test.wantErr {
+			t.Errorf("ParseFuzz(%v) error = %v, want error %v", test.properties, err, test.wantErr)
+			continue
+		}
+		if given != test.given {
+			t.Errorf("ParseFuzz(%v) given = %v, want %v", test.properties, given, test.given)
+		}
+		if err == nil && got != test.want {
+			t.Errorf("ParseFuzz(%v) = %+v, want %+v", test.properties, got, test.want)
+		}
+	}
+}
+
+func TestFuzzValueLength(t *testing.T) {
+	s, _, err := ParseFuzz(map[string][]string{"charset": {"hex"}, "length": {"2-5"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100; i++ {
+		value, err := s.Value()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(value) < 2 || len(value) > 5 {
+			t.Fatalf("Value() = %q, want 2 to 5 characters", value)
+		}
+	}
+}
@@ -0,0 +1,455 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+// Package generator builds HTTP requests for every operation of an OpenAPI specification, with values from a cfg db,
+// replays them, and judges each response against what the specification expects.
+//
+// A run is Generate, then a Replayer's Replay, then Validate:
+//
+//	requests, missed, possible, err := generator.Generate(ctx, api, db, generator.Options{Proto: "https"})
+//	results := make(map[*generator.Request]*generator.Response)
+//	r := &generator.Replayer{Pacing: generator.Pacing{Proto: "https"}}
+//	err = r.Replay(ctx, requests, func(request *generator.Request, response generator.Response) {
+//		results[request] = &response
+//	})
+//	suspicious, conformant, err := generator.Validate(ctx, requests, results)
+//
+// Each stops early, returning the context's error, once it ends.
+//
+// For large specs, Build hands over each request as it's built rather than keeping them all, and a Replayer's Pipe
+// replays each as soon as it's built, so traffic starts before generation ends:
+//
+//	missed, possible, err := r.Pipe(ctx, api, db, opts, func(request *generator.Request, response generator.Response) {
+//		// Judge and keep or write out each result
+//	})
+package generator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/seh-msft/cfg"
+	"github.com/seh-msft/openapi"
+)
+
+// Request represents an HTTP request and associated meta-information.
+type Request struct {
+	*http.Request                 // HTTP request
+	Method        *openapi.Method // Method related to our request
+	Path          string          // OpenAPI path template, such as "/users/{userId}"
+	Variant       string          // Values of a variant, as per Cartesian or Sequence, such as "tenant=t2"
+	Sources       []Source        // Where each parameter's value came from
+}
+
+// Source says where a parameter's value came from
+type Source struct {
+	In    string // "path", "query", "header", or "body"
+	Name  string
+	Value string // "" if random, as body properties may be
+	From  string // "db", "default", "answered", "fuzzed", or "random"
+}
+
+// Suffix labelling the variant a request is, if any, such as " [tenant=t2]"
+func (r *Request) VariantSuffix() string {
+	if r.Variant == "" {
+		return ""
+	}
+
+	return " [" + r.Variant + "]"
+}
+
+// Options say how requests are built
+type Options struct {
+	Strict    bool   `json:"strict"`    // A value which can't be filled fails generation
+	AllBodies bool   `json:"allbodies"` // Write a body for every request
+	Proto     string `json:"proto"`     // Scheme of built requests' URLs
+	Sequence  bool   `json:"sequence"`  // A request per enumerated value of multi-valued parameters, in step
+	Cartesian int    `json:"cartesian"` // Up to this many requests per operation from the cross product of multi-valued parameters
+
+	// Take identifiers the db has nothing for from GEN_name environment variables
+	EnvFallback bool `json:"-"`
+	// Parameter formats from the spec, as per SpecFormats
+	Formats Formats `json:"-"`
+	// Asks for a value the db has nothing for, if set
+	Ask func(method, path string, param openapi.Parameter) (string, bool) `json:"-"`
+	// Told of progress, if set
+	Log func(s string) `json:"-"`
+}
+
+// Log progress, if anyone is listening
+func (o Options) log(s string) {
+	if o.Log != nil {
+		o.Log(s)
+	}
+}
+
+// Generate requests for every operation in an api, with values from a db
+// Returns the requests, the parameters missed and how often, and how many requests were possible
+func Generate(ctx context.Context, api openapi.API, db cfg.Cfg, opts Options) ([]*Request, map[string]uint64, uint64, error) {
+	var requests []*Request
+	missing, totalPossible, err := Build(ctx, api, db, opts, func(request *Request) error {
+		requests = append(requests, request)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	return requests, missing, totalPossible, nil
+}
+
+// Build requests as Generate does, handing each to yield as soon as it's built rather than keeping them all
+// An error from yield stops generation and is returned
+func Build(ctx context.Context, api openapi.API, db cfg.Cfg, opts Options, yield func(*Request) error) (map[string]uint64, uint64, error) {
+	failed := make(map[string]error)
+	totalPossible := uint64(0)
+	missing := make(map[string]uint64)
+
+	// "/foo/bar", map["get"]Method{}
+	// Operations are built in order of path, then method, so runs are alike
+	for _, path := range sortedPaths(api) {
+		methods := api.Paths[path]
+		if err := ctx.Err(); err != nil {
+			return nil, 0, err
+		}
+		opts.log(path + ":\n")
+
+		// "get", Method{}
+	methods:
+		for _, httpMethod := range sortedMethods(methods) {
+			// Requests keep a pointer to their method
+			method := methods[httpMethod]
+			totalPossible++
+			// TODO - openapi parse "requestBody" for POST, etc.
+			opts.log("\t" + httpMethod + ":\n")
+
+			opts.log("\t\t" + method.Summary + "\n\n")
+
+			// Were all the parameters filled from the db?
+			var paths, queries, headers []openapi.Parameter
+
+			// Scan parameters for where they will be substituted in the request to build
+			// Parameter.In = "path", "query", or "header"
+			for _, param := range method.Parameters {
+				if !param.Required {
+					// TODO - attempt to fill non-required parameters
+					// Might be non-trivial
+					continue
+				}
+
+				switch strings.ToLower(param.In) {
+				case "path":
+					paths = append(paths, param)
+
+				case "query":
+					queries = append(queries, param)
+
+				case "header":
+					headers = append(headers, param)
+				}
+
+				opts.log("\t\t" + param.In + " — " + param.Name + "\n")
+			}
+
+			// Insert path parameters
+			// TODO - build URL/request for each server if multiple servers exist
+			if len(api.Servers) < 1 {
+				return nil, 0, ErrNoServers
+			}
+
+			// Values for each parameter, by where they go
+			params := append(append(append([]openapi.Parameter{}, paths...), queries...), headers...)
+			// Values from the db are as written, synthesized for each request as per synthesize
+			choices := make([][]string, len(params))
+			synthesize := make([]bool, len(params))
+			from := make([]string, len(params))
+			for i, parameter := range params {
+				values, r, synth, err := opts.lookupRaw(db, parameter.Name, path, method.OperationID, api.Info.Title)
+				if err != nil {
+					return nil, 0, err
+				}
+				synthesize[i] = synth
+				switch r {
+				case Something:
+					choices[i], from[i] = values, "db"
+					opts.log(fmt.Sprintf("\t\t\t%s ← %d from the db\n", parameter.Name, len(values)))
+
+				case Nothing:
+					// Values for any parameter of the type or format
+					value, ok := defaultRaw(db, parameter.Schema.Type, opts.Formats.Of(httpMethod, path, parameter.Name))
+					if ok {
+						choices[i], from[i], synthesize[i] = []string{value}, "default", true
+						opts.log("\t\t\t" + parameter.Name + " ← a default for its type\n")
+						continue
+					}
+
+					// Ask rather than skip
+					if opts.Ask != nil {
+						if answer, ok := opts.Ask(httpMethod, path, parameter); ok {
+							choices[i], from[i] = []string{answer}, "answered"
+							opts.log("\t\t\t" + parameter.Name + " ← answered\n")
+							continue
+						}
+					}
+
+					if opts.Strict {
+						return nil, 0, &MissingError{strings.ToLower(parameter.In), parameter.Name}
+					}
+
+					opts.log("\t\t\t" + parameter.Name + " ← nothing, skipping the operation\n")
+					missing[parameter.Name]++
+					failed[path] = errors.New(fmt.Sprint("could not find "+strings.ToLower(parameter.In)+" parameter → ", parameter))
+					continue methods
+				case Fuzzing:
+					// A value selected at random or fuzzed as per the db, otherwise by type
+					choices[i], from[i] = values, "fuzzed"
+					if len(values) < 1 {
+						choices[i] = []string{FuzzType(parameter.Schema.Type)}
+					}
+					opts.log("\t\t\t" + parameter.Name + " ← fuzzed\n")
+				default:
+				}
+			}
+
+			// Body properties take values as parameters do, but are never missed
+			var target *openapi.Type
+			if method.RequestBody.Required || opts.AllBodies {
+				if t, found := bodySchema(api, &method); found {
+					target = &t
+
+					var names []string
+					for name := range t.Properties {
+						names = append(names, name)
+					}
+					sort.Strings(names)
+
+					for _, name := range names {
+						values, r, synth, err := opts.lookupRaw(db, name, path, method.OperationID, api.Info.Title)
+						if err != nil {
+							return nil, 0, err
+						}
+						source := "db"
+						switch {
+						case r == Nothing:
+							values, source = nil, "random"
+							value, ok := defaultRaw(db, t.Properties[name].Type, t.Properties[name].Format)
+							if ok {
+								values, source, synth = []string{value}, "default", true
+							}
+						case r == Fuzzing:
+							source = "fuzzed"
+							if len(values) < 1 {
+								values = []string{FuzzType(t.Properties[name].Type)}
+							}
+						}
+						if len(values) > 0 {
+							opts.log(fmt.Sprintf("\t\t\tbody %s ← %d value(s)\n", name, len(values)))
+						} else {
+							opts.log("\t\t\tbody " + name + " ← random for its type\n")
+						}
+						params = append(params, openapi.Parameter{Name: name, In: "body"})
+						choices = append(choices, values)
+						synthesize = append(synthesize, synth)
+						from = append(from, source)
+					}
+				}
+			}
+
+			// One request per variant, each a possible request
+			combos := variants(choices, opts)
+			totalPossible += uint64(len(combos) - 1)
+			for _, combination := range combos {
+				picked, err := synthesized(params, choices, synthesize, combination)
+				if err != nil {
+					return nil, 0, err
+				}
+				httpReq, err := buildRequest(api, path, httpMethod, &method, target, params, picked, combination, opts)
+				if err != nil {
+					if opts.Strict {
+						return nil, 0, errors.New("err: could not build request → " + err.Error())
+					}
+
+					failed[path] = err
+					continue methods
+				}
+
+				label := ""
+				if len(combos) > 1 {
+					label = variantLabel(params, choices, combination)
+				}
+				err = yield(&Request{httpReq, &method, path, label, sources(params, picked, from, combination)})
+				if err != nil {
+					return nil, 0, err
+				}
+			}
+		}
+
+		opts.log("\n")
+	}
+
+	return missing, totalPossible, nil
+}
+
+// Choices with the values of a combination synthesized, as per synthesize, so each request has its own {{uuid}}
+// Only the values the combination picks are synthesized, the rest are as they were
+func synthesized(params []openapi.Parameter, choices [][]string, synthesize []bool, combination []int) ([][]string, error) {
+	picked := append([][]string(nil), choices...)
+	for i, n := range combination {
+		if n < 0 || !synthesize[i] {
+			continue
+		}
+
+		values, err := Synthesize([]string{choices[i][n]})
+		if err != nil {
+			return nil, &DbError{params[i].Name, err}
+		}
+		picked[i] = append([]string(nil), choices[i]...)
+		picked[i][n] = values[0]
+	}
+
+	return picked, nil
+}
+
+// Where the values of a combination came from, by parameter
+func sources(params []openapi.Parameter, choices [][]string, from []string, combination []int) []Source {
+	out := make([]Source, len(params))
+	for i, parameter := range params {
+		out[i] = Source{In: strings.ToLower(parameter.In), Name: parameter.Name, From: from[i]}
+		if combination[i] >= 0 {
+			out[i].Value = choices[i][combination[i]]
+		}
+	}
+
+	return out
+}
+
+// An api's paths, in order
+func sortedPaths(api openapi.API) []string {
+	var paths []string
+	for path := range api.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	return paths
+}
+
+// A path's methods, in order
+func sortedMethods(methods map[string]openapi.Method) []string {
+	var names []string
+	for name := range methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Look up an identifier as Lookup does, falling back to the environment if the options say to
+func (o Options) Lookup(c cfg.Cfg, name, path, operation, title string) ([]string, Result, error) {
+	out, r, err := Lookup(c, name, path, operation, title)
+	if err != nil || r != Nothing || !o.EnvFallback {
+		return out, r, err
+	}
+
+	// Fall back to the environment
+	if value, ok := LookupEnv(name); ok {
+		return []string{value}, Something, nil
+	}
+
+	return out, r, nil
+}
+
+// Look up an identifier as Options.Lookup does, leaving its values as written in the db
+// synthesize is whether they're from the db, so are to be synthesized for each request
+func (o Options) lookupRaw(c cfg.Cfg, name, path, operation, title string) (values []string, r Result, synthesize bool, err error) {
+	out, r, err := LookupDb(c, name, path, operation, title)
+	if err != nil || r != Nothing || !o.EnvFallback {
+		return out, r, err == nil && r != Nothing, err
+	}
+
+	if value, ok := LookupEnv(name); ok {
+		return []string{value}, Something, false, nil
+	}
+
+	return out, r, false, nil
+}
+
+// Build a request with the values of a combination, an index into each parameter's choices
+// Body properties are parameters "in" body, the target is the body's schema, if known
+func buildRequest(api openapi.API, path, httpMethod string, method *openapi.Method, target *openapi.Type, params []openapi.Parameter, choices [][]string, combination []int, opts Options) (*http.Request, error) {
+	// Values by where they go
+	values := make(map[string]map[string]string)
+	for i, parameter := range params {
+		if combination[i] < 0 {
+			// TODO - fuzzing?
+			continue
+		}
+
+		in := strings.ToLower(parameter.In)
+		if values[in] == nil {
+			values[in] = make(map[string]string)
+		}
+		values[in][parameter.Name] = choices[i][combination[i]]
+	}
+
+	// Insert path parameters
+	fullPath := opts.Proto + api.Servers[0].URL + path
+	for name, value := range values["path"] {
+		apiForm := fmt.Sprintf(`{%s}`, name)
+		fullPath = strings.ReplaceAll(fullPath, apiForm, value)
+	}
+
+	var body bytes.Buffer
+
+	// Build body, if required
+	if method.RequestBody.Required || opts.AllBodies {
+		// Start constructing JSON for the body
+		// TODO - an actual recursive object builder?
+		//		"object" could trigger a new map[] level
+		obj := make(map[string]string)
+		if target != nil {
+			// We know the scheme, fill all we can
+			for name, property := range target.Properties {
+				// Fill values we know
+				if value, ok := values["body"][name]; ok {
+					obj[name] = value
+				} else {
+					obj = randProperty(obj, name, property)
+				}
+			}
+		} else {
+			// Unknown scheme - let object be {}
+			// TODO - strict mode fatal?
+		}
+
+		enc := json.NewEncoder(&body)
+		enc.Encode(obj)
+	}
+
+	// Generate request structure
+	httpReq, err := http.NewRequest(strings.ToUpper(httpMethod), fullPath, &body)
+	if err != nil {
+		return nil, err
+	}
+
+	// Insert query parameters
+	vals := httpReq.URL.Query()
+	for name, value := range values["query"] {
+		vals[name] = []string{value}
+	}
+	httpReq.URL.RawQuery = vals.Encode()
+
+	// Override HTTP headers
+	for name, value := range values["header"] {
+		httpReq.Header[name] = []string{value}
+	}
+
+	return httpReq, nil
+}
@@ -0,0 +1,38 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package generator
+
+import (
+	"net/http"
+)
+
+// Hook sees each request just before it's sent, and each response once it lands
+// Before may change the request, such as to set headers or sign it, an error stops the replay
+// After may assert on the response, an error fails the response, making it suspicious
+type Hook interface {
+	Before(req *http.Request) error
+	After(req *http.Request, resp *Response) error
+}
+
+// HookFuncs is a Hook of plain functions, either of which may be nil
+type HookFuncs struct {
+	BeforeFunc func(req *http.Request) error
+	AfterFunc  func(req *http.Request, resp *Response) error
+}
+
+func (h HookFuncs) Before(req *http.Request) error {
+	if h.BeforeFunc == nil {
+		return nil
+	}
+
+	return h.BeforeFunc(req)
+}
+
+func (h HookFuncs) After(req *http.Request, resp *Response) error {
+	if h.AfterFunc == nil {
+		return nil
+	}
+
+	return h.AfterFunc(req, resp)
+}
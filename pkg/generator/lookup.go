@@ -0,0 +1,452 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package generator
+
+import (
+	"errors"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/seh-msft/cfg"
+)
+
+// Result indicates the type of result of a lookup
+type Result int
+
+const (
+	Something Result = iota // Something was found (1+ results)
+	Nothing                 // Nothing was found
+	Fuzzing                 // The caller should invoke contextual fuzzing
+)
+
+// Lookup an identifier name for a given path in a given API
+// Return the set of values which are usable and an 'ok' indicator
+// Path should be in the original OpenAPI {someId} form
+// Values such as @faker:email and {{uuid}} are synthesized per request
+// An entry which can't be used is a *DbError
+func Lookup(c cfg.Cfg, name, path, operation, title string) ([]string, Result, error) {
+	out, r, err := LookupDb(c, name, path, operation, title)
+	if err != nil || r == Nothing {
+		return out, r, err
+	}
+
+	out, err = Synthesize(out)
+	if err != nil {
+		return nil, Nothing, &DbError{name, err}
+	}
+
+	return out, r, nil
+}
+
+// Look up a value for an identifier in the db, as it's written there
+// An entry which can't be used is a *DbError
+func LookupDb(c cfg.Cfg, name, path, operation, title string) ([]string, Result, error) {
+	out, r, err := lookupDb(c, name, path, operation, title)
+	if err != nil {
+		return nil, Nothing, &DbError{name, err}
+	}
+
+	return out, r, nil
+}
+
+// The workings of LookupDb
+func lookupDb(c cfg.Cfg, name, path, operation, title string) ([]string, Result, error) {
+	var out []string
+
+	// Other spellings of a name share its record
+	name, _ = ResolveName(c, name)
+
+	// The attributes for record 'name' with the tuple 'name'
+	primaryAttributes, ok := c.Map[name][name]
+	if !ok {
+		return out, Nothing, nil
+	}
+
+	// Values scoped to a path or operation come first
+	value, ok, err := override(c, name, path, operation, title)
+	if err != nil {
+		return out, Nothing, err
+	}
+	if ok {
+		return []string{value}, Something, nil
+	}
+
+	primaryValue, hasValue := primaryAttributes[name]
+	if hasValue {
+		// Only true if we contain at least one element
+		hasValue = len(primaryValue) > 0
+	}
+
+	// Get properties for record 'name'
+	properties, hasProperties := c.Map[name]["properties"]
+
+	// Short circuit if 'name' has no rules and no enumerated values
+	_, hasDisallows := c.Map[name]["disallow"]
+	_, hasPermits := c.Map[name]["permit"]
+	_, hasEnums := c.Map[name]["values"]
+	_, hasFuzz := properties["fuzz"]
+
+	if !hasValue && !hasEnums && !hasFuzz {
+		// Value omitted for this identifier
+		// TODO - maybe a flag to handle this case?
+		return out, Nothing, nil
+	}
+
+	if !hasDisallows && !hasPermits && !hasEnums && !hasFuzz && hasValue {
+		// Just the value
+		return primaryValue, Something, nil
+	}
+
+	// Records are identified by the identifier name
+	records, ok := c.Lookup(name)
+	if !ok {
+		return out, Nothing, nil
+	}
+
+	fuzz := false
+
+	// Determine if the identifier is valid
+	// We do costly lookups here to guarantee ordering of 'permit', 'disallow', and 'values'
+	// As they are ordered and maps play with ordering
+recordSearch:
+	for _, record := range records {
+		exceptions, ok := record.Lookup("disallow")
+		if ok {
+			excepted, err := MatchTuples(exceptions, path, operation, title)
+			if err != nil {
+				return nil, Nothing, err
+			}
+			if excepted {
+				// We are an exception
+				continue recordSearch
+			}
+		}
+
+		constraints, ok := record.Lookup("permit")
+		if ok {
+			permitted, err := MatchTuples(constraints, path, operation, title)
+			if err != nil {
+				return nil, Nothing, err
+			}
+			if !permitted {
+				// We are not in scope
+				continue recordSearch
+			}
+		}
+
+		// Populate properties
+		if hasProperties {
+			if _, hasFuzz := properties["fuzz"]; hasFuzz {
+				fuzz = true
+			}
+		}
+
+		// Search for enumerated values - ordered
+		values, ok := record.Lookup("values")
+		var vals []string
+		var weights []int
+
+		// Build table of enumerated values, weighted as `values a=5 b=1`
+		if ok {
+			for _, tuple := range values {
+				attributes := tuple.Attributes
+				if len(attributes) > 1 {
+					for _, v := range attributes[1:] {
+						weight, err := valueWeight(v)
+						if err != nil {
+							return nil, Nothing, err
+						}
+						vals = append(vals, v.Name)
+						weights = append(weights, weight)
+					}
+				}
+			}
+		}
+
+		// Insert an enumerated value if any was supplied, short circuit
+		if len(vals) > 0 {
+			if fuzz {
+				// One, single, randomly selected, value
+				// TODO - just shuffle and append?
+				out = append(out, vals[weightedIndex(weights)])
+				continue recordSearch
+			}
+
+			// All values, in order
+			out = append(out, vals...)
+			continue recordSearch
+		}
+
+		// Fuzz as per the identifier's strategy, if it has one
+		if fuzz {
+			strategy, given, err := ParseFuzz(properties)
+			if err != nil {
+				return nil, Nothing, errors.New("invalid fuzz properties → " + err.Error())
+			}
+			if given {
+				value, err := strategy.Value()
+				if err != nil {
+					return nil, Nothing, errors.New("could not fuzz → " + err.Error())
+				}
+				out = append(out, value)
+			}
+			continue recordSearch
+		}
+
+		// Insert the primary value for this identifier
+		if !fuzz && len(primaryValue) > 0 {
+			out = append(out, primaryValue...)
+			continue recordSearch
+		}
+
+		// TODO - fuzzing?
+	}
+
+	r := Nothing
+	if fuzz {
+		r = Fuzzing
+	} else if len(out) > 0 {
+		r = Something
+	}
+
+	return out, r, nil
+}
+
+// The weight of an enumerated value, 1 unless given as `value=weight`
+func valueWeight(attr *cfg.Attribute) (int, error) {
+	if attr.Value == "" {
+		return 1, nil
+	}
+
+	weight, err := strconv.Atoi(attr.Value)
+	if err != nil || weight < 0 {
+		return 0, errors.New("weight of value \"" + attr.Name + "\" must be a whole number, not " + attr.Value)
+	}
+
+	return weight, nil
+}
+
+// Select an index at random, in proportion to its weight
+func weightedIndex(weights []int) int {
+	total := 0
+	for _, weight := range weights {
+		total += weight
+	}
+	if total < 1 {
+		// All weighted out, so uniform
+		return randInt(len(weights))
+	}
+
+	n := randInt(total)
+	for i, weight := range weights {
+		if n < weight {
+			return i
+		}
+		n -= weight
+	}
+
+	return len(weights) - 1
+}
+
+// Sees if a tuple set, such as permit rules, matches a path, operationId, and title
+func MatchTuples(tuples []*cfg.Tuple, path, operation, title string) (bool, error) {
+	var err error
+	for _, tuple := range tuples {
+		attributes := tuple.Attributes
+		// Strip 'except' or 'permit'
+		if len(attributes) > 1 {
+			attributes = attributes[1:]
+		}
+
+		// Valid determines if a given attribute entry and our name/path/title are compatible
+		valid := func(value, other string) bool {
+			return value == other
+		}
+
+		// Use regex to test equality if requested
+		// Tuple.BuildMap can't keep its map, so it's built here
+		_, hasRegex := tuple.BuildMap()["regex"]
+		if len(attributes) > 1 && hasRegex {
+			valid = func(value, other string) bool {
+				regex, e := regexp.Compile(value)
+				if e != nil {
+					err = errors.New(`could not compile regex "` + value + `" → ` + e.Error())
+					return false
+				}
+
+				return regex.MatchString(other)
+			}
+
+			// Strip 'regex'
+			attributes = attributes[1:]
+		}
+
+		result := false
+
+		// Search attributes in the tuple
+	searchAttributes:
+		for _, attr := range attributes {
+			test := ""
+			switch attr.Name {
+			case "title":
+				test = title
+			case "path":
+				test = path
+			case "operation":
+				test = operation
+			default:
+				// Unknown keyword
+				// Skip
+				continue searchAttributes
+			}
+
+			if valid(attr.Value, test) {
+				// Valid and we had an invalid result
+				result = true
+			} else {
+				// Invalid and result was true
+				// A rule in the tuple was violated
+				result = false
+				break searchAttributes
+			}
+		}
+
+		if err != nil {
+			return false, err
+		}
+		if result {
+			return true, nil
+		}
+	}
+
+	// Do not match by default
+	return false, nil
+}
+
+// Find a value scoped to a path, operationId, or title by an override tuple, such as:
+//
+//	orderId=ord-1
+//		override path="/archive/{orderId}" value=arc-9
+//		override operation=getArchivedOrder value=arc-7
+func override(c cfg.Cfg, name, path, operation, title string) (string, bool, error) {
+	records, _ := c.Lookup(name)
+	for _, record := range records {
+		overrides, ok := record.Lookup("override")
+		if !ok {
+			continue
+		}
+
+		for _, tuple := range overrides {
+			matched, err := MatchTuples([]*cfg.Tuple{tuple}, path, operation, title)
+			if err != nil {
+				return "", false, err
+			}
+			if !matched {
+				continue
+			}
+
+			for _, attr := range tuple.Attributes {
+				if attr.Name == "value" {
+					return attr.Value, true, nil
+				}
+			}
+		}
+	}
+
+	return "", false, nil
+}
+
+// Find the record name in the db serving a parameter name
+// An exact match wins, then a record listing the name as an alias, then a record whose name differs only in case, '_', or '-'
+func ResolveName(c cfg.Cfg, name string) (string, bool) {
+	if _, ok := c.Map[name]; ok {
+		return name, true
+	}
+
+	normal := normalName(name)
+	for _, record := range c.Records {
+		if IsIdentity(record) {
+			continue
+		}
+		for _, alias := range Aliases(record) {
+			if normalName(alias) == normal {
+				return record.PrimaryKey(), true
+			}
+		}
+	}
+
+	for _, record := range c.Records {
+		if IsIdentity(record) || len(record.Tuples) < 1 || len(record.Tuples[0].Attributes) < 1 {
+			continue
+		}
+		if normalName(record.PrimaryKey()) == normal {
+			return record.PrimaryKey(), true
+		}
+	}
+
+	return name, false
+}
+
+// The aliases a record lists, such as:
+//
+//	userId=abc-123
+//		alias=user_id,uid
+//
+// Or `alias user_id uid`, or `userId=abc-123 alias=user_id,uid`
+func Aliases(record *cfg.Record) []string {
+	var out []string
+	for i, tuple := range record.Tuples {
+		for j, attr := range tuple.Attributes {
+			if attr.Name != "alias" || (i > 0 && j > 0) {
+				continue
+			}
+
+			for _, alias := range strings.Split(attr.Value, ",") {
+				if alias = strings.TrimSpace(alias); alias != "" {
+					out = append(out, alias)
+				}
+			}
+			if i > 0 {
+				for _, alias := range tuple.Attributes[1:] {
+					out = append(out, alias.Name)
+				}
+			}
+		}
+	}
+
+	return out
+}
+
+// A name without case, '_', or '-', so userId, user_id, and UserID are one
+func normalName(name string) string {
+	name = strings.ToLower(name)
+	return strings.NewReplacer("_", "", "-", "").Replace(name)
+}
+
+// Is a record an identity, a named credential set rather than an identifier?
+func IsIdentity(record *cfg.Record) bool {
+	return len(record.Tuples) > 0 && len(record.Tuples[0].Attributes) > 0 && record.Tuples[0].Attributes[0].Name == "identity"
+}
+
+// Prefix for identifiers taken from the environment, as per EnvFallback
+const EnvPrefix = "GEN_"
+
+// Look up an identifier in the environment, as GEN_name
+// Names which can't be variables, such as X-Region, may also be given as GEN_X_Region
+func LookupEnv(name string) (string, bool) {
+	if value, ok := os.LookupEnv(EnvPrefix + name); ok {
+		return value, true
+	}
+
+	sanitized := strings.Map(func(r rune) rune {
+		if r == '_' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+
+	return os.LookupEnv(EnvPrefix + sanitized)
+}
@@ -0,0 +1,354 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package generator
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/seh-msft/cfg"
+	"github.com/seh-msft/openapi"
+)
+
+// Response is what a replayed request was answered with
+type Response struct {
+	Status           string
+	StatusCode       int
+	Proto            string
+	ProtoMajor       int
+	ProtoMinor       int
+	Header           http.Header
+	Body             string
+	ContentLength    int64
+	TransferEncoding []string
+	Close            bool
+	Uncompressed     bool
+	TLS              *tls.ConnectionState
+	Latency          time.Duration // Time taken to receive the response
+	Failed           []string      // Why hooks failed the response, if they did
+}
+
+// Set pairs a request and response for output formatting
+type Set struct {
+	*Request
+	*Response
+}
+
+// Signer computes signature headers from a request's final bytes, just before it is sent
+type Signer interface {
+	Sign(req *http.Request) error
+}
+
+// Most requests a replay may have in flight at once
+const MaxConcurrency = 32
+
+// Pacing bounds how requests are replayed
+type Pacing struct {
+	Concurrency int           // Requests in flight at once, 1 if not set
+	Timeout     time.Duration // Longest a request may take, including its body, 0 for no limit
+	Rate        float64       // Requests started per second, 0 for no limit
+	BodyLimit   int           // Bytes of each response body kept, 0 to keep all
+	Proto       string        // Scheme to replay with
+}
+
+// Check pacing is within reason
+func (p Pacing) Check() error {
+	switch {
+	case p.Concurrency < 0 || p.Concurrency > MaxConcurrency:
+		return errors.New("concurrency must not be negative or more than 32, 0 replaying one at a time")
+	case p.Timeout < 0:
+		return errors.New("timeout must not be negative")
+	case p.Rate < 0:
+		return errors.New("rate must not be negative")
+	case p.BodyLimit < 0:
+		return errors.New("bodylimit must not be negative")
+	}
+
+	return nil
+}
+
+// Replayer sends requests to their targets
+type Replayer struct {
+	Pacing
+	Transport http.RoundTripper // nil for the default
+	Signer    Signer            // Signs each request just before it's sent, if set
+	Scrub     func(*Response)   // Removes secrets from each response, if set
+	Hooks     []Hook            // Run in order before each request, after any changes of ours and before signing, and after each response
+}
+
+// HookError is a hook failing a request before it's sent, rather than the target failing to answer it
+type HookError struct {
+	Err error
+}
+
+func (e *HookError) Error() string {
+	return "hook failed request → " + e.Err.Error()
+}
+
+func (e *HookError) Unwrap() error {
+	return e.Err
+}
+
+// SignError is the Signer failing to sign a request, which is then never sent
+type SignError struct {
+	Err error
+}
+
+func (e *SignError) Error() string {
+	return "could not sign request → " + e.Err.Error()
+}
+
+func (e *SignError) Unwrap() error {
+	return e.Err
+}
+
+// Replay a request, failing if it can't be made or takes longer than the timeout
+func (r *Replayer) Send(req *http.Request) (Response, error) {
+	req.RequestURI = ""
+	req.URL.Scheme = r.Proto
+	req.URL.Host = req.Host
+
+	client := &http.Client{Transport: r.Transport, Timeout: r.Timeout}
+
+	for _, hook := range r.Hooks {
+		err := hook.Before(req)
+		if err != nil {
+			return Response{}, &HookError{err}
+		}
+	}
+
+	// Signatures cover the final request
+	if r.Signer != nil {
+		err := r.Signer.Sign(req)
+		if err != nil {
+			return Response{}, &SignError{err}
+		}
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return Response{}, err
+	}
+	defer resp.Body.Close()
+
+	// Timing includes receiving the body, which must also arrive in time
+	var body bytes.Buffer
+	_, err = body.ReadFrom(resp.Body)
+	if e, ok := err.(net.Error); ok && e.Timeout() {
+		return Response{}, err
+	}
+
+	out := Response{
+		Status:           resp.Status,
+		StatusCode:       resp.StatusCode,
+		Proto:            resp.Proto,
+		ProtoMajor:       resp.ProtoMajor,
+		ProtoMinor:       resp.ProtoMinor,
+		Header:           resp.Header,
+		ContentLength:    resp.ContentLength,
+		TransferEncoding: resp.TransferEncoding,
+		Close:            resp.Close,
+		Uncompressed:     resp.Uncompressed,
+		Body:             body.String(),
+		Latency:          time.Since(start),
+	}
+	if r.Scrub != nil {
+		r.Scrub(&out)
+	}
+
+	// Hooks see responses as results will
+	for _, hook := range r.Hooks {
+		err := hook.After(req, &out)
+		if err != nil {
+			out.Failed = append(out.Failed, err.Error())
+		}
+	}
+
+	return out, nil
+}
+
+// Replay requests as paced, calling done with each response as it lands
+// Done is called in the order of requests, never concurrently, the first request to fail, or the context ending, stops the rest from starting
+func (r *Replayer) Replay(ctx context.Context, requests []*Request, done func(*Request, Response)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	feed := make(chan *Request)
+	go func() {
+		defer close(feed)
+		for _, request := range requests {
+			select {
+			case feed <- request:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return r.ReplayFrom(ctx, feed, done)
+}
+
+// Replay requests as Replay does, as they arrive on a channel, until it's closed
+// The sender should stop once ReplayFrom returns, such as by ending the context, as nothing more is received
+func (r *Replayer) ReplayFrom(ctx context.Context, requests <-chan *Request, done func(*Request, Response)) error {
+	workers := r.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+
+	var tick <-chan time.Time
+	if r.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / r.Rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	slots := make(chan struct{}, workers)
+
+	// Responses which land before those of earlier requests wait their turn
+	// Done is called from a goroutine of its own, without the lock, so a slow one, such as retrying, holds up no worker
+	type result struct {
+		request  *Request
+		response Response
+	}
+	pending := make(map[int]result)
+	landed := sync.NewCond(&mu)
+	finished := false
+	delivered := make(chan struct{})
+	go func() {
+		defer close(delivered)
+		for next := 0; ; next++ {
+			mu.Lock()
+			res, ok := pending[next]
+			for !ok && !finished {
+				landed.Wait()
+				res, ok = pending[next]
+			}
+			delete(pending, next)
+			mu.Unlock()
+
+			// A request which failed leaves a gap nothing after is delivered past
+			if !ok {
+				return
+			}
+			done(res.request, res.response)
+		}
+	}()
+
+	i := 0
+	for request := range requests {
+		// The first request goes at once, the rest wait their tick
+		i++
+		if tick != nil && i > 1 {
+			select {
+			case <-tick:
+			case <-ctx.Done():
+			}
+		}
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+
+		mu.Lock()
+		if firstErr == nil && ctx.Err() != nil {
+			firstErr = ctx.Err()
+		}
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed {
+			break
+		}
+
+		wg.Add(1)
+		go func(n int, request *Request) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			resp, err := r.Send(request.Request.WithContext(ctx))
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			if r.BodyLimit > 0 && len(resp.Body) > r.BodyLimit {
+				resp.Body = resp.Body[:r.BodyLimit]
+			}
+
+			pending[n] = result{request, resp}
+			landed.Signal()
+		}(i-1, request)
+	}
+
+	wg.Wait()
+	mu.Lock()
+	finished = true
+	landed.Signal()
+	mu.Unlock()
+	<-delivered
+
+	// The context may have ended while waiting for a request
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+
+	return firstErr
+}
+
+// Pipe builds requests and replays each as soon as it's built, as Build and Replay would, rather than building them all first
+// Returns the parameters missed and how often, and how many requests were possible
+func (r *Replayer) Pipe(ctx context.Context, api openapi.API, db cfg.Cfg, opts Options, done func(*Request, Response)) (map[string]uint64, uint64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		missed   map[string]uint64
+		possible uint64
+		buildErr error
+	)
+	feed := make(chan *Request)
+	go func() {
+		defer close(feed)
+		missed, possible, buildErr = Build(ctx, api, db, opts, func(request *Request) error {
+			select {
+			case feed <- request:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+
+	err := r.ReplayFrom(ctx, feed, done)
+
+	// Stop building, then wait for the builder to finish
+	cancel()
+	for range feed {
+	}
+
+	if err != nil {
+		return nil, 0, err
+	}
+	if buildErr != nil {
+		return nil, 0, buildErr
+	}
+
+	return missed, possible, nil
+}
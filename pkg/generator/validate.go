@@ -0,0 +1,88 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package generator
+
+import (
+	"context"
+	"sort"
+	"strconv"
+)
+
+// Validate responses against the API spec, returning the suspicious and the conformant
+// There's an entry per response the spec expects of a request: suspicious if the response is that one, otherwise conformant
+// A response a hook failed is suspicious, if not already
+// Each is in the order of requests, then of expected codes, which aren't replayed are left out
+func Validate(ctx context.Context, requests []*Request, results map[*Request]*Response) ([]Set, []Set, error) {
+	var sus []Set
+	var ok []Set
+
+	// If replayed, compare results to specification
+	for _, request := range requests {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		response, replayed := results[request]
+		if !replayed {
+			continue
+		}
+
+		// Responses ⇒ ["200"]"some kind of reason"
+		var codes []string
+		for expected := range request.Method.Responses {
+			codes = append(codes, expected)
+		}
+		sort.Strings(codes)
+
+		matched := false
+		for _, expected := range codes {
+			eint, err := strconv.Atoi(expected)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			// Check expected vs reality
+			// If we get an expected result, this may be a permission violation
+			if eint == response.StatusCode {
+				sus = append(sus, Set{request, response})
+				matched = true
+			} else {
+				ok = append(ok, Set{request, response})
+			}
+		}
+		if !matched && len(response.Failed) > 0 {
+			sus = append(sus, Set{request, response})
+		}
+	}
+
+	return sus, ok, nil
+}
+
+// Judge a single response against the API spec for its request, for a verdict per request as results stream
+// Returns true if the response is suspicious, as it is if Validate finds it so for any expected code
+func Judge(request *Request, response *Response) (bool, error) {
+	if len(response.Failed) > 0 {
+		return true, nil
+	}
+
+	// Responses ⇒ ["200"]"some kind of reason"
+	for expected := range request.Method.Responses {
+		eint, err := strconv.Atoi(expected)
+		if err != nil {
+			return false, err
+		}
+
+		// Check expected vs reality
+		// If we get an expected result, this may be a permission violation
+		// TODO - options/modes for what qualifies as a permission violation
+		// For now, employ a heuristic
+		if eint == response.StatusCode {
+			// Status code matches a known response
+			return true, nil
+		}
+	}
+
+	// We don't expect the response received
+	// TODO - better detection heuristics/options for abnormal responses
+	return false, nil
+}
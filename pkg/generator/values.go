@@ -0,0 +1,125 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package generator
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Functions available to db value templates, such as `key={{uuid}}`
+var valueFuncs = template.FuncMap{
+	"uuid":    randomUUID,
+	"now":     now,
+	"randint": randint,
+	"concat":  concat,
+	"faker":   Fake,
+	"upper":   strings.ToUpper,
+	"lower":   strings.ToLower,
+
+	// Layouts for now, so `{{now rfc3339}}` reads naturally
+	"rfc3339":   func() string { return time.RFC3339 },
+	"rfc3339ms": func() string { return "2006-01-02T15:04:05.000Z07:00" },
+	"rfc1123":   func() string { return time.RFC1123 },
+	"date":      func() string { return "2006-01-02" },
+	"unix":      func() string { return "unix" },
+	"unixms":    func() string { return "unixms" },
+}
+
+// The most a value template may produce, and the most parsed templates kept
+const (
+	MaxValueLength = 65536
+	maxTemplates   = 1024
+)
+
+// Parsed value templates, by their text, up to maxTemplates of them
+var (
+	valueTemplates   = map[string]*template.Template{}
+	valueTemplatesMu sync.Mutex
+)
+
+// A writer which refuses to grow past its limit
+type limitedBuilder struct {
+	strings.Builder
+	limit int
+}
+
+func (b *limitedBuilder) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > b.limit {
+		return 0, fmt.Errorf("value is longer than %d bytes", b.limit)
+	}
+
+	return b.Builder.Write(p)
+}
+
+// The current time in a layout, RFC 3339 by default
+func now(layout ...string) string {
+	t := time.Now().UTC()
+	if len(layout) < 1 {
+		return t.Format(time.RFC3339)
+	}
+
+	switch layout[0] {
+	case "unix":
+		return fmt.Sprint(t.Unix())
+	case "unixms":
+		return fmt.Sprint(t.UnixNano() / int64(time.Millisecond))
+	}
+
+	return t.Format(layout[0])
+}
+
+// Random integer in [min, max]
+func randint(min, max int) (int, error) {
+	if max < min {
+		return 0, fmt.Errorf("randint %d %d: max is less than min", min, max)
+	}
+
+	return min + randInt(max-min+1), nil
+}
+
+// Concatenate arguments of any kind
+func concat(args ...interface{}) string {
+	var b strings.Builder
+	for _, arg := range args {
+		fmt.Fprint(&b, arg)
+	}
+
+	return b.String()
+}
+
+// Execute a value containing {{ }} as a template, others are as they are
+func Evaluate(value string) (string, error) {
+	if !strings.Contains(value, "{{") {
+		return value, nil
+	}
+
+	valueTemplatesMu.Lock()
+	t, ok := valueTemplates[value]
+	valueTemplatesMu.Unlock()
+	if !ok {
+		var err error
+		t, err = template.New("value").Funcs(valueFuncs).Parse(value)
+		if err != nil {
+			return "", err
+		}
+
+		valueTemplatesMu.Lock()
+		if len(valueTemplates) < maxTemplates {
+			valueTemplates[value] = t
+		}
+		valueTemplatesMu.Unlock()
+	}
+
+	b := limitedBuilder{limit: MaxValueLength}
+	err := t.Execute(&b, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
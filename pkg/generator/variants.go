@@ -0,0 +1,120 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package generator
+
+import (
+	"strings"
+
+	"github.com/seh-msft/openapi"
+)
+
+// Find the schema of a method's JSON body
+func bodySchema(api openapi.API, method *openapi.Method) (openapi.Type, bool) {
+	// TODO - break out different formats
+	ref := method.RequestBody.Content["application/json"]["schema"].Ref
+	// We get #/components/schemas/ as a prefix sometimes
+	refLess := strings.TrimPrefix(ref, "#/components/schemas/")
+
+	// All types in the schema table
+	for typeName, t := range api.Components["schemas"] {
+
+		// Properties are elements in the body
+		for _, property := range t.Properties {
+			schema := property.Items
+			if schema.Ref == ref || schema.Ref == refLess || typeName == ref || typeName == refLess {
+				// We found our type ref
+				return t, true
+			}
+		}
+	}
+
+	return openapi.Type{}, false
+}
+
+// Variants of a request, as per Cartesian and Sequence
+func variants(choices [][]string, opts Options) [][]int {
+	switch {
+	case opts.Cartesian > 0:
+		return combinations(choices, opts.Cartesian)
+	case opts.Sequence:
+		return sequences(choices)
+	}
+
+	return combinations(choices, 1)
+}
+
+// Sequences of parameter values, the nth of each (wrapping around) for as many as the longest has
+// Parameters without choices are -1
+func sequences(choices [][]string) [][]int {
+	n := 1
+	for _, values := range choices {
+		if len(values) > n {
+			n = len(values)
+		}
+	}
+
+	var out [][]int
+	for v := 0; v < n; v++ {
+		combination := make([]int, len(choices))
+		for i, values := range choices {
+			combination[i] = -1
+			if len(values) > 0 {
+				combination[i] = v % len(values)
+			}
+		}
+		out = append(out, combination)
+	}
+
+	return out
+}
+
+// Label a variant by the values of its multi-valued parameters, such as "tenant=t2, X-Region=us"
+func variantLabel(params []openapi.Parameter, choices [][]string, combination []int) string {
+	var labels []string
+	for i, parameter := range params {
+		if len(choices[i]) > 1 && combination[i] >= 0 {
+			labels = append(labels, parameter.Name+"="+choices[i][combination[i]])
+		}
+	}
+
+	return strings.Join(labels, ", ")
+}
+
+// Combinations of parameter values, as indices into each parameter's choices
+// Without a cap over one, only the first value of each is taken
+// Parameters without choices are -1
+func combinations(choices [][]string, cap int) [][]int {
+	first := make([]int, len(choices))
+	for i, values := range choices {
+		if len(values) < 1 {
+			first[i] = -1
+		}
+	}
+	out := [][]int{first}
+
+	// Count through the cross product, the last parameter fastest
+	for current := first; len(out) < cap; {
+		next := append([]int{}, current...)
+		i := len(next) - 1
+		for ; i >= 0; i-- {
+			if next[i] < 0 {
+				continue
+			}
+			next[i]++
+			if next[i] < len(choices[i]) {
+				break
+			}
+			next[i] = 0
+		}
+		if i < 0 {
+			// Wrapped around, we've seen them all
+			break
+		}
+
+		out = append(out, next)
+		current = next
+	}
+
+	return out
+}
@@ -0,0 +1,30 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Print the replay plan, as per -dryrun: each request in the order it would be sent, and where its values came from
+func printPlan(w io.Writer, requests []*Request) {
+	host := ""
+	if len(requests) > 0 {
+		host = " to " + requests[0].Host
+	}
+	fmt.Fprintf(w, "Replay plan: %d requests%s, none sent\n", len(requests), host)
+
+	for i, request := range requests {
+		fmt.Fprintf(w, "\n%4d  %-7s %s%s\n", i+1, strings.ToUpper(request.Request.Method), redact(request.URL.String()), request.VariantSuffix())
+		for _, source := range request.Sources {
+			if source.Value == "" && source.From == "random" {
+				fmt.Fprintf(w, "      %-6s %s (random)\n", source.In, source.Name)
+				continue
+			}
+			fmt.Fprintf(w, "      %-6s %s = %s (%s)\n", source.In, source.Name, redact(source.Value), source.From)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"errors"
+
+	"github.com/seh-msft/cfg"
+)
+
+// Credentials and headers for every request, whether a run's or a -listen job's
+type Preparation struct {
+	Headers       HeaderFlags // As per -H
+	Security      Security    // The spec's security schemes and requirements
+	Authorization string      // Authorization header value for bearer, basic, and other HTTP schemes
+	APIKey        string      // Key for apiKey schemes, as per -apikey
+	Cookie        string      // As per -cookie
+}
+
+// Ready built requests to go out, as a run and a -listen job both do
+// Headers, correlation ids, and the user agent go on every request, then credentials unless opts.NoAuth strips them
+func prepare(requests []*Request, db cfg.Cfg, opts Options, title string, p Preparation) error {
+	// Gateways may want headers the spec doesn't say, such as for routing
+	err := applyHeaders(requests, p.Headers, db)
+	if err != nil {
+		return errors.New("could not apply headers → " + err.Error())
+	}
+
+	// Each result can be found in the target's logs, and its owners can tell who sent it
+	if !*noCorrelate {
+		correlate(requests)
+	}
+	applyUserAgent(requests, *userAgent)
+
+	if opts.NoAuth {
+		// Credentials from the db or spec parameters are removed too
+		stripAuth(requests)
+		return nil
+	}
+
+	// Session cookies go on every request
+	err = applyCookies(requests, p.Cookie, db, opts.Options, title)
+	if err != nil {
+		return errors.New("could not apply cookies → " + err.Error())
+	}
+
+	// Credentials go where each operation's security requirements say
+	err = applySecurity(requests, p.Security, db, opts.Options, title, p.Authorization, p.APIKey)
+	if err != nil {
+		return errors.New("could not apply credentials → " + err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,171 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Profile is a named set of options server callers may refer to, rather than send them all
+type Profile struct {
+	API           string   `json:"api"`           // URL for the OpenAPI specification
+	CfgPath       string   `json:"cfgpath"`       // URL, or file on the server, for the db
+	Target        string   `json:"target"`        // Hostname to replay built requests to
+	Auth          string   `json:"auth"`          // Where tokens come from: none, authfile:file, or aad:resource
+	IgnoreMethods []string `json:"ignoremethods"` // HTTP methods to not build
+
+	tokens TokenSource
+}
+
+// Profiles from -profiles, by name
+var profiles map[string]*Profile
+
+// Load a JSON or YAML file of profiles, as {"profiles": {"name": {...}}}
+func loadProfiles(name string) (map[string]*Profile, error) {
+	buf, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	// YAML becomes JSON, as YAML dbs do
+	switch strings.ToLower(path.Ext(name)) {
+	case ".yaml", ".yml":
+		var doc map[string]interface{}
+		err = yaml.Unmarshal(buf, &doc)
+		if err != nil {
+			return nil, err
+		}
+		buf, err = json.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var file struct {
+		Profiles map[string]*Profile `json:"profiles"`
+	}
+	err = json.Unmarshal(buf, &file)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, p := range file.Profiles {
+		if p == nil {
+			return nil, errors.New("profile " + name + " is empty")
+		}
+
+		p.tokens, err = profileTokens(p.Auth)
+		if err != nil {
+			return nil, errors.New("profile " + name + ": " + err.Error())
+		}
+	}
+
+	return file.Profiles, nil
+}
+
+// The token source for a profile's auth, nil for none
+func profileTokens(auth string) (TokenSource, error) {
+	kind := strings.SplitN(auth, ":", 2)
+	switch {
+	case auth == "" || auth == "none":
+		return nil, nil
+	case len(kind) < 2 || kind[1] == "":
+		return nil, errors.New("auth must be none, authfile:file, or aad:resource, not " + auth)
+	case kind[0] == "authfile":
+		return &AuthFile{Name: kind[1]}, nil
+	case kind[0] == "aad":
+		return &tokenCache{source: &AAD{Resource: kind[1], Credential: *aadCred}}, nil
+	}
+
+	return nil, errors.New("unknown auth provider " + kind[0])
+}
+
+// The server's own tokens go only to a target a profile names, or one -allowhosts names
+var errServerToken = errors.New("the server's own credentials only go to a profile's target or one -allowhosts names, give auth or noauth")
+
+// Fill in what a caller didn't give from their profile
+func (p *Profile) apply(ctx context.Context, opts *JobOptions) error {
+	if opts.API == "" && opts.spec == nil {
+		opts.API = p.API
+	}
+	ownTarget := opts.Target != ""
+	if opts.Target == "" {
+		opts.Target = p.Target
+	}
+	opts.IgnoreMethods = append(opts.IgnoreMethods, p.IgnoreMethods...)
+
+	// Only a profile may name a db on the server itself
+	if opts.Cfg == "" && opts.CfgPath == "" && p.CfgPath != "" {
+		if strings.Contains(p.CfgPath, "://") {
+			opts.CfgPath = p.CfgPath
+		} else {
+			buf, err := ioutil.ReadFile(p.CfgPath)
+			if err != nil {
+				return err
+			}
+			opts.Cfg, opts.cfgName = string(buf), p.CfgPath
+		}
+		opts.trusted = true
+	}
+
+	// Callers' own credentials win
+	if opts.Auth != "" || opts.Cookie != "" || opts.NoAuth {
+		return nil
+	}
+	if p.tokens == nil {
+		opts.NoAuth = true
+		return nil
+	}
+
+	// Callers may not send the profile's tokens where they like
+	if (ownTarget || opts.Target == "") && !egress.allowListed(ctx, opts.Target) {
+		return errServerToken
+	}
+
+	token, err := p.tokens.Token()
+	if err != nil {
+		return err
+	}
+	opts.Auth = token
+
+	return nil
+}
+
+// tokenCache keeps a token until shortly before it expires
+type tokenCache struct {
+	source TokenSource
+
+	mu    sync.Mutex
+	token string
+}
+
+// Token from the cache, or a new one if it's lapsing
+func (t *tokenCache) Token() (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if exp, ok := tokenExpiry(t.token); ok && time.Now().Add(expirySkew).Before(exp) {
+		return t.token, nil
+	}
+
+	token, err := t.source.Token()
+	if err != nil {
+		return "", err
+	}
+	if !*noRedact {
+		redactSecret(token)
+	}
+	t.token = token
+
+	return token, nil
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/seh-msft/generator/pkg/generator"
+)
+
+// Width of the progress bar, and most of the request shown, in characters
+const (
+	barWidth     = 24
+	currentWidth = 48
+)
+
+// Progress shows how far a replay has got on stderr, as n/total, ETA, and the request last sent
+// On a terminal it's redrawn in place, otherwise a line is written every so often, such as for CI logs
+// A nil Progress shows nothing
+type Progress struct {
+	mu       sync.Mutex
+	w        io.Writer
+	terminal bool
+	total    int
+	done     int
+	current  string
+	started  time.Time
+	stop     chan struct{}
+	stopped  sync.WaitGroup
+}
+
+// Show progress of replaying total requests, until Finish
+func newProgress(total int) *Progress {
+	p := &Progress{
+		w:        os.Stderr,
+		terminal: isTerminal(os.Stderr),
+		total:    total,
+		started:  time.Now(),
+		stop:     make(chan struct{}),
+	}
+
+	// A hung request shows as time passing with nothing done
+	every := 10 * time.Second
+	if p.terminal {
+		every = 250 * time.Millisecond
+	}
+
+	p.stopped.Add(1)
+	go func() {
+		defer p.stopped.Done()
+		tick := time.NewTicker(every)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				p.draw()
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+
+	return p
+}
+
+// A hook noting each request as it's sent
+func (p *Progress) hook() generator.Hook {
+	return generator.HookFuncs{
+		BeforeFunc: func(req *http.Request) error {
+			p.mu.Lock()
+			p.current = req.Method + " " + req.URL.Path
+			p.mu.Unlock()
+			return nil
+		},
+	}
+}
+
+// Count more requests to replay, such as those built while replaying
+func (p *Progress) Add(n int) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	p.total += n
+	p.mu.Unlock()
+}
+
+// Count a request done
+func (p *Progress) Done() {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	p.done++
+	p.mu.Unlock()
+}
+
+// Stop showing progress, clearing the line on a terminal
+func (p *Progress) Finish() {
+	if p == nil {
+		return
+	}
+
+	close(p.stop)
+	p.stopped.Wait()
+
+	if p.terminal {
+		fmt.Fprint(p.w, "\r\033[K")
+		return
+	}
+	p.draw()
+}
+
+// Write the progress line
+func (p *Progress) draw() {
+	p.mu.Lock()
+	done, total, current := p.done, p.total, p.current
+	p.mu.Unlock()
+
+	current = redact(current)
+	if len(current) > currentWidth {
+		current = current[:currentWidth-1] + "…"
+	}
+
+	elapsed := time.Since(p.started)
+	eta := "?"
+	if done > 0 {
+		eta = (elapsed / time.Duration(done) * time.Duration(total-done)).Round(time.Second).String()
+	}
+	percent := 100
+	if total > 0 {
+		percent = 100 * done / total
+	}
+
+	line := fmt.Sprintf("%d/%d %d%% elapsed %s ETA %s %s", done, total, percent, elapsed.Round(time.Second), eta, current)
+	if !p.terminal {
+		fmt.Fprintln(p.w, "progress:", line)
+		return
+	}
+
+	filled := barWidth * percent / 100
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", barWidth-filled)
+	fmt.Fprintf(p.w, "\r\033[K[%s] %s", bar, line)
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimit bounds how often each caller may ask for generation, as a bucket of tokens per caller
+type RateLimit struct {
+	PerMinute float64 // Tokens added to a bucket each minute
+	Burst     int     // Most tokens a bucket holds
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+// Tokens a caller has left, as of when
+type bucket struct {
+	tokens float64
+	when   time.Time
+}
+
+// Take a token for a caller, or say how long until there's one
+func (l *RateLimit) take(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.buckets == nil {
+		l.buckets = make(map[string]*bucket)
+	}
+	perSecond := l.PerMinute / 60
+
+	// Forget callers whose buckets have filled up again
+	if now.Sub(l.swept) > time.Minute {
+		for c, b := range l.buckets {
+			if b.tokens+now.Sub(b.when).Seconds()*perSecond >= float64(l.Burst) {
+				delete(l.buckets, c)
+			}
+		}
+		l.swept = now
+	}
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: float64(l.Burst), when: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(float64(l.Burst), b.tokens+now.Sub(b.when).Seconds()*perSecond)
+	b.when = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / perSecond * float64(time.Second))
+	}
+	b.tokens--
+
+	return true, 0
+}
+
+// Wrap a handler so callers asking for generation too often are refused
+// Callers are told apart by who they authenticated as, otherwise by address
+func (l *RateLimit) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || (route(r) != "/generator" && route(r) != "/jobs") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		client := caller(r)
+		if client == "" {
+			client, _, _ = net.SplitHostPort(r.RemoteAddr)
+		}
+
+		ok, wait := l.take(client, time.Now())
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeError(w, r, &apiError{http.StatusTooManyRequests, fmt.Sprint("Error: too many requests, limit is ", l.PerMinute, " a minute"), false})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
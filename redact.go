@@ -0,0 +1,165 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Replaces secrets in all output
+const redacted = "[REDACTED]"
+
+// Patterns for secrets redacted by default
+// If a pattern has a subexpression, only the first subexpression is redacted
+var defaultRedactions = []string{
+	// Credential-bearing headers in raw HTTP
+	`(?im)^(?:authorization|proxy-authorization|cookie|set-cookie|x-api-key|api-key|x-signature):[ \t]*([^\r\n]+)`,
+	// Bearer tokens anywhere
+	`(?i)\bbearer[ \t]+([A-Za-z0-9\-._~+/]+=*)`,
+	// JSON Web Tokens anywhere
+	`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`,
+}
+
+// Headers whose values are always redacted
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+	"X-Api-Key":           true,
+	"Api-Key":             true,
+	"X-Signature":         true,
+}
+
+// Shortest literal secret redacted wherever it occurs
+const minSecret = 8
+
+// Compiled redaction patterns, nil if redaction is disabled
+// Secrets may be added while requests are in flight, such as by -authfile in server mode
+var (
+	redactions   []*regexp.Regexp
+	redacting    = make(map[string]bool) // Literal secrets among them
+	redactionsMu sync.RWMutex
+)
+
+// RedactFlags are repeatable -redact patterns
+type RedactFlags []string
+
+func (r *RedactFlags) String() string {
+	return strings.Join(*r, ",")
+}
+
+func (r *RedactFlags) Set(v string) error {
+	*r = append(*r, v)
+	return nil
+}
+
+// Compile the default patterns, the user's patterns, and literal secrets
+func initRedactions(patterns []string, secrets ...string) error {
+	redactionsMu.Lock()
+	redactions = nil
+	redacting = make(map[string]bool)
+	redactionsMu.Unlock()
+
+	for _, pattern := range append(append([]string{}, defaultRedactions...), patterns...) {
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			return err
+		}
+		redactionsMu.Lock()
+		redactions = append(redactions, regex)
+		redactionsMu.Unlock()
+	}
+
+	// Secrets we were handed should never be repeated, wherever they occur
+	// Very short secrets would redact ordinary text, so they're left to the patterns
+	for _, secret := range secrets {
+		redactSecret(secret)
+	}
+
+	return nil
+}
+
+// Redact a secret from here on, such as a refreshed token
+func redactSecret(secret string) {
+	if len(secret) < minSecret {
+		return
+	}
+
+	redactionsMu.Lock()
+	defer redactionsMu.Unlock()
+	if redacting[secret] {
+		return
+	}
+	redacting[secret] = true
+
+	// Never append in place, readers may hold the old slice
+	regex := regexp.MustCompile(regexp.QuoteMeta(secret))
+	redactions = append(redactions[:len(redactions):len(redactions)], regex)
+}
+
+// Redact a credential as it's put on a request, wherever it came from, unless -noredact
+func redactCredential(value string) {
+	if !*noRedact {
+		redactSecret(value)
+	}
+}
+
+// The current redaction patterns
+func currentRedactions() []*regexp.Regexp {
+	redactionsMu.RLock()
+	defer redactionsMu.RUnlock()
+	return redactions
+}
+
+// Redact secrets from a string
+func redact(s string) string {
+	for _, regex := range currentRedactions() {
+		matches := regex.FindAllStringSubmatchIndex(s, -1)
+		if matches == nil {
+			continue
+		}
+
+		var b strings.Builder
+		last := 0
+		for _, m := range matches {
+			// Redact the first subexpression if there is one, else the whole match
+			start, end := m[0], m[1]
+			if len(m) > 2 && m[2] >= 0 {
+				start, end = m[2], m[3]
+			}
+
+			b.WriteString(s[last:start])
+			b.WriteString(redacted)
+			last = end
+		}
+		b.WriteString(s[last:])
+
+		s = b.String()
+	}
+
+	return s
+}
+
+// Redact secrets from a copy of HTTP headers
+func redactHeader(h http.Header) http.Header {
+	if h == nil || currentRedactions() == nil {
+		return h
+	}
+
+	out := make(http.Header, len(h))
+	for name, values := range h {
+		for _, v := range values {
+			if sensitiveHeaders[http.CanonicalHeaderKey(name)] {
+				v = redacted
+			}
+			out[name] = append(out[name], redact(v))
+		}
+	}
+
+	return out
+}
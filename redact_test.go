@@ -0,0 +1,86 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	err := initRedactions([]string{`(?i)password=([^&\s]+)`}, "flagsecret123", "short")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer initRedactions(nil)
+
+	// Credentials from a db, as auth.go registers them when attaching them
+	redactCredential("dbapikey-0123456789")
+	redactCookies("session=cookievalue42; theme=dark")
+
+	tests := []struct {
+		in, want string
+	}{
+		// Credential-bearing headers
+		{"Authorization: Basic dXNlcjpwYXNz\r\n", "Authorization: [REDACTED]\r\n"},
+		{"proxy-authorization:Negotiate abc\n", "proxy-authorization:[REDACTED]\n"},
+		{"X-API-Key: k\r\nAccept: */*\r\n", "X-API-Key: [REDACTED]\r\nAccept: */*\r\n"},
+		{"Set-Cookie: a=b; Path=/\n", "Set-Cookie: [REDACTED]\n"},
+
+		// Bearer tokens and JWTs anywhere
+		{`{"token": "Bearer abc.def-ghi"}`, `{"token": "Bearer [REDACTED]"}`},
+		{"got eyJhbGciOi.eyJzdWIiOi.c2ln back", "got [REDACTED] back"},
+
+		// User patterns redact their subexpression
+		{"GET /login?user=a&password=hunter2 HTTP/1.1", "GET /login?user=a&password=[REDACTED] HTTP/1.1"},
+
+		// Literal secrets, wherever they are
+		{"GET /items?key=flagsecret123 HTTP/1.1", "GET /items?key=[REDACTED] HTTP/1.1"},
+		{"GET /items?api_key=dbapikey-0123456789", "GET /items?api_key=[REDACTED]"},
+		{`{"echo": "cookievalue42"}`, `{"echo": "[REDACTED]"}`},
+
+		// Short secrets would redact ordinary text
+		{"a short walk", "a short walk"},
+		{"theme=dark", "theme=dark"},
+		{"GET /items HTTP/1.1", "GET /items HTTP/1.1"},
+	}
+
+	for _, test := range tests {
+		got := redact(test.in)
+		if got != test.want {
+			t.Errorf("redact(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestRedactHeader(t *testing.T) {
+	err := initRedactions(nil, "flagsecret123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer initRedactions(nil)
+
+	h := http.Header{
+		"Authorization": {"short"},
+		"Cookie":        {"a=b"},
+		"X-Trace":       {"flagsecret123"},
+		"Accept":        {"application/json"},
+	}
+	got := redactHeader(h)
+
+	want := map[string]string{
+		"Authorization": redacted,
+		"Cookie":        redacted,
+		"X-Trace":       redacted,
+		"Accept":        "application/json",
+	}
+	for name, value := range want {
+		if got.Get(name) != value {
+			t.Errorf("redactHeader: %s = %q, want %q", name, got.Get(name), value)
+		}
+	}
+	if h.Get("Authorization") != "short" {
+		t.Error("redactHeader changed the headers it was given")
+	}
+}
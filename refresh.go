@@ -0,0 +1,184 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/seh-msft/generator/pkg/generator"
+)
+
+// TokenSource mints bearer tokens on demand
+type TokenSource interface {
+	Token() (string, error)
+}
+
+// OAuth as a token source, a cached refresh token avoids signing in again
+type oauthSource struct {
+	*OAuth
+	flow string
+}
+
+// Token acquires a token with the configured flow
+func (o *oauthSource) Token() (string, error) {
+	return o.Acquire(o.flow)
+}
+
+// Refresher renews the bearer token when it expires mid-run
+// Requests keep the token they were built with, and are given the current one by Hook as each is sent
+type Refresher struct {
+	Source   TokenSource
+	Token    string              // The token requests were built with
+	Replayer *generator.Replayer // Replays retried requests
+
+	mu      sync.Mutex
+	current string          // The token to send, if it's been renewed
+	issued  map[string]bool // Every token renewed, which requests may have gone out with
+}
+
+// The token to send
+func (r *Refresher) token() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.current == "" {
+		return r.Token
+	}
+	return r.current
+}
+
+// Send a new token from now on
+func (r *Refresher) renew(token string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.issued == nil {
+		r.issued = make(map[string]bool)
+	}
+	r.current = token
+	r.issued[token] = true
+}
+
+// Whether a request's Authorization header is one of our tokens, and which
+func (r *Refresher) ours(header string) (string, bool) {
+	if !strings.HasPrefix(header, "Bearer ") {
+		return "", false
+	}
+	token := strings.TrimPrefix(header, "Bearer ")
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return token, token == r.Token || r.issued[token]
+}
+
+// Hook gives each request bearing one of our tokens the current one, as it's sent
+// Only the request going out is changed, as replay's workers share the rest
+func (r *Refresher) Hook() generator.Hook {
+	return generator.HookFuncs{
+		BeforeFunc: func(req *http.Request) error {
+			if _, ok := r.ours(req.Header.Get("Authorization")); ok {
+				req.Header.Set("Authorization", "Bearer "+r.token())
+			}
+			return nil
+		},
+	}
+}
+
+// Clock skew tolerated when reading token expiry
+const expirySkew = 30 * time.Second
+
+// Expired reports if a response looks like a rejection of our expired token
+// A 401 alone is a legitimate result, the token must have lapsed or the server must say so
+func (r *Refresher) Expired(request *Request, response *Response) bool {
+	if response.StatusCode != 401 {
+		return false
+	}
+
+	// Only requests carrying our token can be helped by a new one
+	sent, ok := r.ours(request.Header.Get("Authorization"))
+	if !ok {
+		return false
+	}
+
+	challenge := strings.ToLower(response.Header.Get("Www-Authenticate"))
+	if strings.Contains(challenge, "invalid_token") || strings.Contains(challenge, "expired") {
+		return true
+	}
+
+	exp, ok := tokenExpiry(sent)
+	return ok && time.Now().Add(expirySkew).After(exp)
+}
+
+// Retry refreshes the token, which requests sent from then on bear, and replays the request once
+// If the refresh fails, the original response stands
+func (r *Refresher) Retry(request *Request, response *Response) (Response, error) {
+	token, err := r.Source.Token()
+	if err != nil {
+		warn("warn: could not refresh token →", err)
+		return *response, nil
+	}
+	if !*noRedact {
+		redactSecret(token)
+	}
+	chat("auth: token expired, refreshed and retrying", request.URL.Path)
+
+	r.renew(token)
+
+	return r.replayAgain(request)
+}
+
+// Reload picks up a new token if the source has one, for sources which are cheap to ask such as -authfile
+func (r *Refresher) Reload() {
+	token, err := r.Source.Token()
+	if err != nil {
+		warn("warn: could not reload token →", err)
+		return
+	}
+
+	if token != r.token() {
+		chat("auth: token changed, sending the new one on remaining requests")
+		r.renew(token)
+	}
+}
+
+// Replay a request whose body was consumed already, with the current token as Hook gives it
+func (r *Refresher) replayAgain(request *Request) (Response, error) {
+	if request.GetBody != nil {
+		body, err := request.GetBody()
+		if err == nil {
+			request.Body = body
+		}
+	}
+
+	return r.Replayer.Send(request.Request)
+}
+
+// Read the expiry of a JWT, ok is false for opaque tokens
+func tokenExpiry(token string) (time.Time, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+
+	buf, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	err = json.Unmarshal(buf, &claims)
+	if err != nil || claims.Exp == 0 {
+		return time.Time{}, false
+	}
+
+	return time.Unix(claims.Exp, 0), true
+}
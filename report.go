@@ -0,0 +1,229 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/seh-msft/openapi"
+)
+
+// Results of earlier runs, as written by -format json or jsonl
+type savedResults struct {
+	requests      []*Request
+	totalPossible uint64
+	missed        map[string]uint64
+	sus, ok       []Set
+}
+
+// Read the results of runs, standard input if none are named
+// Findings are as they were judged, results from several runs are merged
+func readResults(names []string) (*savedResults, error) {
+	if len(names) < 1 {
+		names = []string{"-"}
+	}
+
+	saved := &savedResults{missed: make(map[string]uint64)}
+	for _, name := range names {
+		var r io.Reader = os.Stdin
+		if name != "-" {
+			f, err := os.Open(name)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			r = f
+		}
+
+		err := saved.read(r)
+		if err != nil {
+			return nil, errors.New(name + ": " + err.Error())
+		}
+	}
+
+	return saved, nil
+}
+
+// Read one run's results, a JSON document or JSON Lines ending in the run info
+func (s *savedResults) read(r io.Reader) error {
+	var entries []Entry
+	var server string
+	possible := uint64(0)
+
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for {
+		var v struct {
+			Entry
+			Info *struct {
+				Server   string
+				Missed   map[string]uint64
+				Possible uint64
+			}
+			Conformant []Entry
+			Suspicious []Entry
+		}
+		err := dec.Decode(&v)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case v.Info != nil:
+			server, possible = v.Info.Server, v.Info.Possible
+			for name, n := range v.Info.Missed {
+				s.missed[name] += n
+			}
+			for _, e := range v.Conformant {
+				e.Verdict = "conformant"
+				entries = append(entries, e)
+			}
+			for _, e := range v.Suspicious {
+				e.Verdict = "suspicious"
+				entries = append(entries, e)
+			}
+
+		case v.Method != "":
+			entries = append(entries, v.Entry)
+
+		default:
+			return errors.New("not the results of a run")
+		}
+	}
+
+	for _, e := range entries {
+		request, response := e.result(server)
+		s.requests = append(s.requests, request)
+
+		set := Set{Request: request, Response: response}
+		switch e.Verdict {
+		case "suspicious":
+			s.sus = append(s.sus, set)
+		case "conformant":
+			s.ok = append(s.ok, set)
+		default:
+			return fmt.Errorf("unknown verdict %q for %s %s", e.Verdict, e.Method, e.Path)
+		}
+	}
+
+	// Results which don't say how many requests were possible, such as -format json, count those built
+	if possible < uint64(len(entries)) {
+		possible = uint64(len(entries))
+	}
+	s.totalPossible += possible
+
+	return nil
+}
+
+// The request and response an entry was written from, judged as the entry was
+func (e Entry) result(server string) (*Request, *Response) {
+	// A response the spec expects is suspicious
+	method := &openapi.Method{Responses: make(map[string]openapi.Response)}
+	if e.Verdict == "suspicious" && len(e.Failed) < 1 {
+		method.Responses[strconv.Itoa(e.HTTPCode)] = openapi.Response{}
+	}
+
+	u := &url.URL{Host: server, Path: e.Path}
+	request := &Request{
+		Request: &http.Request{Method: e.Method, URL: u, Host: server, Header: make(http.Header)},
+		Method:  method,
+		Path:    e.Path,
+		Variant: e.Variant,
+	}
+	if e.RequestID != "" {
+		request.Header.Set(requestIDHeader, e.RequestID)
+	}
+	response := &Response{
+		Status:     strconv.Itoa(e.HTTPCode) + " " + http.StatusText(e.HTTPCode),
+		StatusCode: e.HTTPCode,
+		Body:       e.Body,
+		Failed:     e.Failed,
+	}
+
+	return request, response
+}
+
+// Write the results of earlier runs to the outputs, as a run would, and fail on their findings
+func writeReport(out *bufio.Writer, names []string, thresholds []Threshold) {
+	saved, err := readResults(names)
+	if err != nil {
+		fatal("err: could not read results →", err)
+	}
+
+	addOutName()
+
+	for _, sink := range sinks {
+		err := sink.Open(out)
+		if err != nil {
+			fatal("err: could not open output →", err)
+		}
+	}
+
+	for _, set := range saved.results() {
+		for _, sink := range sinks {
+			err := sink.Stream(set.Request, set.Response)
+			if err != nil {
+				die(exitOutput, "err: could not emit result →", err)
+			}
+		}
+	}
+
+	for _, sink := range sinks {
+		err := sink.Results(saved.requests, saved.totalPossible, saved.missed, saved.sus, saved.ok)
+		if err != nil {
+			die(exitOutput, "err: could not emit results →", err)
+		}
+	}
+
+	closeSinks()
+
+	// Results land in the Tests tab of a pipeline
+	if *adoRun != "" {
+		a, err := adoFromEnv()
+		if err != nil {
+			die(exitOutput, "err: could not publish ADO test run →", err)
+		}
+
+		id, err := a.Publish(*adoRun, saved.requests, saved.missed, saved.sus, saved.ok)
+		if err != nil {
+			die(exitOutput, "err: could not publish ADO test run →", err)
+		}
+		chat(fmt.Sprintf("Published ADO test run %d\n", id))
+	}
+
+	// Tell the humans
+	if *notifyHook != "" {
+		link := *notifyLink
+		if link == "" {
+			link = runLink()
+		}
+
+		err := notify(*notifyHook, link, saved.requests, saved.totalPossible, saved.missed, saved.sus, saved.ok)
+		if err != nil {
+			die(exitOutput, "err: could not notify →", err)
+		}
+	}
+
+	// Findings fail the run
+	if !gate(thresholds, metrics(saved.requests, saved.totalPossible, saved.missed, saved.sus, saved.ok)) {
+		stderr.Flush()
+		os.Exit(exitFindings)
+	}
+}
+
+// Results in the order they were read
+func (s *savedResults) results() []Set {
+	f := &Findings{requests: s.requests, sus: s.sus, ok: s.ok}
+	return f.results()
+}
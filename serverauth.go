@@ -0,0 +1,287 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ServerAuth authenticates callers of the server, by API key or Azure AD token
+type ServerAuth struct {
+	Keys     map[string]string // Caller names by key
+	Tenant   string            // Azure AD tenant whose tokens are accepted, if any
+	Audience string            // Audience tokens must be issued for, required with Tenant
+
+	client  *http.Client
+	mu      sync.Mutex
+	jwks    map[string]*rsa.PublicKey // Signing keys by key ID
+	fetched time.Time
+}
+
+// How long Azure AD signing keys are kept before fetching them again
+const jwksLifetime = time.Hour
+
+// Key for the caller in a request's context
+type callerKey struct{}
+
+// The authenticated caller of a request, if any
+func caller(r *http.Request) string {
+	name, _ := r.Context().Value(callerKey{}).(string)
+	return name
+}
+
+// Load API keys from a file of "caller=key" lines
+func loadServerKeys(name string) (map[string]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	keys := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) < 2 || strings.TrimSpace(kv[0]) == "" || strings.TrimSpace(kv[1]) == "" {
+			return nil, fmt.Errorf("line %d is not caller=key", n)
+		}
+		keys[strings.TrimSpace(kv[1])] = strings.TrimSpace(kv[0])
+		if !*noRedact {
+			redactSecret(strings.TrimSpace(kv[1]))
+		}
+	}
+
+	return keys, scanner.Err()
+}
+
+// Wrap a handler so only authenticated callers reach it
+// The splash page at '/' and the service's own description are left open
+func (a *ServerAuth) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/", "/openapi.json", "/docs":
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		name, err := a.authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="generator"`)
+			writeError(w, r, &apiError{http.StatusUnauthorized, "Error: unauthorized → " + err.Error(), false})
+			return
+		}
+
+		auditFor(r).Caller = name
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), callerKey{}, name)))
+	})
+}
+
+// Find who's calling, by X-API-Key or an Authorization bearer key or token
+func (a *ServerAuth) authenticate(r *http.Request) (string, error) {
+	credential := r.Header.Get("X-API-Key")
+	if credential == "" {
+		authorization := r.Header.Get("Authorization")
+		if !strings.HasPrefix(strings.ToLower(authorization), "bearer ") {
+			return "", errors.New("no X-API-Key or Authorization: Bearer header")
+		}
+		credential = strings.TrimSpace(authorization[len("bearer "):])
+	}
+
+	// Tokens are JWTs
+	if a.Tenant != "" && strings.Count(credential, ".") == 2 {
+		return a.validateToken(credential)
+	}
+
+	// Compare every key, so timing says nothing of which is close
+	name := ""
+	for key, caller := range a.Keys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(credential)) == 1 {
+			name = caller
+		}
+	}
+	if name == "" {
+		return "", errors.New("unknown key")
+	}
+
+	return name, nil
+}
+
+// Validate an Azure AD token, returning who it's for
+func (a *ServerAuth) validateToken(token string) (string, error) {
+	parts := strings.Split(token, ".")
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	err := decodeSegment(parts[0], &header)
+	if err != nil {
+		return "", errors.New("malformed token header")
+	}
+	if header.Alg != "RS256" {
+		return "", errors.New("token algorithm must be RS256, not " + header.Alg)
+	}
+
+	key, err := a.signingKey(header.Kid)
+	if err != nil {
+		return "", err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", errors.New("malformed token signature")
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) != nil {
+		return "", errors.New("token signature is invalid")
+	}
+
+	var claims struct {
+		Iss               string          `json:"iss"`
+		Aud               json.RawMessage `json:"aud"`
+		Exp               int64           `json:"exp"`
+		Nbf               int64           `json:"nbf"`
+		Upn               string          `json:"upn"`
+		PreferredUsername string          `json:"preferred_username"`
+		AppID             string          `json:"appid"`
+		Azp               string          `json:"azp"`
+		Oid               string          `json:"oid"`
+	}
+	err = decodeSegment(parts[1], &claims)
+	if err != nil {
+		return "", errors.New("malformed token claims")
+	}
+
+	now := time.Now().Unix()
+	const skew = 300
+	if claims.Exp == 0 {
+		return "", errors.New("token has no expiry")
+	}
+	if now > claims.Exp+skew {
+		return "", errors.New("token has expired")
+	}
+	if claims.Nbf != 0 && now+skew < claims.Nbf {
+		return "", errors.New("token isn't valid yet")
+	}
+
+	issuers := []string{
+		"https://login.microsoftonline.com/" + a.Tenant + "/v2.0",
+		"https://sts.windows.net/" + a.Tenant + "/",
+	}
+	if claims.Iss != issuers[0] && claims.Iss != issuers[1] {
+		return "", errors.New("token is from another issuer → " + claims.Iss)
+	}
+
+	var audiences []string
+	if json.Unmarshal(claims.Aud, &audiences) != nil {
+		var audience string
+		json.Unmarshal(claims.Aud, &audience)
+		audiences = []string{audience}
+	}
+	found := false
+	for _, audience := range audiences {
+		found = found || (audience != "" && audience == a.Audience)
+	}
+	if !found {
+		return "", errors.New("token is for another audience")
+	}
+
+	// Users by name, applications by ID
+	for _, name := range []string{claims.Upn, claims.PreferredUsername, claims.AppID, claims.Azp, claims.Oid} {
+		if name != "" {
+			return name, nil
+		}
+	}
+
+	return "", errors.New("token names no caller")
+}
+
+// The tenant's signing key with an ID, fetched if not known
+func (a *ServerAuth) signingKey(kid string) (*rsa.PublicKey, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if key, ok := a.jwks[kid]; ok && time.Since(a.fetched) < jwksLifetime {
+		return key, nil
+	}
+
+	// Keys roll over, so an unknown ID is worth fetching for, at most once a minute
+	if time.Since(a.fetched) < time.Minute {
+		return nil, errors.New("token is signed with an unknown key")
+	}
+
+	if a.client == nil {
+		a.client = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := a.client.Get("https://login.microsoftonline.com/" + a.Tenant + "/discovery/v2.0/keys")
+	if err != nil {
+		return nil, errors.New("could not fetch signing keys → " + err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("could not fetch signing keys → " + resp.Status)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&set)
+	if err != nil {
+		return nil, errors.New("could not parse signing keys → " + err.Error())
+	}
+
+	a.jwks = make(map[string]*rsa.PublicKey)
+	a.fetched = time.Now()
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+		e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		a.jwks[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+
+	key, ok := a.jwks[kid]
+	if !ok {
+		return nil, errors.New("token is signed with an unknown key")
+	}
+	return key, nil
+}
+
+// Decode a base64url JWT segment as JSON
+func decodeSegment(segment string, v interface{}) error {
+	buf, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(buf, v)
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/seh-msft/generator/pkg/generator"
+)
+
+// Signer computes signature headers from a request's final bytes, just before it is sent
+type Signer = generator.Signer
+
+// Build a signer from -sign: "hmac" or "exec:command args…"
+func newSigner(spec, key, header string) (Signer, error) {
+	switch {
+	case spec == "hmac":
+		if key == "" {
+			return nil, errors.New("hmac signing requires -signkey")
+		}
+		return &hmacSigner{[]byte(key), header}, nil
+
+	case strings.HasPrefix(spec, "exec:"):
+		args := strings.Fields(strings.TrimPrefix(spec, "exec:"))
+		if len(args) < 1 {
+			return nil, errors.New("no command to sign with")
+		}
+		return &execSigner{args}, nil
+	}
+
+	return nil, errors.New("unknown signer → " + spec)
+}
+
+// HMAC-SHA256 over the body, with a Date: header for freshness
+type hmacSigner struct {
+	key    []byte
+	header string
+}
+
+// Sign sets the Date: header if absent and the base64 signature header
+func (s *hmacSigner) Sign(req *http.Request) error {
+	if req.Header.Get("Date") == "" {
+		req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	}
+
+	body, err := requestBody(req)
+	if err != nil {
+		return err
+	}
+
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(body)
+	req.Header.Set(s.header, base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+
+	return nil
+}
+
+// An external command reads the raw request on stdin and writes `Name: value` header lines to set
+type execSigner struct {
+	args []string
+}
+
+// Sign runs the command and sets the headers it writes
+func (s *execSigner) Sign(req *http.Request) error {
+	dump, err := httputil.DumpRequestOut(req, true)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(s.args[0], s.args[1:]...)
+	cmd.Stdin = bytes.NewReader(dump)
+	out, err := cmd.Output()
+	if err != nil {
+		var exit *exec.ExitError
+		if errors.As(err, &exit) && len(exit.Stderr) > 0 {
+			return errors.New(err.Error() + " → " + strings.TrimSpace(string(exit.Stderr)))
+		}
+		return err
+	}
+
+	return setHeaderLines(req, out)
+}
+
+// Set each `Name: value` line a command wrote as a header
+func setHeaderLines(req *http.Request, out []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return errors.New("command wrote a malformed header → " + line)
+		}
+		req.Header.Set(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+
+	return scanner.Err()
+}
+
+// Read a request body without consuming it
+func requestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+		return ioutil.ReadAll(body)
+	}
+
+	buf, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(buf))
+
+	return buf, nil
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/seh-msft/cfg"
+	"github.com/seh-msft/generator/pkg/generator"
+	"github.com/seh-msft/openapi"
+)
+
+// MissingParameter is a required parameter the db has nothing for
+type MissingParameter struct {
+	Name       string
+	In         string   // "path", "query", or "header"
+	Operations []string // Operations needing it, such as "GET /orders/{orderId}"
+}
+
+// Find the required parameters the db has nothing for, in name order
+// Defaults and the environment count as generation with opts would count them
+func missingParameters(api openapi.API, db cfg.Cfg, opts generator.Options) []MissingParameter {
+	found := make(map[string]*MissingParameter)
+
+	for path, methods := range api.Paths {
+		for httpMethod, method := range methods {
+			for _, param := range method.Parameters {
+				in := strings.ToLower(param.In)
+				if !param.Required || (in != "path" && in != "query" && in != "header") {
+					continue
+				}
+
+				if _, r, _ := generator.LookupDb(db, param.Name, path, method.OperationID, api.Info.Title); r != generator.Nothing {
+					continue
+				}
+				if _, ok, _ := generator.DefaultValue(db, param.Schema.Type, opts.Formats.Of(httpMethod, path, param.Name)); ok {
+					continue
+				}
+				if _, ok := generator.LookupEnv(param.Name); ok && opts.EnvFallback {
+					continue
+				}
+				if prompter != nil && prompter.answers[param.Name] != "" {
+					continue
+				}
+
+				key := in + " " + param.Name
+				if found[key] == nil {
+					found[key] = &MissingParameter{Name: param.Name, In: in}
+				}
+				found[key].Operations = append(found[key].Operations, strings.ToUpper(httpMethod)+" "+path)
+			}
+		}
+	}
+
+	var out []MissingParameter
+	for _, m := range found {
+		sort.Strings(m.Operations)
+		out = append(out, *m)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Name == out[j].Name {
+			return out[i].In < out[j].In
+		}
+		return out[i].Name < out[j].Name
+	})
+
+	return out
+}
+
+// Write a skeleton db with an empty record per missing parameter, annotated with the operations needing it
+// JSON and YAML skeletons can't be annotated
+func writeSkeleton(name string, missing []MissingParameter) error {
+	var db cfg.Cfg
+	var b strings.Builder
+
+	b.WriteString("# Parameters generator could not fill, " + time.Now().Format("2006-01-02") + "\n")
+	b.WriteString("# Fill in values and add these to your db\n")
+
+	seen := make(map[string]bool)
+	for _, m := range missing {
+		b.WriteString("\n# " + m.In + " parameter, needed by:\n")
+		for _, op := range m.Operations {
+			b.WriteString("#\t" + op + "\n")
+		}
+
+		// Parameters in more than one place are one identifier
+		if seen[m.Name] {
+			b.WriteString("# (as above)\n")
+			continue
+		}
+		seen[m.Name] = true
+
+		attr := cfg.Attribute{Name: m.Name}
+		b.WriteString(attr.String() + "\n")
+		db.Records = append(db.Records, &cfg.Record{Tuples: []*cfg.Tuple{{Attributes: []*cfg.Attribute{&attr}}}})
+	}
+
+	return saveDb(name, db, b.String())
+}
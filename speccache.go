@@ -0,0 +1,137 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/seh-msft/openapi"
+)
+
+// Most api documents -listen keeps parsed at once
+const specCacheSize = 64
+
+// A parsed api document, and what's needed to ask if it's changed
+// Entries are replaced rather than modified, so may be read without the lock
+type cachedSpec struct {
+	parsed       *ParsedSpec
+	etag         string
+	lastModified string
+	checked      time.Time // When we last knew it to be current
+}
+
+var (
+	specCache   = make(map[string]*cachedSpec)
+	specCacheMu sync.Mutex
+)
+
+// Fetch and parse the api document at a URL, reusing a parsed copy while it's current
+// Copies are checked with the server once older than -specttl, if it gave an ETag or Last-Modified
+func fetchSpec(ctx context.Context, url string) (ParsedSpec, *apiError) {
+	specCacheMu.Lock()
+	cached := specCache[url]
+	specCacheMu.Unlock()
+
+	if cached != nil && time.Since(cached.checked) < *specTTL {
+		return cached.copy(), nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return ParsedSpec{}, &apiError{http.StatusBadRequest, "Error: request for API JSON failed → " + err.Error(), true}
+	}
+	if cached != nil {
+		if cached.etag != "" {
+			req.Header.Set("If-None-Match", cached.etag)
+		}
+		if cached.lastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.lastModified)
+		}
+	}
+
+	resp, err := fetchClient().Do(req)
+	if err != nil {
+		return ParsedSpec{}, &apiError{fetchFailed(err, http.StatusBadRequest), "Error: request for API JSON failed → " + err.Error(), true}
+	}
+	defer resp.Body.Close()
+
+	// Unchanged since we parsed it
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		current := *cached
+		current.checked = time.Now()
+		storeSpec(url, &current)
+		return current.copy(), nil
+	}
+
+	// If we don't get a 200 OK
+	if resp.StatusCode != 200 {
+		buf, _ := readLimited(resp.Body, *maxFetch)
+		contents := string(buf)
+		return ParsedSpec{}, &apiError{http.StatusBadRequest, "Error: request for API JSON denied → " + contents, false}
+	}
+
+	spec, err := readLimited(resp.Body, *maxFetch)
+	if err != nil {
+		return ParsedSpec{}, &apiError{fetchFailed(err, http.StatusBadRequest), "Error: reading API JSON failed → " + err.Error(), false}
+	}
+
+	// Load openapi spec, with the security and formats it declares
+	parsed, err := parseSpec(spec)
+	if err != nil {
+		return ParsedSpec{}, &apiError{http.StatusInternalServerError, "Error: parsing OpenAPI specification failed → " + err.Error(), false}
+	}
+
+	fresh := &cachedSpec{
+		parsed:       parsed,
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+		checked:      time.Now(),
+	}
+	storeSpec(url, fresh)
+
+	return fresh.copy(), nil
+}
+
+// Keep a parsed document, making room if need be
+func storeSpec(url string, spec *cachedSpec) {
+	if *specTTL <= 0 {
+		return
+	}
+
+	specCacheMu.Lock()
+	defer specCacheMu.Unlock()
+
+	// The least recently checked goes first
+	if _, ok := specCache[url]; !ok && len(specCache) >= specCacheSize {
+		var oldest string
+		for u, s := range specCache {
+			if oldest == "" || s.checked.Before(specCache[oldest].checked) {
+				oldest = u
+			}
+		}
+		delete(specCache, oldest)
+	}
+
+	specCache[url] = spec
+}
+
+// A copy of the document jobs may trim and retarget freely
+// Its security and formats are shared, as jobs only read them
+func (c *cachedSpec) copy() ParsedSpec {
+	parsed := *c.parsed
+	api := &parsed.API
+	api.Servers = append([]openapi.Server(nil), c.parsed.API.Servers...)
+	api.Paths = make(map[string]map[string]openapi.Method, len(c.parsed.API.Paths))
+	for path, methods := range c.parsed.API.Paths {
+		api.Paths[path] = make(map[string]openapi.Method, len(methods))
+		for name, method := range methods {
+			api.Paths[path][name] = method
+		}
+	}
+
+	return parsed
+}
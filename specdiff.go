@@ -0,0 +1,123 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/seh-msft/openapi"
+)
+
+// Keys of a path item which are operations
+var httpMethods = map[string]bool{"get": true, "put": true, "post": true, "delete": true, "options": true, "head": true, "patch": true, "trace": true}
+
+// Operations of a spec which an older spec doesn't have, or has otherwise, as "method path"
+// Operations are compared with what they refer to, so a change to a shared schema changes every operation using it
+func changedOperations(old, spec []byte) (map[string]bool, error) {
+	var before, after map[string]interface{}
+	err := json.Unmarshal(old, &before)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(spec, &after)
+	if err != nil {
+		return nil, err
+	}
+
+	was := operationBodies(before)
+	changed := make(map[string]bool)
+	for op, body := range operationBodies(after) {
+		if was[op] != body {
+			changed[op] = true
+		}
+	}
+
+	return changed, nil
+}
+
+// Each operation of a spec, with the path's parameters and what it refers to, as JSON
+func operationBodies(doc map[string]interface{}) map[string]string {
+	out := make(map[string]string)
+	paths, _ := doc["paths"].(map[string]interface{})
+	for path, v := range paths {
+		item, _ := v.(map[string]interface{})
+		for method, op := range item {
+			if !httpMethods[strings.ToLower(method)] {
+				continue
+			}
+
+			buf, _ := json.Marshal(map[string]interface{}{
+				"operation":  resolveRefs(doc, op, nil),
+				"parameters": resolveRefs(doc, item["parameters"], nil),
+			})
+			out[strings.ToLower(method)+" "+path] = string(buf)
+		}
+	}
+
+	return out
+}
+
+// A value with each local $ref replaced by what it refers to, once per branch so cycles end
+func resolveRefs(doc map[string]interface{}, v interface{}, seen []string) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if ref, ok := v["$ref"].(string); ok && strings.HasPrefix(ref, "#/") {
+			for _, s := range seen {
+				if s == ref {
+					return map[string]interface{}{"$ref": ref}
+				}
+			}
+
+			var target interface{} = doc
+			for _, part := range strings.Split(ref[2:], "/") {
+				part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+				m, _ := target.(map[string]interface{})
+				target = m[part]
+			}
+			return resolveRefs(doc, target, append(append([]string{}, seen...), ref))
+		}
+
+		out := make(map[string]interface{})
+		for key, value := range v {
+			out[key] = resolveRefs(doc, value, seen)
+		}
+		return out
+
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, value := range v {
+			out[i] = resolveRefs(doc, value, seen)
+		}
+		return out
+	}
+
+	return v
+}
+
+// Narrow an api to the operations changed since an older spec
+func onlyChanged(api *openapi.API, changed map[string]bool) {
+	for path, methods := range api.Paths {
+		for method := range methods {
+			if !changed[strings.ToLower(method)+" "+path] {
+				delete(methods, method)
+			}
+		}
+		if len(methods) < 1 {
+			delete(api.Paths, path)
+		}
+	}
+}
+
+// Changed operations, in order, for logging
+func changedNames(changed map[string]bool) []string {
+	var names []string
+	for op := range changed {
+		names = append(names, strings.ToUpper(op[:strings.Index(op, " ")])+op[strings.Index(op, " "):])
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,219 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+//go:build cgo
+// +build cgo
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/seh-msft/cfg"
+)
+
+// Relational schema for results, created if absent
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS runs (
+	id       TEXT PRIMARY KEY,
+	started  TEXT NOT NULL,
+	api      TEXT NOT NULL,
+	server   TEXT NOT NULL,
+	built    INTEGER NOT NULL,
+	possible INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS requests (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	run          TEXT NOT NULL REFERENCES runs(id),
+	method       TEXT NOT NULL,
+	path         TEXT NOT NULL,
+	url          TEXT NOT NULL,
+	operation_id TEXT NOT NULL,
+	headers      TEXT NOT NULL,
+	body         TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS responses (
+	request    INTEGER PRIMARY KEY REFERENCES requests(id),
+	status     INTEGER NOT NULL,
+	headers    TEXT NOT NULL,
+	body       TEXT NOT NULL,
+	latency_ms REAL NOT NULL,
+	verdict    TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS missed (
+	run       TEXT NOT NULL REFERENCES runs(id),
+	parameter TEXT NOT NULL,
+	count     INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS requests_path ON requests(path, method);
+`
+
+// Persist a run, its requests, responses, and verdicts into a SQLite database
+func exportSQLite(name, title string, started time.Time, requests []*Request, totalPossible uint64, missed map[string]uint64, sus, ok []Set) error {
+	db, err := sql.Open("sqlite3", name)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec(sqliteSchema)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	server := ""
+	if len(requests) > 0 {
+		server = requests[0].Host
+	}
+
+	_, err = tx.Exec(`INSERT INTO runs VALUES (?, ?, ?, ?, ?, ?)`, runID, started.UTC().Format(time.RFC3339), title, server, len(requests), totalPossible)
+	if err != nil {
+		return err
+	}
+
+	for param, count := range missed {
+		_, err = tx.Exec(`INSERT INTO missed VALUES (?, ?, ?)`, runID, param, count)
+		if err != nil {
+			return err
+		}
+	}
+
+	insert := func(set Set, verdict string) error {
+		e := exchange(set)
+		reqHeaders, _ := json.Marshal(e.Request.Header)
+		respHeaders, _ := json.Marshal(e.Response.Header)
+
+		result, err := tx.Exec(`INSERT INTO requests (run, method, path, url, operation_id, headers, body) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			runID, strings.ToUpper(e.Request.Method), set.Request.Path, e.Request.URL, set.Request.Method.OperationID, string(reqHeaders), e.Request.Body)
+		if err != nil {
+			return err
+		}
+
+		id, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`INSERT INTO responses VALUES (?, ?, ?, ?, ?, ?)`,
+			id, set.Response.StatusCode, string(respHeaders), e.Response.Body, float64(set.Response.Latency)/float64(time.Millisecond), verdict)
+		return err
+	}
+
+	for _, bad := range sus {
+		err = insert(bad, "suspicious")
+		if err != nil {
+			return err
+		}
+	}
+	for _, set := range ok {
+		err = insert(set, "conformant")
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Load a db from a SQLite table with name and value columns
+// Optional path, title, and operation columns scope a row's value as an override would
+// Other columns, such as metadata, are ignored
+// Unscoped rows with the same name are enumerated values, in row order
+func loadSQLiteDb(name, table string) (cfg.Cfg, error) {
+	var c cfg.Cfg
+	if !sqliteTableName.MatchString(table) {
+		return c, errors.New("invalid table name → " + table)
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+name+"?mode=ro")
+	if err != nil {
+		return c, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`SELECT * FROM ` + table + ` ORDER BY rowid`)
+	if err != nil {
+		return c, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return c, err
+	}
+	index := make(map[string]int)
+	for i, column := range columns {
+		index[strings.ToLower(column)] = i
+	}
+	if _, ok := index["name"]; !ok {
+		return c, errors.New("table " + table + " has no name column")
+	}
+	if _, ok := index["value"]; !ok {
+		return c, errors.New("table " + table + " has no value column")
+	}
+
+	// One record per name, with its enumerated values, if any
+	records := make(map[string]*cfg.Record)
+	values := make(map[string]*cfg.Tuple)
+
+	for rows.Next() {
+		cells := make([]sql.NullString, len(columns))
+		ptrs := make([]interface{}, len(columns))
+		for i := range cells {
+			ptrs[i] = &cells[i]
+		}
+		err = rows.Scan(ptrs...)
+		if err != nil {
+			return c, err
+		}
+
+		id, value := cells[index["name"]].String, cells[index["value"]].String
+		if id == "" {
+			continue
+		}
+
+		record, ok := records[id]
+		if !ok {
+			record = &cfg.Record{Tuples: []*cfg.Tuple{{Attributes: []*cfg.Attribute{{Name: id}}}}}
+			records[id] = record
+			c.Records = append(c.Records, record)
+		}
+
+		override := []*cfg.Attribute{{Name: "override"}}
+		for _, scope := range []string{"title", "path", "operation"} {
+			if i, ok := index[scope]; ok && cells[i].String != "" {
+				override = append(override, &cfg.Attribute{Name: scope, Value: cells[i].String})
+			}
+		}
+
+		switch {
+		case len(override) > 1:
+			override = append(override, &cfg.Attribute{Name: "value", Value: value})
+			record.Tuples = append(record.Tuples, &cfg.Tuple{Attributes: override})
+
+		case values[id] != nil:
+			values[id].Attributes = append(values[id].Attributes, &cfg.Attribute{Name: value})
+
+		case record.Tuples[0].Attributes[0].Value != "":
+			// Enumerated values are names in cfg, the first row's value leads
+			values[id] = &cfg.Tuple{Attributes: []*cfg.Attribute{{Name: "values"}, {Name: record.Tuples[0].Attributes[0].Value}, {Name: value}}}
+			record.Tuples = append(record.Tuples, values[id])
+
+		default:
+			record.Tuples[0].Attributes[0].Value = value
+		}
+	}
+
+	return c, rows.Err()
+}
@@ -0,0 +1,24 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+//go:build !cgo
+// +build !cgo
+
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/seh-msft/cfg"
+)
+
+// SQLite requires cgo
+func exportSQLite(name, title string, started time.Time, requests []*Request, totalPossible uint64, missed map[string]uint64, sus, ok []Set) error {
+	return errors.New("built without cgo, SQLite is unavailable")
+}
+
+// SQLite requires cgo
+func loadSQLiteDb(name, table string) (cfg.Cfg, error) {
+	return cfg.Cfg{}, errors.New("built without cgo, SQLite is unavailable")
+}
@@ -0,0 +1,32 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Table identifiers are read from by default
+const sqliteTable = "identifiers"
+
+// Table names we'll put in a query
+var sqliteTableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Is a db name a SQLite file, such as fixtures.sqlite or fixtures.db?table=ids
+// Returns the file and table names
+func sqliteDb(name string) (string, string, bool) {
+	table := sqliteTable
+	if i := strings.Index(name, "?table="); i >= 0 {
+		name, table = name[:i], name[i+len("?table="):]
+	}
+
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".db", ".sqlite", ".sqlite3":
+		return name, table, true
+	}
+
+	return "", "", false
+}
@@ -0,0 +1,180 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/seh-msft/cfg"
+	"github.com/seh-msft/generator/pkg/generator"
+	"gopkg.in/yaml.v3"
+)
+
+// Extract values for identifiers from successful responses, as per the db's extract tuples, such as:
+//
+//	orderId=ord-1
+//		extract operation=createOrder field=id
+//		extract regex path="/orders/.*" header=Location
+//
+// Fields are dotted paths into a JSON body, such as "items.0.id"
+// Requests are taken in order, so the last response a value is found in wins
+func extractValues(db cfg.Cfg, title string, requests []*Request, results map[*Request]*Response) (map[string]string, error) {
+	extracted := make(map[string]string)
+
+	for _, request := range requests {
+		response, ok := results[request]
+		if !ok || response.StatusCode < 200 || response.StatusCode > 299 {
+			continue
+		}
+
+		for _, record := range db.Records {
+			extracts, ok := record.Lookup("extract")
+			if !ok {
+				continue
+			}
+
+			for _, tuple := range extracts {
+				matched, err := generator.MatchTuples([]*cfg.Tuple{tuple}, request.Path, request.Method.OperationID, title)
+				if err != nil {
+					return nil, errors.New(`could not match extract rule of "` + record.PrimaryKey() + `" → ` + err.Error())
+				}
+				if !matched {
+					continue
+				}
+
+				if value, ok := extract(tuple, response); ok {
+					extracted[record.PrimaryKey()] = value
+				}
+			}
+		}
+	}
+
+	return extracted, nil
+}
+
+// Extract the field or header an extract tuple names from a response
+func extract(tuple *cfg.Tuple, response *Response) (string, bool) {
+	for _, attr := range tuple.Attributes {
+		switch attr.Name {
+		case "header":
+			value := response.Header.Get(attr.Value)
+			return value, value != ""
+
+		case "field":
+			var body interface{}
+			if json.Unmarshal([]byte(response.Body), &body) != nil {
+				return "", false
+			}
+
+			for _, step := range strings.Split(attr.Value, ".") {
+				switch v := body.(type) {
+				case map[string]interface{}:
+					body = v[step]
+				case []interface{}:
+					i, err := strconv.Atoi(step)
+					if err != nil || i < 0 || i >= len(v) {
+						return "", false
+					}
+					body = v[i]
+				default:
+					return "", false
+				}
+			}
+
+			if body == nil {
+				return "", false
+			}
+			return jsonString(body), true
+		}
+	}
+
+	return "", false
+}
+
+// Write the db read from source to name, with extracted values in place of the identifiers' own
+// The source is read afresh so secrets resolved from the environment aren't written out
+func writeDb(ctx context.Context, name, source string, extracted map[string]string) error {
+	if _, _, ok := sqliteDb(name); ok {
+		return errors.New("SQLite dbs can't be written, write cfg, JSON, or YAML")
+	}
+
+	db, err := readDb(ctx, source)
+	if err != nil {
+		return err
+	}
+
+	for id, value := range extracted {
+		records, ok := db.Lookup(id)
+		if !ok {
+			// A new identifier
+			db.Records = append(db.Records, &cfg.Record{Tuples: []*cfg.Tuple{{Attributes: []*cfg.Attribute{{Name: id, Value: value}}}}})
+			continue
+		}
+
+		// The record which extracts the value holds it
+		record := records[0]
+		for _, r := range records {
+			if _, ok := r.Lookup("extract"); ok {
+				record = r
+				break
+			}
+		}
+		record.Tuples[0].Attributes[0].Value = value
+	}
+
+	return saveDb(name, db, db.String())
+}
+
+// Write a db to a file as JSON or YAML by its extension, otherwise as the given cfg text
+func saveDb(name string, db cfg.Cfg, text string) error {
+	var buf []byte
+	var err error
+
+	// "db.json.age" is encrypted JSON
+	plain := name
+	encrypted := strings.HasSuffix(strings.ToLower(name), ".age")
+	if encrypted {
+		plain = name[:len(name)-len(".age")]
+	}
+
+	switch strings.ToLower(filepath.Ext(plain)) {
+	case ".json":
+		buf, err = json.MarshalIndent(jsonDb(db), "", "\t")
+		buf = append(buf, '\n')
+	case ".yaml", ".yml":
+		// By way of JSON, as YAML dbs are loaded
+		var doc interface{}
+		buf, err = json.Marshal(jsonDb(db))
+		if err == nil {
+			err = json.Unmarshal(buf, &doc)
+		}
+		if err == nil {
+			buf, err = yaml.Marshal(doc)
+		}
+	default:
+		buf = []byte(text)
+	}
+	if err == nil && encrypted {
+		buf, err = encryptDb(buf)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Write beside, then move into place
+	tmp := name + ".tmp"
+	err = ioutil.WriteFile(tmp, buf, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, name)
+}
@@ -0,0 +1,217 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+
+	"github.com/seh-msft/generator/pkg/generator"
+	"github.com/seh-msft/openapi"
+)
+
+// Specs too large to hold at once, as per -stream, are read twice
+// First for all but their paths, then a path item at a time, each built and dropped before the next is read
+
+// Read all of a spec but its paths, with the security schemes and requirements it declares outside them
+// Only what generation uses of each component is kept
+func specHead(name string) (openapi.API, Security, error) {
+	api := openapi.API{Components: make(map[string]map[string]openapi.Type)}
+	security := Security{Schemes: make(map[string]SecurityScheme), Operations: make(map[string]*[]SecurityRequirement)}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return api, security, err
+	}
+	defer f.Close()
+
+	err = eachMember(json.NewDecoder(f), func(dec *json.Decoder, key string) error {
+		switch key {
+		case "openapi":
+			return dec.Decode(&api.Version)
+		case "info":
+			return dec.Decode(&api.Info)
+		case "servers":
+			return dec.Decode(&api.Servers)
+		case "security":
+			return dec.Decode(&security.Global)
+		case "paths":
+			return eachMember(dec, func(dec *json.Decoder, path string) error {
+				return skip(dec)
+			})
+		case "components":
+			return eachMember(dec, func(dec *json.Decoder, kind string) error {
+				var raw json.RawMessage
+				err := dec.Decode(&raw)
+				if err != nil {
+					return err
+				}
+
+				var types map[string]openapi.Type
+				err = json.Unmarshal(raw, &types)
+				if err != nil {
+					return err
+				}
+				api.Components[kind] = types
+				if kind == "securitySchemes" {
+					return json.Unmarshal(raw, &security.Schemes)
+				}
+
+				return nil
+			})
+		}
+
+		return skip(dec)
+	})
+
+	return api, security, err
+}
+
+// Build a spec's requests a path item at a time, with all but its paths as per specHead
+// Each path item is narrowed as opts says, then handed to generate as a spec of only that path
+// Its requests go to yield before the next is read, an error from yield stops the rest
+// The security requirements and parameter formats of each operation are kept, as parsing the whole spec would
+// Operations are built in the order the spec gives their paths, rather than in order of path
+func streamSpec(name string, head openapi.API, security Security, opts Options, generate func(openapi.API) ([]*Request, map[string]uint64, uint64, error), yield func([]*Request) error) (map[string]uint64, uint64, error) {
+	missing := make(map[string]uint64)
+	totalPossible := uint64(0)
+
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	err = eachMember(json.NewDecoder(f), func(dec *json.Decoder, key string) error {
+		if key != "paths" {
+			return skip(dec)
+		}
+
+		return eachMember(dec, func(dec *json.Decoder, path string) error {
+			var raw json.RawMessage
+			err := dec.Decode(&raw)
+			if err != nil {
+				return err
+			}
+
+			var item map[string]json.RawMessage
+			err = json.Unmarshal(raw, &item)
+			if err != nil {
+				return err
+			}
+			security.addOperations(path, item)
+			opts.Formats.Add(path, item)
+
+			var methods map[string]openapi.Method
+			err = json.Unmarshal(raw, &methods)
+			if err != nil {
+				return errors.New(path + ": " + err.Error())
+			}
+
+			api := head
+			api.Paths = map[string]map[string]openapi.Method{path: methods}
+			opts.narrow(&api)
+
+			built, missed, possible, err := generate(api)
+			if err != nil {
+				return err
+			}
+			for param, count := range missed {
+				missing[param] += count
+			}
+			totalPossible += possible
+
+			return yield(built)
+		})
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return missing, totalPossible, nil
+}
+
+// Replay requests as build hands them to its yield, each sent on as soon as it's built, as Replayer.Pipe does
+// Yield fails once replay has stopped, so build should too, keeping its own error to tell apart from replay's
+func replayStream(ctx context.Context, rp *generator.Replayer, build func(yield func([]*Request) error), done func(*Request, Response)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	feed := make(chan *Request)
+	go func() {
+		defer close(feed)
+		build(func(built []*Request) error {
+			for _, request := range built {
+				select {
+				case feed <- request:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			return nil
+		})
+	}()
+
+	err := rp.ReplayFrom(ctx, feed, done)
+
+	// Stop building, then wait for the builder to finish
+	cancel()
+	for range feed {
+	}
+
+	return err
+}
+
+// Hand each member of the JSON object next in dec to fn, which must decode the member's value
+// A null is taken as an empty object
+func eachMember(dec *json.Decoder, fn func(dec *json.Decoder, key string) error) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if t == nil {
+		return nil
+	}
+	if t != json.Delim('{') {
+		return errors.New("expected an object")
+	}
+
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, _ := t.(string)
+
+		err = fn(dec, key)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = dec.Token()
+	return err
+}
+
+// Pass over the value next in dec
+func skip(dec *json.Decoder) error {
+	var raw json.RawMessage
+	return dec.Decode(&raw)
+}
+
+// Check -stream can do what else was asked, as flags reading the whole spec can't be streamed
+func checkStream() error {
+	switch {
+	case *since != "":
+		return errors.New("-stream doesn't go with -since, which compares whole specs")
+	case *interactive:
+		return errors.New("-stream doesn't go with -interactive, which reads the whole spec for examples")
+	case *missingDbName != "":
+		return errors.New("-stream doesn't go with -missingdb, which looks over the whole spec")
+	}
+
+	return nil
+}
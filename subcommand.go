@@ -0,0 +1,130 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Subcommand is a mode of the CLI, which only takes the flags which apply to it
+type Subcommand struct {
+	Name    string
+	Summary string
+	Flags   [][]string // Groups of flags which apply, beyond the common ones
+}
+
+// Flags by what they're for, which subcommands share
+var (
+	commonFlags = []string{"config", "D", "v", "vv", "q", "noredact", "redact"}
+	buildFlags  = []string{"api", "db", "envfallback", "dbkey", "dbauth", "identity", "interactive", "missingdb", "csv", "strict", "proto", "allbodies", "sequence", "cartesian", "ignoremethods", "methods", "paths", "since", "stream", "speccache", "limit", "sample", "shuffle", "H", "nocorrelate", "useragent", "dryrun", "artifacts", "target", "printreqs", "outdir", "deadline", "otlp", "watch"}
+	authFlags   = []string{"auth", "authfile", "noauth", "basic", "ntlm", "apikey", "cookie", "aad", "aadcred", "oauth", "oauthissuer", "oauthclient", "oauthscope"}
+	replayFlags = []string{"progress", "concurrency", "timeout", "rate", "bodylimit", "sign", "signkey", "signheader", "hook", "writedb"}
+	outputFlags = []string{"o", "output", "format", "ado", "gha", "jsonl", "indent", "full", "template"}
+	gateFlags   = []string{"fail-on", "adorun", "notify", "notifylink"}
+	exportFlags = []string{"appinsights", "elastic", "elasticindex", "sqlite"}
+	serverFlags = []string{"listen", "grpc", "cert", "key", "serverkeys", "servertenant", "serveraudience", "auditlog", "draintimeout", "maxbody", "maxfetch", "fetchtimeout", "readtimeout", "writetimeout", "maxjobs", "queue", "ratelimit", "rateburst", "corsorigins", "corsmethods", "allowhosts", "denyhosts", "profiles", "specttl"}
+	jobFlags    = []string{"nocorrelate", "useragent", "strict", "allbodies", "proto", "sequence", "cartesian", "concurrency", "timeout", "rate", "bodylimit", "deadline", "aadcred"}
+	subcommands = []*Subcommand{
+		{"generate", "Build requests from a spec and db, without replaying them", [][]string{buildFlags, authFlags, outputFlags, {"fail-on"}}},
+		{"replay", "Build and replay requests, writing each result as JSON Lines unless told otherwise, findings don't fail the run", [][]string{buildFlags, authFlags, replayFlags, outputFlags}},
+		{"validate", "Build and replay requests, judge each response against the spec, and fail the run on findings", [][]string{buildFlags, authFlags, replayFlags, outputFlags, gateFlags, exportFlags}},
+		{"serve", "Offer generation over HTTP, and gRPC, as per -listen", [][]string{serverFlags, jobFlags}},
+		{"report", "Write the JSON or JSON Lines results of runs again, in any format, and fail on their findings", [][]string{outputFlags, gateFlags}},
+	}
+)
+
+// The subcommand named, if any
+func findSubcommand(name string) *Subcommand {
+	for _, cmd := range subcommands {
+		if cmd.Name == name {
+			return cmd
+		}
+	}
+
+	return nil
+}
+
+// Name of a subcommand, "" for none
+func (c *Subcommand) name() string {
+	if c == nil {
+		return ""
+	}
+
+	return c.Name
+}
+
+// Whether a flag applies to a subcommand, any do without one
+func (c *Subcommand) takes(name string) bool {
+	if c == nil {
+		return true
+	}
+
+	for _, group := range append([][]string{commonFlags}, c.Flags...) {
+		for _, f := range group {
+			if f == name {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Parse a subcommand's flags, those after its name, refusing any which don't apply to it
+func (c *Subcommand) parse(args []string) error {
+	flag.Usage = c.usage
+	flag.CommandLine.Parse(args)
+
+	var refused []string
+	flag.Visit(func(f *flag.Flag) {
+		if !c.takes(f.Name) {
+			refused = append(refused, "-"+f.Name)
+		}
+	})
+	switch {
+	case len(refused) == 1:
+		return errors.New(refused[0] + " does not apply to " + c.Name + ", see generator " + c.Name + " -h")
+	case len(refused) > 1:
+		return errors.New(strings.Join(refused, ", ") + " do not apply to " + c.Name + ", see generator " + c.Name + " -h")
+	}
+
+	return nil
+}
+
+// Print the flags of a subcommand, as -h would
+func (c *Subcommand) usage() {
+	fs := flag.NewFlagSet(c.Name, flag.ContinueOnError)
+	fs.SetOutput(flag.CommandLine.Output())
+	flag.VisitAll(func(f *flag.Flag) {
+		if c.takes(f.Name) {
+			fs.Var(f.Value, f.Name, f.Usage)
+			fs.Lookup(f.Name).DefValue = f.DefValue
+		}
+	})
+
+	args := "[flags]"
+	if c.Name == "report" {
+		args += " [results ...]"
+	}
+	fmt.Fprintf(fs.Output(), "Usage: generator %s %s\n\n%s\n\n", c.Name, args, c.Summary)
+	fs.PrintDefaults()
+}
+
+// Print the flags of the whole CLI, and its subcommands
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage of %s:\n", os.Args[0])
+	flag.PrintDefaults()
+
+	fmt.Fprintf(out, "\nEach flag may also be given as an environment variable, such as %s for -fail-on\n", envName("fail-on"))
+	fmt.Fprintln(out, "\nSubcommands, each with only the flags which apply, as per generator <subcommand> -h:")
+	for _, cmd := range subcommands {
+		fmt.Fprintf(out, "  %-10s%s\n", cmd.Name, cmd.Summary)
+	}
+	fmt.Fprintf(out, "  %-10s%s\n", "db check", "Report mistakes in a db, as per -db and -api")
+}
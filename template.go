@@ -0,0 +1,99 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Report is the result model user templates are executed against
+type Report struct {
+	Server     string            // Host we targeted
+	Built      int               // Requests built
+	Total      uint64            // Requests possible
+	Missed     map[string]uint64 // Parameters missed and how often
+	Suspicious []Outcome         // Suspicious results
+	Conformant []Outcome         // Conformant results
+}
+
+// Outcome is a single replayed request and its result, flattened for templates
+type Outcome struct {
+	Method      string        // HTTP method
+	URL         string        // Full URL called
+	Path        string        // URL path called
+	Variant     string        // Values of a variant, as per -cartesian or -sequence
+	RequestID   string        // Correlation id sent as X-Request-ID
+	OperationID string        // OpenAPI operationId, if any
+	Summary     string        // OpenAPI summary, if any
+	HTTPCode    int           // HTTP status code received
+	Status      string        // HTTP status line received
+	Body        string        // Body received
+	Latency     time.Duration // Time taken to respond
+	Verdict     string        // "suspicious" or "conformant"
+}
+
+// Functions available to user templates
+var templateFuncs = template.FuncMap{
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"join":  strings.Join,
+	"trim":  strings.TrimSpace,
+}
+
+// Build the result model for a run
+func report(requests []*Request, totalPossible uint64, missed map[string]uint64, sus, ok []Set) Report {
+	r := Report{
+		Built:  len(requests),
+		Total:  totalPossible,
+		Missed: missed,
+	}
+	if len(requests) > 0 {
+		r.Server = requests[0].Host
+	}
+
+	outcome := func(set Set, verdict string) Outcome {
+		return Outcome{
+			Method:      strings.ToUpper(set.Request.Request.Method),
+			URL:         set.Request.URL.String(),
+			Path:        set.Request.URL.Path,
+			Variant:     set.Request.Variant,
+			RequestID:   requestID(set.Request),
+			OperationID: set.Request.Method.OperationID,
+			Summary:     set.Request.Method.Summary,
+			HTTPCode:    set.Response.StatusCode,
+			Status:      set.Response.Status,
+			Body:        set.Response.Body,
+			Latency:     set.Response.Latency,
+			Verdict:     verdict,
+		}
+	}
+
+	for _, set := range sus {
+		r.Suspicious = append(r.Suspicious, outcome(set, "suspicious"))
+	}
+	for _, set := range ok {
+		r.Conformant = append(r.Conformant, outcome(set, "conformant"))
+	}
+
+	return r
+}
+
+// Load a user template from a file
+func loadTemplate(name string) (*template.Template, error) {
+	buf, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return template.New(name).Funcs(templateFuncs).Parse(string(buf))
+}
+
+// Template-formatted output
+func printTemplate(w io.Writer, t *template.Template, requests []*Request, totalPossible uint64, missed map[string]uint64, sus, ok []Set) error {
+	return t.Execute(w, report(requests, totalPossible, missed, sus, ok))
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Threshold is a single pass/fail rule for a run such as `coverage<80%`
+type Threshold struct {
+	Metric string  // One of the names from metrics()
+	Op     string  // Comparison operator
+	Value  float64 // Right hand side of the comparison
+}
+
+// Operators permitted in a threshold, longest first so `>=` isn't read as `>`
+var thresholdOps = []string{">=", "<=", "!=", "==", ">", "<", "="}
+
+// Parse thresholds in the form `suspicious>0,missing>10,coverage<80%`
+func parseThresholds(s string) ([]Threshold, error) {
+	var out []Threshold
+
+	for _, rule := range strings.Split(s, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+
+		var t Threshold
+		for _, op := range thresholdOps {
+			i := strings.Index(rule, op)
+			if i < 0 {
+				continue
+			}
+
+			t.Metric = strings.ToLower(strings.TrimSpace(rule[:i]))
+			t.Op = op
+			value := strings.TrimSuffix(strings.TrimSpace(rule[i+len(op):]), "%")
+
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf(`bad value in threshold "%s" → %v`, rule, err)
+			}
+			t.Value = v
+
+			break
+		}
+
+		if t.Op == "" {
+			return nil, errors.New(`no operator in threshold "` + rule + `"`)
+		}
+
+		switch t.Metric {
+		case "suspicious", "conformant", "missing", "coverage":
+		default:
+			return nil, errors.New(`unknown metric in threshold "` + rule + `"`)
+		}
+
+		out = append(out, t)
+	}
+
+	return out, nil
+}
+
+// Metrics for a run, as referenced by thresholds
+//
+//	suspicious - number of suspicious responses
+//	conformant - number of conformant responses
+//	missing - number of distinct parameters which could not be filled
+//	coverage - percentage of possible requests which were built
+func metrics(requests []*Request, totalPossible uint64, missed map[string]uint64, sus, ok []Set) map[string]float64 {
+	coverage := 100.0
+	if totalPossible > 0 {
+		coverage = 100 * float64(len(requests)) / float64(totalPossible)
+	}
+
+	return map[string]float64{
+		"suspicious": float64(len(sus)),
+		"conformant": float64(len(ok)),
+		"missing":    float64(len(missed)),
+		"coverage":   coverage,
+	}
+}
+
+// Tripped is true if the metrics violate the threshold
+func (t Threshold) Tripped(metrics map[string]float64) bool {
+	v := metrics[t.Metric]
+
+	switch t.Op {
+	case ">":
+		return v > t.Value
+	case "<":
+		return v < t.Value
+	case ">=":
+		return v >= t.Value
+	case "<=":
+		return v <= t.Value
+	case "=", "==":
+		return v == t.Value
+	case "!=":
+		return v != t.Value
+	}
+
+	return false
+}
+
+func (t Threshold) String() string {
+	s := t.Metric + t.Op + strconv.FormatFloat(t.Value, 'f', -1, 64)
+	if t.Metric == "coverage" {
+		s += "%"
+	}
+
+	return s
+}
+
+// Gate a run on thresholds, logging each which was tripped
+// Returns true if the run passes
+func gate(thresholds []Threshold, metrics map[string]float64) bool {
+	pass := true
+	for _, t := range thresholds {
+		if t.Tripped(metrics) {
+			emit(fmt.Sprintf("fail: threshold %s tripped (%s = %.4g)", t, t.Metric, metrics[t.Metric]))
+			pass = false
+		}
+	}
+
+	return pass
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+)
+
+// Is a request a multipart/form-data upload
+func isUpload(r *http.Request) bool {
+	kind, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && kind == "multipart/form-data"
+}
+
+// Read the options, spec, and db of a multipart/form-data upload
+// The "options" part is JSON as per POST /generator, the "api" and "cfg" parts are the documents themselves
+func decodeUpload(r *http.Request, opts *JobOptions) error {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return err
+	}
+
+	var cfgFile []byte
+	var cfgName string
+
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		switch part.FormName() {
+		case "options":
+			err = json.NewDecoder(part).Decode(opts)
+			if err == io.EOF {
+				err = nil
+			}
+
+		case "api":
+			opts.spec, err = ioutil.ReadAll(part)
+			if err == nil && len(opts.spec) < 1 {
+				err = errors.New("api part is empty")
+			}
+
+		case "cfg":
+			cfgFile, err = ioutil.ReadAll(part)
+			cfgName = part.FileName()
+
+		default:
+			err = errors.New("unknown part " + part.FormName() + ", parts are options, api, and cfg")
+		}
+		part.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	// Uploads stand in for the URLs
+	if opts.spec != nil && opts.API != "" {
+		return errors.New("provide the api part ⊻ an api URL")
+	}
+	if cfgFile != nil {
+		if opts.Cfg != "" || opts.CfgPath != "" {
+			return errors.New("provide the cfg part ⊻ cfg or cfgpath")
+		}
+		opts.Cfg, opts.cfgName = string(cfgFile), cfgName
+	}
+
+	return nil
+}
@@ -0,0 +1,42 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"runtime/debug"
+)
+
+// Version of generator, as per -ldflags '-X main.version=v1.2.3', otherwise as go install recorded it
+var version = ""
+
+// Version of generator, "devel" for a build from a checkout
+func generatorVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+
+	return "devel"
+}
+
+// The User-Agent requests are sent with, unless -useragent says otherwise
+// It names the tool, its version, and the run, so owners of a target can tell its traffic apart, and exempt it if need be
+func defaultUserAgent() string {
+	return "generator/" + generatorVersion() + " (+https://github.com/seh-msft/generator; run " + runID + ")"
+}
+
+// Put a User-Agent on every request which hasn't one, such as from -H or a spec parameter
+func applyUserAgent(requests []*Request, ua string) {
+	if ua == "" {
+		ua = defaultUserAgent()
+	}
+
+	for _, request := range requests {
+		if request.Header.Get("User-Agent") == "" {
+			request.Header.Set("User-Agent", ua)
+		}
+	}
+}
@@ -4,163 +4,237 @@
 package main
 
 import (
-	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httputil"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/seh-msft/cfg"
+	"github.com/seh-msft/generator/pkg/generator"
 )
 
-// In should be a _complete_ HTTP request
-// Out is optional and a JSON form of the response will be written if non-nil
-func replay(req *http.Request, out io.Writer) Response {
-	req.RequestURI = ""
-	req.URL.Scheme = *proto
-	req.URL.Host = req.Host
+// Transport for replay, nil for the default
+var transport http.RoundTripper
 
-	client := &http.Client{}
+// Signs each request just before replay, if any
+var signer Signer
 
-	resp, err := client.Do(req)
-	if err != nil {
-		fatal("err: could not make request →", err)
-	}
+// Replay requests as paced, with our transport, signer, and hooks, keeping secrets out of responses
+func replayer(p generator.Pacing) *generator.Replayer {
+	return &generator.Replayer{Pacing: p, Transport: transport, Signer: signer, Scrub: scrub, Hooks: hooks}
+}
 
-	http2response := func(r http.Response) Response {
-		resp := Response{
-			Status:           r.Status,
-			StatusCode:       r.StatusCode,
-			Proto:            r.Proto,
-			ProtoMajor:       r.ProtoMajor,
-			ProtoMinor:       r.ProtoMinor,
-			Header:           r.Header,
-			ContentLength:    r.ContentLength,
-			TransferEncoding: r.TransferEncoding,
-			Close:            r.Close,
-			Uncompressed:     r.Uncompressed,
-		}
+// Redact secrets from a response
+func scrub(resp *Response) {
+	resp.Header = redactHeader(resp.Header)
+	resp.Body = redact(resp.Body)
+}
 
-		/* TODO - we may want to be able to check a global options table?
-		// Do we want REST/flag options for these?
-		if !*noBody {
-			var buf bytes.Buffer
-			buf.ReadFrom(r.Body)
-			body := buf.String()
-			resp.Body = body
+// JSON-formatted output
+// If indent is true, the document is indented for humans
+// If full is true, the complete request and response are included per result
+func printJSON(w io.Writer, indent, full bool, requests []*Request, missed map[string]uint64, sus, ok []Set) error {
+	type Group struct {
+		Method    string
+		HTTPCode  int
+		Path      string
+		Variant   string `json:",omitempty"`
+		RequestID string `json:",omitempty"`
+		Body      string
+		Exchange  *Exchange `json:",omitempty"`
+	}
+	type Output struct {
+		Info struct {
+			// TODO - account for multiple servers, make this part of Request{} ?
+			Server string
+			Missed map[string]uint64
 		}
+		Conformant []Group
+		Suspicious []Group
+	}
+	var out Output
+	if len(requests) > 0 {
+		out.Info.Server = requests[0].Host
+	}
+	out.Info.Missed = missed
 
-		if *yesTLS {
-			resp.TLS = r.TLS
+	group := func(set Set) Group {
+		g := Group{
+			Method:    set.Request.Request.Method,
+			HTTPCode:  set.Response.StatusCode,
+			Path:      set.Request.URL.Path,
+			Variant:   set.Request.Variant,
+			RequestID: requestID(set.Request),
+			Body:      set.Response.Body,
+		}
+		if full {
+			g.Exchange = exchange(set)
 		}
-		*/
+		return g
+	}
 
-		return resp
+	for _, set := range ok {
+		out.Conformant = append(out.Conformant, group(set))
 	}
 
-	if out != nil {
-		w := bufio.NewWriter(out)
-		r := http2response(*resp)
-		enc := json.NewEncoder(w)
-		err := enc.Encode(r)
-		if err != nil {
-			fatal("err: could not encode response to JSON →", err)
-		}
-		w.Flush()
-		return r
+	for _, set := range sus {
+		out.Suspicious = append(out.Suspicious, group(set))
 	}
 
-	return http2response(*resp)
+	return newEncoder(w, indent).Encode(out)
 }
 
-// Validate against API spec
-func validate(results map[*Request]*Response) ([]Set, []Set, error) {
-	var sus []Set
-	var ok []Set
+// Exchange is a complete request and response pair, for forensic analysis
+type Exchange struct {
+	Request struct {
+		Method string
+		URL    string
+		Header http.Header
+		Body   string
+	}
+	Response struct {
+		Status  string
+		Header  http.Header
+		Body    string
+		Latency string
+	}
+}
 
-	// If replayed, compare results to specification
-	for request, response := range results {
-		// Responses ⇒ ["200"]"some kind of reason"
-		for expected := range request.Method.Responses {
-			eint, err := strconv.Atoi(expected)
-			if err != nil {
-				return nil, nil, err
-			}
+// Build the complete exchange for a replayed request
+func exchange(set Set) *Exchange {
+	var e Exchange
 
-			// Check expected vs reality
-			// If we get an expected result, this may be a permission violation
-			// TODO - options/modes for what qualifies as a permission violation
-			// For now, employ a heuristic
-			if eint == response.StatusCode {
-				// Status code matches a known response
-				sus = append(sus, Set{request, response})
-			} else {
-				// We don't expect the response received
-				// TODO - better detection heuristics/options for abnormal responses
-				ok = append(ok, Set{request, response})
-			}
+	e.Request.Method = set.Request.Request.Method
+	e.Request.URL = redact(set.Request.URL.String())
+	e.Request.Header = redactHeader(set.Request.Header)
+
+	// The original body was consumed by replay
+	if set.Request.GetBody != nil {
+		body, err := set.Request.GetBody()
+		if err == nil {
+			buf, _ := ioutil.ReadAll(body)
+			e.Request.Body = redact(string(buf))
 		}
 	}
 
-	return sus, ok, nil
+	e.Response.Status = set.Response.Status
+	e.Response.Header = set.Response.Header
+	e.Response.Body = set.Response.Body
+	e.Response.Latency = set.Response.Latency.String()
+
+	return &e
 }
 
-// JSON-formatted output
-func printJSON(w io.Writer, requests []*Request, missed map[string]uint64, sus, ok []Set) error {
-	type Group struct {
-		Method   string
-		HTTPCode int
-		Path     string
-		Body     string
+// Entry is a single replay result as emitted in JSON Lines output
+type Entry struct {
+	Method    string
+	HTTPCode  int
+	Path      string
+	Variant   string `json:",omitempty"` // Values of a variant, as per -cartesian or -sequence
+	RequestID string `json:",omitempty"` // Correlation id sent as X-Request-ID
+	Body      string
+	Verdict   string    // "suspicious" or "conformant"
+	Failed    []string  `json:",omitempty"` // Why hooks failed the response, as per -hook
+	Exchange  *Exchange `json:",omitempty"`
+}
+
+// JSON Lines output - emit one replay result as soon as it completes
+// If full is true, the complete request and response are included
+func printJSONL(w io.Writer, full bool, request *Request, response *Response) error {
+	entry, err := newEntry(full, request, response)
+	if err != nil {
+		return err
 	}
-	type Output struct {
-		Info struct {
-			// TODO - account for multiple servers, make this part of Request{} ?
-			Server string
-			Missed map[string]uint64
-		}
-		Conformant []Group
-		Suspicious []Group
+
+	enc := json.NewEncoder(w)
+	return enc.Encode(entry)
+}
+
+// One replay result, with its verdict
+// If full is true, the complete request and response are included
+func newEntry(full bool, request *Request, response *Response) (Entry, error) {
+	suspicious, err := generator.Judge(request, response)
+	if err != nil {
+		return Entry{}, err
 	}
-	var out Output
-	out.Info.Server = requests[0].Host
-	out.Info.Missed = missed
 
-	for _, set := range ok {
-		out.Conformant = append(out.Conformant, Group{
-			Method:   set.Request.Request.Method,
-			HTTPCode: set.Response.StatusCode,
-			Path:     set.Request.URL.Path,
-			Body:     set.Response.Body,
-		})
+	verdict := "conformant"
+	if suspicious {
+		verdict = "suspicious"
 	}
 
-	for _, set := range sus {
-		out.Suspicious = append(out.Suspicious, Group{
-			Method:   set.Request.Request.Method,
-			HTTPCode: set.Response.StatusCode,
-			Path:     set.Request.URL.Path,
-			Body:     set.Response.Body,
-		})
+	entry := Entry{
+		Method:    request.Request.Method,
+		HTTPCode:  response.StatusCode,
+		Path:      request.URL.Path,
+		Variant:   request.Variant,
+		RequestID: requestID(request),
+		Body:      response.Body,
+		Verdict:   verdict,
+		Failed:    response.Failed,
 	}
+	if full {
+		entry.Exchange = exchange(Set{Request: request, Response: response})
+	}
+
+	return entry, nil
+}
+
+// JSON Lines output - emit the run information, last
+func printJSONLInfo(w io.Writer, requests []*Request, totalPossible uint64, missed map[string]uint64) error {
+	type Info struct {
+		Server   string
+		Missed   map[string]uint64
+		Possible uint64 // Requests which could have been built
+	}
+	var out struct {
+		Info Info
+	}
+	if len(requests) > 0 {
+		out.Info.Server = requests[0].Host
+	}
+	out.Info.Missed = missed
+	out.Info.Possible = totalPossible
 
 	enc := json.NewEncoder(w)
 	return enc.Encode(out)
 }
 
+// Names of missed parameters, in order
+func missedNames(missed map[string]uint64) []string {
+	var names []string
+	for name := range missed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
 // ADO-formatted output with debug/warnings/errors
 func printADO(w io.Writer, requests []*Request, missed map[string]uint64, sus, ok []Set) {
 	// Misc debug info
 	fmt.Fprintf(w, "##[group]Miscellaneous Info\n")
 	// TODO - account for multiple servers, make this part of Request{} ?
-	fmt.Fprintf(w, "##[debug]Server we're targeting: `%s`\n", requests[0].Host)
+	if len(requests) > 0 {
+		fmt.Fprintf(w, "##[debug]Server we're targeting: `%s`\n", requests[0].Host)
+	}
 	fmt.Fprintf(w, "##[debug]Parameters we missed:\n")
-	for param, count := range missed {
-		fmt.Fprintf(w, "##[debug]`%s` missed %d times\n", param, count)
+	for _, param := range missedNames(missed) {
+		fmt.Fprintf(w, "##[debug]`%s` missed %d times\n", param, missed[param])
 	}
 	fmt.Fprintf(w, "##[endgroup]\n\n")
 
@@ -168,7 +242,7 @@ func printADO(w io.Writer, requests []*Request, missed map[string]uint64, sus, o
 	if len(ok) > 0 {
 		fmt.Fprintf(w, "##[group]Conformant (ok) Responses (%d requests total)\n", len(ok))
 		for _, set := range ok {
-			fmt.Fprintf(w, "##[debug]Conformant Response code `HTTP %d` for path `HTTP %s` `%s`\n", set.Response.StatusCode, strings.ToUpper(set.Request.Request.Method), set.Request.URL.Path)
+			fmt.Fprintf(w, "##[debug]Conformant Response code `HTTP %d` for path `HTTP %s` `%s`%s\n", set.Response.StatusCode, strings.ToUpper(set.Request.Request.Method), set.Request.URL.Path, set.Request.VariantSuffix())
 			if len(set.Response.Body) > 0 {
 				fmt.Fprintf(w, "##[debug]Body received:\n\n```\n%s\n```\n", set.Response.Body)
 			}
@@ -182,7 +256,7 @@ func printADO(w io.Writer, requests []*Request, missed map[string]uint64, sus, o
 	if len(sus) > 0 {
 		fmt.Fprintf(w, "##vso[task.logissue type=warning]Suspicious (bad) Responses (%d requests total)\n", len(sus))
 		for _, bad := range sus {
-			fmt.Fprintf(w, "##vso[task.logissue type=warning]Suspicious Response code `HTTP %d` for path `HTTP %s` `%s`\n", bad.Response.StatusCode, strings.ToUpper(bad.Request.Request.Method), bad.Request.URL.Path)
+			fmt.Fprintf(w, "##vso[task.logissue type=warning]Suspicious Response code `HTTP %d` for path `HTTP %s` `%s`%s%s\n", bad.Response.StatusCode, strings.ToUpper(bad.Request.Request.Method), bad.Request.URL.Path, bad.Request.VariantSuffix(), requestIDSuffix(bad.Request))
 			if len(bad.Response.Body) > 0 {
 				fmt.Fprintf(w, "##[debug]Body received:\n\n```\n%s\n```\n", bad.Response.Body)
 			}
@@ -192,26 +266,411 @@ func printADO(w io.Writer, requests []*Request, missed map[string]uint64, sus, o
 	}
 }
 
+// GitHub Actions-formatted output with workflow commands
+// If summary is non-nil, a Markdown job summary is written to it
+func printGHA(w io.Writer, summary io.Writer, strict bool, requests []*Request, missed map[string]uint64, sus, ok []Set) {
+	// Suspicious results fail the job under strict mode
+	level := "warning"
+	if strict {
+		level = "error"
+	}
+
+	// Misc debug info
+	fmt.Fprintf(w, "::group::Miscellaneous Info\n")
+	// TODO - account for multiple servers, make this part of Request{} ?
+	if len(requests) > 0 {
+		fmt.Fprintf(w, "::debug::Server we're targeting: `%s`\n", requests[0].Host)
+	}
+	fmt.Fprintf(w, "::debug::Parameters we missed:\n")
+	for _, param := range missedNames(missed) {
+		fmt.Fprintf(w, "::debug::`%s` missed %d times\n", param, missed[param])
+	}
+	fmt.Fprintf(w, "::endgroup::\n\n")
+
+	// Log 'ok' requests
+	if len(ok) > 0 {
+		fmt.Fprintf(w, "::group::Conformant (ok) Responses (%d requests total)\n", len(ok))
+		for _, set := range ok {
+			fmt.Fprintf(w, "Conformant Response code `HTTP %d` for path `HTTP %s` `%s`%s\n", set.Response.StatusCode, strings.ToUpper(set.Request.Request.Method), set.Request.URL.Path, set.Request.VariantSuffix())
+			if len(set.Response.Body) > 0 {
+				fmt.Fprintf(w, "Body received:\n\n```\n%s\n```\n", set.Response.Body)
+			}
+			fmt.Fprintf(w, "\n")
+		}
+		fmt.Fprintf(w, "::endgroup::\n\n")
+	}
+
+	// For every suspicious request, drop a warning (or error)
+	if len(sus) > 0 {
+		fmt.Fprintf(w, "::%s title=Suspicious Responses::Suspicious (bad) Responses (%d requests total)\n", level, len(sus))
+		for _, bad := range sus {
+			fmt.Fprintf(w, "::%s title=Suspicious Response::Suspicious Response code `HTTP %d` for path `HTTP %s` `%s`%s%s\n", level, bad.Response.StatusCode, strings.ToUpper(bad.Request.Request.Method), bad.Request.URL.Path, bad.Request.VariantSuffix(), requestIDSuffix(bad.Request))
+			if len(bad.Response.Body) > 0 {
+				fmt.Fprintf(w, "::debug::Body received: %s\n", ghaEscape(bad.Response.Body))
+			}
+		}
+		fmt.Fprintf(w, "\n")
+	}
+
+	if summary == nil {
+		return
+	}
+
+	// Markdown job summary
+	printMarkdown(summary, requests, missed, sus, ok)
+}
+
+// Markdown output, such as for a job summary or pull request comment
+func printMarkdown(summary io.Writer, requests []*Request, missed map[string]uint64, sus, ok []Set) {
+	fmt.Fprintf(summary, "## Generator Results\n\n")
+	if len(requests) > 0 {
+		fmt.Fprintf(summary, "Server: `%s`\n\n", requests[0].Host)
+	}
+	fmt.Fprintf(summary, "| Verdict | Requests |\n| --- | --- |\n")
+	fmt.Fprintf(summary, "| Suspicious | %d |\n| Conformant | %d |\n\n", len(sus), len(ok))
+
+	if len(sus) > 0 {
+		fmt.Fprintf(summary, "### Suspicious Responses\n\n")
+		fmt.Fprintf(summary, "| Method | Path | HTTP Code | Request ID |\n| --- | --- | --- | --- |\n")
+		for _, bad := range sus {
+			fmt.Fprintf(summary, "| %s | `%s`%s | %d | `%s` |\n", strings.ToUpper(bad.Request.Request.Method), bad.Request.URL.Path, bad.Request.VariantSuffix(), bad.Response.StatusCode, requestID(bad.Request))
+		}
+		fmt.Fprintf(summary, "\n")
+	}
+
+	if len(missed) > 0 {
+		fmt.Fprintf(summary, "### Parameters Missed\n\n")
+		fmt.Fprintf(summary, "| Parameter | Times Missed |\n| --- | --- |\n")
+		for _, param := range missedNames(missed) {
+			fmt.Fprintf(summary, "| `%s` | %d |\n", param, missed[param])
+		}
+		fmt.Fprintf(summary, "\n")
+	}
+}
+
+// Escape a workflow command message as per GitHub Actions
+func ghaEscape(s string) string {
+	s = strings.ReplaceAll(s, "%", "%25")
+	s = strings.ReplaceAll(s, "\r", "%0D")
+	s = strings.ReplaceAll(s, "\n", "%0A")
+	return s
+}
+
+// JUnit XML-formatted output, one test case per replayed request
+// Suspicious responses are failures
+func printJUnit(w io.Writer, requests []*Request, sus, ok []Set) error {
+	type Failure struct {
+		Message string `xml:"message,attr"`
+		Body    string `xml:",chardata"`
+	}
+	type TestCase struct {
+		Name      string   `xml:"name,attr"`
+		ClassName string   `xml:"classname,attr"`
+		Time      string   `xml:"time,attr"`
+		Failure   *Failure `xml:"failure,omitempty"`
+	}
+	type TestSuite struct {
+		XMLName   xml.Name   `xml:"testsuite"`
+		Name      string     `xml:"name,attr"`
+		Tests     int        `xml:"tests,attr"`
+		Failures  int        `xml:"failures,attr"`
+		TestCases []TestCase `xml:"testcase"`
+	}
+
+	suite := TestSuite{
+		Name:     "generator",
+		Tests:    len(sus) + len(ok),
+		Failures: len(sus),
+	}
+	if len(requests) > 0 {
+		suite.Name += " " + requests[0].Host
+	}
+
+	testCase := func(set Set) TestCase {
+		return TestCase{
+			Name:      strings.ToUpper(set.Request.Request.Method) + " " + set.Request.URL.Path + set.Request.VariantSuffix(),
+			ClassName: set.Request.Method.OperationID,
+			Time:      strconv.FormatFloat(set.Response.Latency.Seconds(), 'f', 3, 64),
+		}
+	}
+
+	for _, bad := range sus {
+		tc := testCase(bad)
+		tc.Failure = &Failure{
+			Message: fmt.Sprintf("Suspicious response code HTTP %d%s", bad.Response.StatusCode, requestIDSuffix(bad.Request)),
+			Body:    bad.Response.Body,
+		}
+		if len(bad.Response.Failed) > 0 {
+			tc.Failure.Message = "Failed by hook → " + strings.Join(bad.Response.Failed, "; ")
+		}
+		suite.TestCases = append(suite.TestCases, tc)
+	}
+
+	for _, set := range ok {
+		suite.TestCases = append(suite.TestCases, testCase(set))
+	}
+
+	fmt.Fprint(w, xml.Header)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "\t")
+	err := enc.Encode(suite)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(w)
+
+	return nil
+}
+
+// ANSI escapes for terminal output
+const (
+	ansiReset  = "\x1b[0m"
+	ansiBold   = "\x1b[1m"
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+)
+
+// Human-readable summary output
+// If color is true, ANSI escapes are used for emphasis
+func printSummary(w io.Writer, color bool, requests []*Request, totalPossible uint64, missed map[string]uint64, sus, ok []Set) {
+	paint := func(code, s string) string {
+		if !color {
+			return s
+		}
+		return code + s + ansiReset
+	}
+
+	// How many would show in each top-N listing
+	const top = 5
+
+	fmt.Fprintln(w, paint(ansiBold, "Generator summary"))
+	if len(requests) > 0 {
+		fmt.Fprintf(w, "  Server:      %s\n", requests[0].Host)
+	}
+	fmt.Fprintf(w, "  Built:       %d/%d requests\n", len(requests), totalPossible)
+	fmt.Fprintf(w, "  Suspicious:  %s\n", paint(ansiRed, strconv.Itoa(len(sus))))
+	fmt.Fprintf(w, "  Conformant:  %s\n", paint(ansiGreen, strconv.Itoa(len(ok))))
+
+	// Most commonly missed parameters first
+	if len(missed) > 0 {
+		var names []string
+		for name := range missed {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool {
+			if missed[names[i]] == missed[names[j]] {
+				return names[i] < names[j]
+			}
+			return missed[names[i]] > missed[names[j]]
+		})
+		if len(names) > top {
+			names = names[:top]
+		}
+
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, paint(ansiBold, "Top missing parameters"))
+		for _, name := range names {
+			fmt.Fprintf(w, "  %-24s %s\n", name, paint(ansiYellow, fmt.Sprint(missed[name])))
+		}
+	}
+
+	// Suspicious results are what we're here for
+	if len(sus) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, paint(ansiBold, "Suspicious responses"))
+		for _, bad := range sus {
+			fmt.Fprintf(w, "  %s %-7s %s%s\n", paint(ansiRed, strconv.Itoa(bad.Response.StatusCode)), strings.ToUpper(bad.Request.Request.Method), bad.Request.URL.Path, bad.Request.VariantSuffix()+requestIDSuffix(bad.Request))
+		}
+	}
+
+	// Slowest endpoints first, unless timings weren't kept, as in a report of earlier runs
+	all := append(append([]Set{}, sus...), ok...)
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Response.Latency > all[j].Response.Latency
+	})
+	if len(all) > 0 && all[0].Response.Latency > 0 {
+		if len(all) > top {
+			all = all[:top]
+		}
+
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, paint(ansiBold, "Slowest endpoints"))
+		for _, set := range all {
+			fmt.Fprintf(w, "  %10s %-7s %s%s\n", set.Response.Latency.Round(time.Millisecond), strings.ToUpper(set.Request.Request.Method), set.Request.URL.Path, set.Request.VariantSuffix())
+		}
+	}
+}
+
+// Is the file a terminal (character device)?
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// Write each request as a raw HTTP file in a directory, plus an index manifest
+// Files are named by a hash of the method and URL, with the scheme proto, so runs diff cleanly
+func writeOutdir(dir, proto string, requests []*Request) error {
+	// Entry in the index manifest
+	type Item struct {
+		File    string
+		Method  string
+		URL     string
+		Variant string `json:",omitempty"`
+	}
+	var index []Item
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	names := requestFileNames(proto, requests)
+	for i, request := range requests {
+		err := ioutil.WriteFile(filepath.Join(dir, names[i]), []byte(prettyRequest(request.Request)), 0644)
+		if err != nil {
+			return err
+		}
+
+		index = append(index, Item{names[i], strings.ToUpper(request.Request.Method), requestURL(proto, request), request.Variant})
+	}
+
+	sort.Slice(index, func(i, j int) bool {
+		return index[i].File < index[j].File
+	})
+
+	buf, err := json.MarshalIndent(index, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(dir, "index.json"), append(buf, '\n'), 0644)
+}
+
+// File names for requests, by a hash of the method and URL, so runs diff cleanly
+func requestFileNames(proto string, requests []*Request) []string {
+	var names []string
+	seen := make(map[string]int)
+	for _, request := range requests {
+		method := strings.ToUpper(request.Request.Method)
+		sum := sha256.Sum256([]byte(method + " " + requestURL(proto, request)))
+		name := method + "-" + hex.EncodeToString(sum[:])[:12]
+
+		// The same call may be built more than once
+		seen[name]++
+		if n := seen[name]; n > 1 {
+			name += "-" + strconv.Itoa(n)
+		}
+		names = append(names, name+".http")
+	}
+
+	return names
+}
+
+// A request's URL with the scheme proto
+func requestURL(proto string, request *Request) string {
+	return proto + "://" + request.Host + request.URL.RequestURI()
+}
+
+// JSON encoder, optionally indented for humans
+func newEncoder(w io.Writer, indent bool) *json.Encoder {
+	enc := json.NewEncoder(w)
+	if indent {
+		enc.SetIndent("", "\t")
+	}
+
+	return enc
+}
+
 // Ingest a db file
-// Form of `someId=abc-123-098-def` one per line
-func ingestDb(name string) cfg.Cfg {
-	file, err := os.Open(name)
+// Form of `someId=abc-123-098-def` one per line, or JSON, YAML, or a SQLite table
+func ingestDb(ctx context.Context, name string) cfg.Cfg {
+	config, err := readDb(ctx, name)
 	if err != nil {
-		fatal(`err: could not open db file "`, name, `" →`, err)
+		fatal(`err: could not load db "`+name+`" →`, err)
 	}
 
-	config, err := cfg.Load(file)
+	err = expandDb(config)
 	if err != nil {
-		fatal("err: cfg could not load →", err)
+		fatal("err: could not resolve db value →", err)
 	}
 
 	return config
 }
 
+// Read a db file, URL, or SQLite table
+func readDb(ctx context.Context, name string) (cfg.Cfg, error) {
+	if strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://") {
+		return fetchDb(ctx, name)
+	}
+
+	if file, table, ok := sqliteDb(name); ok {
+		return loadSQLiteDb(file, table)
+	}
+
+	file, err := os.Open(name)
+	if err != nil {
+		return cfg.Cfg{}, err
+	}
+	defer file.Close()
+
+	return loadDb(file, name)
+}
+
+// Fetch a db over HTTP, with the -dbauth Authorization header, if any
+func fetchDb(ctx context.Context, url string) (cfg.Cfg, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return cfg.Cfg{}, err
+	}
+
+	if *dbAuth != "" {
+		authorization := *dbAuth
+		if !strings.Contains(authorization, " ") {
+			// A bare token
+			authorization = "Bearer " + authorization
+		}
+		req.Header.Set("Authorization", authorization)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return cfg.Cfg{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return cfg.Cfg{}, errors.New("db request responded " + resp.Status)
+	}
+
+	return loadDb(resp.Body, req.URL.Path)
+}
+
 // Fatal - end program with an error message and newline
 func fatal(s ...interface{}) {
-	fmt.Fprintln(os.Stderr, s...)
-	os.Exit(1)
+	die(exitError, s...)
+}
+
+// Die - end program with an exit code, error message, and newline
+func die(code int, s ...interface{}) {
+	// The message is logged as others are, such as to -artifacts
+	if stderr != nil {
+		fmt.Fprintln(stderr, s...)
+		stderr.Flush()
+	} else {
+		fmt.Fprintln(os.Stderr, s...)
+	}
+
+	// Outputs not yet whole are left as they were
+	for _, sink := range sinks {
+		sink.abandon()
+	}
+	os.Exit(code)
 }
 
 // Pretty logging of HTTP requests
@@ -221,12 +680,44 @@ func prettyRequest(r *http.Request) string {
 		return ""
 	}
 
-	return string(dump)
+	return redact(string(dump))
 }
 
-// Chatty emission
+// How much to log: -1 for -q, 0 by default, 1 for -v, and 2 for -vv or -D
+func verbosity() int {
+	switch {
+	case *quiet:
+		return -1
+	case *veryVerbose || *chatty:
+		return 2
+	case *verbose:
+		return 1
+	}
+
+	return 0
+}
+
+// Notes on what the run does, as per -v
 func chat(s ...interface{}) {
-	if !*chatty {
+	if verbosity() < 1 {
+		return
+	}
+
+	emit(s...)
+}
+
+// Traces of how each request is built, as per -vv
+func trace(s ...interface{}) {
+	if verbosity() < 2 {
+		return
+	}
+
+	emit(s...)
+}
+
+// Warnings, unless -q
+func warn(s ...interface{}) {
+	if verbosity() < 0 {
 		return
 	}
 
@@ -235,6 +726,6 @@ func chat(s ...interface{}) {
 
 // Stderr emission
 func emit(s ...interface{}) {
-	fmt.Fprintln(stderr, s...)
+	fmt.Fprint(stderr, redact(fmt.Sprintln(s...)))
 	stderr.Flush()
 }
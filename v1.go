@@ -0,0 +1,77 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Prefix of the versioned API, whose responses keep their schema and whose errors are JSON
+// The unversioned routes remain for existing callers
+const apiV1 = "/v1"
+
+// ErrorEnvelope is how the versioned API answers with an error
+type ErrorEnvelope struct {
+	Error ErrorBody `json:"error"`
+}
+
+// ErrorBody describes an error, Reason is stable for programs to act on
+type ErrorBody struct {
+	Status  int    `json:"status"`  // HTTP status
+	Reason  string `json:"reason"`  // Such as "bad_request" or "not_found"
+	Message string `json:"message"` // For humans, may change
+}
+
+// Reasons for errors, by HTTP status
+var errorReasons = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusNotAcceptable:         "not_acceptable",
+	http.StatusConflict:              "conflict",
+	http.StatusGone:                  "cancelled",
+	http.StatusRequestEntityTooLarge: "too_large",
+	http.StatusTooManyRequests:       "too_many_requests",
+	http.StatusInternalServerError:   "internal",
+	http.StatusBadGateway:            "replay_failed",
+	http.StatusServiceUnavailable:    "shutting_down",
+	http.StatusGatewayTimeout:        "timed_out",
+}
+
+// Is a request to the versioned API
+func isV1(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, apiV1+"/")
+}
+
+// The route of a request, without any version
+func route(r *http.Request) string {
+	if isV1(r) {
+		return strings.TrimPrefix(r.URL.Path, apiV1)
+	}
+	return r.URL.Path
+}
+
+// Where a route lives, in the version a request asked for
+func versioned(r *http.Request, path string) string {
+	if isV1(r) {
+		return apiV1 + path
+	}
+	return path
+}
+
+// Write an error as an envelope
+func writeEnvelope(w http.ResponseWriter, e *apiError) {
+	reason, ok := errorReasons[e.Code]
+	if !ok {
+		reason = "error"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Code)
+	json.NewEncoder(w).Encode(ErrorEnvelope{ErrorBody{e.Code, reason, e.Message}})
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2021, Microsoft Corporation, Sean Hinchee
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// How often -watch looks for changes
+const watchEvery = 500 * time.Millisecond
+
+// Set in the environment of each run -watch starts, which mustn't watch in turn
+const watchChildEnv = "GENERATOR_WATCH_CHILD"
+
+// Whether we're a run -watch started
+func watchChild() bool {
+	return os.Getenv(watchChildEnv) != ""
+}
+
+// Files a run reads, which -watch watches
+func watchedFiles() []string {
+	var files []string
+	for _, name := range []string{*apiName, *since, *dbName, *csvName, *tmplName, *configName} {
+		if name != "" && name != "-" && !strings.Contains(name, "://") {
+			files = append(files, name)
+		}
+	}
+	if *configName == "" {
+		if _, err := os.Stat(defaultConfig); err == nil {
+			files = append(files, defaultConfig)
+		}
+	}
+
+	return files
+}
+
+// When each file was last changed, and how big it is
+func fileStamps(files []string) map[string]string {
+	stamps := make(map[string]string)
+	for _, name := range files {
+		if info, err := os.Stat(name); err == nil {
+			stamps[name] = info.ModTime().String() + " " + strconv.FormatInt(info.Size(), 10)
+		}
+	}
+
+	return stamps
+}
+
+// Run, then run again whenever the spec, db, or config changes, until interrupted, as per -watch
+// Each run is its own process, so one which fails doesn't end the watch
+func watchRuns() int {
+	files := watchedFiles()
+	if len(files) < 1 {
+		emit("err: -watch has no local -api, -since, -db, -csv, -template, or config files to watch")
+		return exitError
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		emit("err: could not find ourselves to run again →", err)
+		return exitError
+	}
+	// Runs are as we were asked for, without watching
+	var args []string
+	for _, arg := range os.Args[1:] {
+		name := strings.SplitN(strings.TrimLeft(arg, "-"), "=", 2)[0]
+		if !strings.HasPrefix(arg, "-") || name != "watch" {
+			args = append(args, arg)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	stamps := fileStamps(files)
+	for {
+		cmd := exec.CommandContext(ctx, exe, args...)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		cmd.Env = append(os.Environ(), watchChildEnv+"=1")
+		err := cmd.Run()
+
+		var exit *exec.ExitError
+		switch {
+		case ctx.Err() != nil:
+			return exitClean
+		case errors.As(err, &exit):
+			warn("watch: run exited", exit.ExitCode())
+		case err != nil:
+			emit("err: could not run →", err)
+			return exitError
+		}
+		warn("watch: waiting for changes to " + strings.Join(files, ", ") + " …")
+
+		// Wait for a change, then for it to settle, as editors may write more than once
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				return exitClean
+			case <-time.After(watchEvery):
+			}
+
+			now := fileStamps(files)
+			for _, name := range files {
+				if now[name] != stamps[name] {
+					warn("watch: " + name + " changed, running again")
+					time.Sleep(watchEvery)
+					stamps = fileStamps(files)
+					break wait
+				}
+			}
+		}
+	}
+}